@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+// authorInput holds the fields of an author clients can write. Aliases are
+// added one at a time, as each may clash with another author.
+type authorInput struct {
+	Name string `json:"name"`
+	Bio  string `json:"bio"`
+}
+
+func (input authorInput) applyTo(author *model.Author) {
+	author.Name = input.Name
+	author.Bio = input.Bio
+}
+
+func inputFromAuthor(author *model.Author) authorInput {
+	return authorInput{Name: author.Name, Bio: author.Bio}
+}
+
+func (app *application) createAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	var input authorInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	author := &model.Author{}
+	input.applyTo(author)
+
+	v := validator.New()
+	if model.ValidateAuthor(v, author); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Authors.Insert(author)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateAlias):
+			v.Fail("name", "already_exists", "an author with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/authors/%d", author.ID))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"author": author}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showAuthorHandler serves an author's page: the author, the books they are
+// credited on and how the books they wrote are rated.
+func (app *application) showAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	author, err := app.models.Authors.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// The bibliography and stats change with the books and their reviews.
+	catalog, err := app.catalogState()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.notModified(w, r, entityTag(r, append([]interface{}{author.Version}, catalog...)...), time.Time{}) {
+		return
+	}
+	bibliography, err := app.models.Authors.Bibliography(author.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	stats, err := app.models.Authors.Stats(author.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"author": author, "bibliography": bibliography, "stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	author, err := app.models.Authors.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var input authorInput
+	err = app.readPatch(w, r, inputFromAuthor(author), &input)
+	if err != nil {
+		app.patchErrorResponse(w, r, err)
+		return
+	}
+	input.applyTo(author)
+
+	v := validator.New()
+	if model.ValidateAuthor(v, author); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Authors.Update(author)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateAlias):
+			v.Fail("name", "already_exists", "an author with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	author, err = app.models.Authors.Get(author.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"author": author}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addAuthorAliasHandler records another name an author is known by, so that
+// books carrying it in their byline are credited to them.
+func (app *application) addAuthorAliasHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Name string `json:"name"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if v.Var("name", input.Name, "required,max=200"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Authors.AddAlias(id, input.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrDuplicateAlias):
+			v.Fail("name", "already_exists", "an author with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	author, err := app.models.Authors.Get(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"author": author}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setBookAuthorsHandler replaces the people credited on a book, in order.
+func (app *application) setBookAuthorsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Authors []*model.Credit `json:"authors"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if model.ValidateCredits(v, input.Authors); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Authors.SetCredits(id, input.Authors, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrUnknownAuthor):
+			v.Fail("authors", "unknown_author", "must only credit existing authors")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrAuthorNotFirst):
+			v.Fail("authors", "author_not_first", "must list an author before anyone else")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	credits, err := app.models.Authors.GetCredits([]int64{id})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	bookCredits := credits[id]
+	if bookCredits == nil {
+		bookCredits = []*model.Credit{}
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"authors": bookCredits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+)
+
+func TestShowAuthor(t *testing.T) {
+	app, db := newTestApplication(t)
+	stubFreshness(db)
+	db.On("FROM authors", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"id", "name", "bio", "version", "updated_at", "array"},
+			Rows:    [][]driver.Value{{int64(3), "Frank Herbert", "", int64(1), stubUpdatedAt, `{"Frank Herbert","Franklin Herbert"}`}},
+		}
+	})
+	db.On("array_agg(book_authors.role", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"id", "title", "year", "roles"},
+			Rows:    [][]driver.Value{{int64(1), "Dune", int64(1965), "{author}"}},
+		}
+	})
+	db.On("count(DISTINCT books.id)", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"books", "reviews", "avg"}, Rows: [][]driver.Value{{int64(1), int64(2), "4.5"}}}
+	})
+
+	res, body := serve(t, app, model.AnonymousUser, "GET", "/api/v1/authors/3", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v", res.StatusCode, body)
+	}
+	author := body["author"].(map[string]interface{})
+	bibliography := body["bibliography"].([]interface{})
+	if aliases := author["aliases"].([]interface{}); len(aliases) != 2 || len(bibliography) != 1 {
+		t.Errorf("got %v", body)
+	}
+	if stats := body["stats"].(map[string]interface{}); stats["reviews"] != float64(2) {
+		t.Errorf("got stats %v", stats)
+	}
+	if res.Header.Get("ETag") == "" {
+		t.Error("no ETag on the author's page")
+	}
+}
+
+func TestSetBookAuthorsValidatesCredits(t *testing.T) {
+	tests := []struct {
+		body, code string
+	}{
+		{`{"authors": [{"author_id": 1, "role": "ghostwriter"}]}`, "not_allowed"},
+		{`{"authors": [{"author_id": 404, "role": "author"}]}`, "unknown_author"},
+	}
+	for _, tt := range tests {
+		app, db := newTestApplication(t, "books:write")
+		db.On("set_config", func([]driver.NamedValue) stubResult { return stubResult{} })
+		db.On("DELETE FROM book_authors", func([]driver.NamedValue) stubResult { return stubResult{} })
+		db.On("INSERT INTO book_authors", func([]driver.NamedValue) stubResult {
+			return stubResult{Err: errors.New(`pq: insert or update on table "book_authors" violates foreign key constraint "book_authors_author_id_fkey"`)}
+		})
+		res, body := serve(t, app, activatedUser, "PUT", "/api/v1/books/1/authors", tt.body)
+		params, _ := body["invalid_params"].([]interface{})
+		if res.StatusCode != http.StatusUnprocessableEntity || len(params) != 1 || params[0].(map[string]interface{})["code"] != tt.code {
+			t.Errorf("%s: got %d %v, want 422 %s", tt.body, res.StatusCode, body, tt.code)
+		}
+	}
+}
+
+func TestSetBookAuthorsRequiresAnAuthorFirst(t *testing.T) {
+	app, _ := newTestApplication(t, "books:write")
+	body := `{"authors": [{"author_id": 2, "role": "translator"}, {"author_id": 1, "role": "author"}]}`
+	res, js := serve(t, app, activatedUser, "PUT", "/api/v1/books/1/authors", body)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("got %d %v, want 422", res.StatusCode, js)
+	}
+	params, _ := js["invalid_params"].([]interface{})
+	if len(params) != 1 || params[0].(map[string]interface{})["code"] != "author_not_first" {
+		t.Errorf("got invalid_params %v, want author_not_first", params)
+	}
+}
+
+func TestCreateAuthorReportsATakenName(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	db.On("INSERT INTO authors", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"id", "version", "updated_at"}, Rows: [][]driver.Value{{int64(3), int64(1), time.Now()}}}
+	})
+	// The name is already an alias of author 1.
+	db.On("INSERT INTO author_aliases", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"author_id"}, Rows: [][]driver.Value{{int64(1)}}}
+	})
+	res, js := serve(t, app, activatedUser, "POST", "/api/v1/authors", `{"name": "Terry Pratchett"}`)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("got %d %v, want 422", res.StatusCode, js)
+	}
+	params, _ := js["invalid_params"].([]interface{})
+	want := map[string]interface{}{"name": "name", "code": "already_exists", "reason": "an author with this name already exists"}
+	if len(params) != 1 || !reflect.DeepEqual(params[0], want) {
+		t.Errorf("got invalid_params %v, want [%v]", params, want)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/shyndaliu/capybook/pkg/capybook/model"
 	"github.com/shyndaliu/capybook/pkg/capybook/validator"
@@ -13,32 +15,61 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 	data := map[string]string{
 		"status": "Hello! Welcome to Capybook API",
 	}
-	err := app.writeJSON(w, http.StatusOK, data, nil)
+	err := app.writeJSON(w, r, http.StatusOK, data, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request) {
-	var input struct {
-		Title       string   `json:"title"`
-		Author      string   `json:"author"`
-		Year        int32    `json:"year"`
-		Description string   `json:"description"`
-		Genres      []string `json:"genres"`
+// bookInput holds the fields of a book clients can write. It is what books
+// are created and imported from, and the document PATCH requests apply to.
+type bookInput struct {
+	Title       string   `json:"title"`
+	Author      string   `json:"author"`
+	Year        int32    `json:"year"`
+	Description string   `json:"description"`
+	Genres      []string `json:"genres"`
+	Language    string   `json:"language"`
+}
+
+// newBook creates a book from input, in English unless it says otherwise.
+func (input bookInput) newBook() *model.Book {
+	book := &model.Book{}
+	input.applyTo(book)
+	if book.Language == "" {
+		book.Language = "english"
 	}
+	return book
+}
+
+func (input bookInput) applyTo(book *model.Book) {
+	book.Title = input.Title
+	book.Author = input.Author
+	book.Year = input.Year
+	book.Description = input.Description
+	book.Genres = input.Genres
+	book.Language = input.Language
+}
+
+func inputFromBook(book *model.Book) bookInput {
+	return bookInput{
+		Title:       book.Title,
+		Author:      book.Author,
+		Year:        book.Year,
+		Description: book.Description,
+		Genres:      book.Genres,
+		Language:    book.Language,
+	}
+}
+
+func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request) {
+	var input bookInput
 	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
-	book := &model.Book{
-		Title:       input.Title,
-		Author:      input.Author,
-		Year:        input.Year,
-		Description: input.Description,
-		Genres:      input.Genres,
-	}
+	book := input.newBook()
 
 	v := validator.New()
 	if model.ValidateBook(v, book); !v.Valid() {
@@ -46,7 +77,7 @@ func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Books.Insert(book)
+	err = app.models.Books.Insert(book, app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -54,44 +85,122 @@ func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/api/v1/books/%d", book.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"book": book}, headers)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"book": book}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 func (app *application) listBooksHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Author string
-		Genres []string
+		model.BookQuery
 		model.Filters
+		Facets  []string
+		Fields  []string
+		Include []string
+		Format  string
 	}
 	v := validator.New()
 	qs := r.URL.Query()
 
+	input.Search = app.readString(qs, "q", "")
+	input.Language = app.readString(qs, "lang", "english")
 	input.Title = app.readString(qs, "title", "")
 	input.Author = app.readString(qs, "author", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.Subgenres = app.readBool(qs, "subgenres", false, v)
+	input.Pending = app.readBool(qs, "pending", false, v)
+	input.Series = int64(app.readInt(qs, "series", 0, v))
+	for _, name := range app.readCSV(qs, "tags", []string{}) {
+		input.Tags = append(input.Tags, model.TagKey(name))
+	}
+	input.Facets = app.readCSV(qs, "facets", []string{})
+	input.Fields = app.readFieldList(qs, "fields", jsonFields(model.Book{}), v)
+	input.Include = app.readFieldList(qs, "include", bookIncludes, v)
+	input.Format = app.readListFormat(w, r, v)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.Limit = app.readInt(qs, "limit", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "author", "-id", "-title", "-year", "author"}
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	// Searches are ordered by relevance unless the client asks otherwise.
+	defaultSort := "id"
+	if input.Search != "" {
+		defaultSort = "-rank"
+	}
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
+	input.Filters.SortSafelist = []string{"id", "title", "year", "author", "-id", "-title", "-year", "-author", "-rank"}
 
+	v.Var("lang", input.Language, "searchlang")
+	v.Var("series", input.Series, "min=0")
+	v.Var("tags", input.Tags, "max=5,dive,required")
+	v.Var("facets", input.Facets, "dive,oneof="+strings.Join(model.FacetNames, " "))
+	model.ValidateBookQuery(v, input.BookQuery)
 	model.ValidateFilters(v, input.Filters)
 
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	state, err := app.catalogState()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.notModified(w, r, entityTag(r, append(state, input.Format)...), time.Time{}) {
+		return
+	}
 	// Call the GetAll() method to retrieve the movies, passing in the various filter
 	// parameters.
-	books, err := app.models.Books.GetAll(input.Title, input.Author, input.Genres, input.Filters)
+	books, metadata, err := app.models.Books.GetAll(input.BookQuery, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	env := envelope{"books": books, "metadata": metadata}
+	if len(input.Fields) > 0 || len(input.Include) > 0 {
+		resources, err := app.projectBooks(books, input.Fields, input.Include)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["books"] = resources
+	}
+	if len(input.Facets) > 0 {
+		facets, err := app.models.Books.Facets(input.BookQuery, input.Facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["facets"] = facets
+	}
+	headers := make(http.Header)
+	if links := app.paginationLinks(r, metadata); links != "" {
+		headers.Set("Link", links)
+	}
+	columns := listColumns(model.Book{}, input.Fields, input.Include)
+	err = app.writeList(w, r, input.Format, env, "books", columns, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+func (app *application) autocompleteBooksHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	prefix := strings.TrimSpace(app.readString(qs, "prefix", ""))
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Var("prefix", prefix, "required,max=100")
+	v.Var("limit", limit, "min=1,max=20")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Books.Autocomplete(prefix, limit)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// Send a JSON response containing the movie data.
-	err = app.writeJSON(w, http.StatusOK, envelope{"books": books}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"suggestions": suggestions}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -102,17 +211,57 @@ func (app *application) getBookHandler(w http.ResponseWriter, r *http.Request) {
 		app.notFoundResponse(w, r)
 		return
 	}
+	v := validator.New()
+	qs := r.URL.Query()
+	fields := app.readFieldList(qs, "fields", jsonFields(model.Book{}), v)
+	include := app.readFieldList(qs, "include", bookIncludes, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 	book, err := app.models.Books.Get(id)
+	if errors.Is(err, model.ErrRecordNotFound) {
+		app.redirectMergedBook(w, r, id)
+		return
+	}
 	if err != nil {
-		switch {
-		case errors.Is(err, model.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	state := []interface{}{book.Version}
+	lastModified := book.UpdatedAt
+	// The title of the series comes from the series, which is renamed without
+	// the book changing.
+	if book.Series != nil {
+		state = append(state, book.Series.Version)
+		if book.Series.UpdatedAt.After(lastModified) {
+			lastModified = book.Series.UpdatedAt
+		}
+	}
+	// Embedded reviews, author stats, editions and authors change without the
+	// book changing.
+	if len(include) > 0 {
+		catalog, err := app.catalogState()
+		if err != nil {
 			app.serverErrorResponse(w, r, err)
+			return
 		}
+		state = append(state, catalog...)
+		lastModified = time.Time{}
+	}
+	if app.notModified(w, r, entityTag(r, state...), lastModified) {
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	env := envelope{"book": book}
+	if len(fields) > 0 || len(include) > 0 {
+		resources, err := app.projectBooks([]*model.Book{book}, fields, include)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["book"] = resources[0]
+	}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -133,40 +282,33 @@ func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
-	var input struct {
-		Title       *string  `json:"title"`
-		Author      *string  `json:"author"`
-		Year        *int32   `json:"year"`
-		Description *string  `json:"description"`
-		Genres      []string `json:"genres"`
-	}
-	err = app.readJSON(w, r, &input)
+	// The patch is applied to the writable fields of the book, so removing a
+	// member clears it and "/genres/-" appends a single genre.
+	var input bookInput
+	err = app.readPatch(w, r, inputFromBook(book), &input)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.patchErrorResponse(w, r, err)
 		return
 	}
-	if input.Title != nil {
-		book.Title = *input.Title
-	}
-	if input.Author != nil {
-		book.Author = *input.Author
-	}
-	if input.Year != nil {
-		book.Year = *input.Year
-	}
-	if input.Description != nil {
-		book.Description = *input.Description
-	}
-	if input.Genres != nil {
-		book.Genres = input.Genres
+	input.applyTo(book)
+
+	v := validator.New()
+	if model.ValidateBook(v, book); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
 
-	newbook, err := app.models.Books.Update(book)
+	newbook, err := app.models.Books.Update(book, app.contextGetUser(r).ID)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": newbook}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": newbook}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -177,7 +319,7 @@ func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request
 		app.notFoundResponse(w, r)
 		return
 	}
-	err = app.models.Books.Delete(id)
+	err = app.models.Books.Delete(id, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrRecordNotFound):
@@ -187,7 +329,7 @@ func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+)
+
+// stubBookColumns are the columns of a book as the book model reads them, and
+// stubListColumns those of a book in a listing.
+var (
+	stubBookColumns = []string{"id", "title", "author", "year", "description", "genres", "language", "version", "updated_at", "pending", "series", "cover_url"}
+	stubListColumns = append(append([]string(nil), stubBookColumns...), "sort", "title", "description", "count")
+)
+
+var stubUpdatedAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// stubBook is the row of a stubbed book.
+func stubBook(id int64, title, author string) []driver.Value {
+	return []driver.Value{id, title, author, int64(1965), "A desert planet.", "{novel}", "english", int64(1), stubUpdatedAt, false, nil, nil}
+}
+
+// stubListed is the row of a stubbed book in a listing of total books.
+func stubListed(book []driver.Value, sortKey string, total int64) []driver.Value {
+	return append(book, sortKey, "", "", total)
+}
+
+// stubFreshness answers the freshness queries behind cached responses. It has
+// to come before any stub that would also match them.
+func stubFreshness(db *stubDB) {
+	db.On("FROM catalog_version", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"version"}, Rows: [][]driver.Value{{int64(1)}}}
+	})
+	db.On("coalesce(max(updated_at), 'epoch')", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"count", "max"}, Rows: [][]driver.Value{{int64(1), stubUpdatedAt}}}
+	})
+}
+
+func TestSearchBooks(t *testing.T) {
+	app, db := newTestApplication(t)
+	stubFreshness(db)
+	var search interface{}
+	db.On("FROM books", func(args []driver.NamedValue) stubResult {
+		search = args[0].Value
+		return stubResult{
+			Columns: stubListColumns,
+			Rows:    [][]driver.Value{append(stubBook(1, "War and Peace", "Leo Tolstoy"), "0.5", "<mark>War</mark> and Peace", "A novel", int64(1))},
+		}
+	})
+	res, body := serve(t, app, model.AnonymousUser, "GET", "/api/v1/books?q=war", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v, want 200", res.StatusCode, body)
+	}
+	if search != "war:*" {
+		t.Errorf("searched for %v, want war:*", search)
+	}
+	books := body["books"].([]interface{})
+	highlight, _ := books[0].(map[string]interface{})["highlight"].(map[string]interface{})
+	if highlight["title"] != "<mark>War</mark> and Peace" {
+		t.Errorf("got highlight %v, want the marked title", highlight)
+	}
+}
+
+func TestSearchBooksRejectsUnsupportedLanguages(t *testing.T) {
+	app, _ := newTestApplication(t)
+	res, body := serve(t, app, model.AnonymousUser, "GET", "/api/v1/books?q=war&lang=klingon", "")
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got %d %v, want 422", res.StatusCode, body)
+	}
+}
+
+// A search of nothing but punctuation has no terms left to look for, and
+// would otherwise list the whole catalog.
+func TestSearchBooksRejectsSearchesWithoutTerms(t *testing.T) {
+	app, _ := newTestApplication(t)
+	res, body := serve(t, app, model.AnonymousUser, "GET", "/api/v1/books?q=%21%3F", "")
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got %d %v, want 422", res.StatusCode, body)
+	}
+}
+
+func TestAutocompleteBooks(t *testing.T) {
+	app, db := newTestApplication(t)
+	db.On("AS suggestions", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"value", "kind", "book_id", "score"},
+			Rows:    [][]driver.Value{{"Dune", "title", int64(1), 1.8}},
+		}
+	})
+	res, body := serve(t, app, model.AnonymousUser, "GET", "/api/v1/books/autocomplete?prefix=dun", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v, want 200", res.StatusCode, body)
+	}
+	suggestions := body["suggestions"].([]interface{})
+	if len(suggestions) != 1 || suggestions[0].(map[string]interface{})["value"] != "Dune" {
+		t.Errorf("got %v, want Dune", suggestions)
+	}
+}
+
+func TestAutocompleteBooksValidatesInput(t *testing.T) {
+	app, _ := newTestApplication(t)
+	for _, path := range []string{
+		"/api/v1/books/autocomplete",
+		"/api/v1/books/autocomplete?prefix=%20%20",
+		"/api/v1/books/autocomplete?prefix=dun&limit=21",
+		"/api/v1/books/autocomplete?prefix=dun&limit=0",
+	} {
+		res, body := serve(t, app, model.AnonymousUser, "GET", path, "")
+		if res.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("GET %s: got %d %v, want 422", path, res.StatusCode, body)
+		}
+	}
+}
+
+func TestListBooksFacets(t *testing.T) {
+	app, db := newTestApplication(t)
+	stubFreshness(db)
+	db.On("SELECT 'rating'", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"name", "value", "count"},
+			Rows:    [][]driver.Value{{"rating", "4", int64(5)}, {"rating", "unrated", int64(1)}},
+		}
+	})
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{}
+	})
+	res, body := serve(t, app, model.AnonymousUser, "GET", "/api/v1/books?facets=rating", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v, want 200", res.StatusCode, body)
+	}
+	facets, _ := body["facets"].(map[string]interface{})
+	if ratings, _ := facets["rating"].([]interface{}); len(ratings) != 2 {
+		t.Errorf("got facets %v, want two rating buckets", body["facets"])
+	}
+
+	res, body = serve(t, app, model.AnonymousUser, "GET", "/api/v1/books", "")
+	if _, ok := body["facets"]; res.StatusCode != http.StatusOK || ok {
+		t.Errorf("got %d %v, want no facets unless asked for", res.StatusCode, body)
+	}
+
+	res, body = serve(t, app, model.AnonymousUser, "GET", "/api/v1/books?facets=publisher", "")
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got %d %v for an unknown facet, want 422", res.StatusCode, body)
+	}
+}
+
+func TestListBooksLinksToOtherPages(t *testing.T) {
+	app, db := newTestApplication(t)
+	stubFreshness(db)
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: stubListColumns,
+			Rows:    [][]driver.Value{stubListed(stubBook(2, "Emma", "Jane Austen"), "2", 3)},
+		}
+	})
+	res, body := serve(t, app, model.AnonymousUser, "GET", "/api/v1/books?genres=novel&page=2&limit=1", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v, want 200", res.StatusCode, body)
+	}
+	want := `</api/v1/books?genres=novel&limit=1&page=1>; rel="first", ` +
+		`</api/v1/books?genres=novel&limit=1&page=1>; rel="prev", ` +
+		`</api/v1/books?genres=novel&limit=1&page=3>; rel="next", ` +
+		`</api/v1/books?genres=novel&limit=1&page=3>; rel="last"`
+	if got := res.Header.Get("Link"); got != want {
+		t.Errorf("got Link %s, want %s", got, want)
+	}
+	metadata := body["metadata"].(map[string]interface{})
+	if metadata["total_records"] != 3.0 || metadata["last_page"] != 3.0 {
+		t.Errorf("got metadata %v, want 3 records on 3 pages", metadata)
+	}
+}
+
+func TestListBooksByCursorLinksToTheNextPage(t *testing.T) {
+	app, db := newTestApplication(t)
+	stubFreshness(db)
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: stubListColumns,
+			Rows: [][]driver.Value{
+				stubListed(stubBook(4, "Emma", "Jane Austen"), "4", 3),
+				stubListed(stubBook(5, "Dune", "Frank Herbert"), "5", 3),
+			},
+		}
+	})
+	res, body := serve(t, app, model.AnonymousUser, "GET", "/api/v1/books?limit=1", "")
+	next, _ := body["metadata"].(map[string]interface{})["next_cursor"].(string)
+	if res.StatusCode != http.StatusOK || next == "" {
+		t.Fatalf("got %d %v, want 200 and a next cursor", res.StatusCode, body)
+	}
+	res, body = serve(t, app, model.AnonymousUser, "GET", "/api/v1/books?limit=1&cursor="+next, "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v, want 200", res.StatusCode, body)
+	}
+	if books := body["books"].([]interface{}); len(books) != 1 {
+		t.Errorf("got %d books, want the extra row dropped", len(books))
+	}
+	link := res.Header.Get("Link")
+	if !strings.Contains(link, `rel="next"`) || strings.Contains(link, "page=") || strings.Contains(link, `rel="last"`) {
+		t.Errorf("got Link %s, want only a next link by cursor", link)
+	}
+
+	res, body = serve(t, app, model.AnonymousUser, "GET", "/api/v1/books?limit=1&sort=title&cursor="+next, "")
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got %d %v for a cursor of another sort, want 422", res.StatusCode, body)
+	}
+}
+
+// patchBook sends a PATCH for book 1 with the given media type and body.
+func patchBook(t *testing.T, app *application, contentType, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	r := httptest.NewRequest("PATCH", "/api/v1/books/1", strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, app.contextSetUser(r, activatedUser))
+	var js map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &js)
+	return w, js
+}
+
+func TestPatchBook(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		wantGenres  string
+		wantYear    int64
+	}{
+		{"application/json", `{"year": 1966}`, `{"novel"}`, 1966},
+		{"application/merge-patch+json", `{"genres": ["sf"], "year": null}`, "", 0},
+		{"application/json-patch+json", `[{"op": "add", "path": "/genres/-", "value": "sf"}]`, `{"novel","sf"}`, 1965},
+	}
+	for _, tt := range tests {
+		app, db := newTestApplication(t, "books:write")
+		db.On("set_config", func([]driver.NamedValue) stubResult { return stubResult{} })
+		var updated []driver.NamedValue
+		db.On("UPDATE books", func(args []driver.NamedValue) stubResult {
+			updated = args
+			// The author is unchanged, so the byline keeps its credit.
+			return stubResult{
+				Columns: append(stubBookColumns, "author"),
+				Rows:    [][]driver.Value{append(stubBook(1, "Dune", "Frank Herbert"), "Frank Herbert")},
+			}
+		})
+		db.On("FROM books", func([]driver.NamedValue) stubResult {
+			return stubResult{
+				Columns: stubBookColumns,
+				Rows:    [][]driver.Value{stubBook(1, "Dune", "Frank Herbert")},
+			}
+		})
+		w, body := patchBook(t, app, tt.contentType, tt.body)
+		if tt.wantYear == 0 {
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Errorf("%s %s: got %d %v, want 422 for a removed year", tt.contentType, tt.body, w.Code, body)
+			}
+			continue
+		}
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: got %d %v, want 200", tt.contentType, tt.body, w.Code, body)
+		}
+		genres, _ := updated[4].Value.(string)
+		if genres != tt.wantGenres || updated[2].Value != tt.wantYear {
+			t.Errorf("%s %s: stored genres %v and year %v, want %s and %d", tt.contentType, tt.body, updated[4].Value, updated[2].Value, tt.wantGenres, tt.wantYear)
+		}
+	}
+}
+
+func TestPatchBookRejectsBadPatches(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: stubBookColumns,
+			Rows:    [][]driver.Value{stubBook(1, "Dune", "Frank Herbert")},
+		}
+	})
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"text/plain", `{"year": 1966}`, http.StatusUnsupportedMediaType},
+		{"application/json-patch+json", `[{"op": "remove", "path": "/isbn"}]`, http.StatusBadRequest},
+		{"application/json-patch+json", `[{"op": "test", "path": "/year", "value": 1999}]`, http.StatusConflict},
+		{"application/merge-patch+json", `{"isbn": "x"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w, body := patchBook(t, app, tt.contentType, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s %s: got %d %v, want %d", tt.contentType, tt.body, w.Code, body, tt.status)
+		}
+	}
+	if logged := db.Logged("UPDATE books"); len(logged) != 0 {
+		t.Errorf("a rejected patch updated the book")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// entityTag derives a strong entity tag from the request URL and the state the
+// response is built from, such as record versions. Equal state and URL always
+// produce the same body, so the body itself never has to be hashed. State is
+// formatted with %v, so it must be values: a pointer would hash its address.
+func entityTag(r *http.Request, state ...interface{}) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s?%s", r.URL.Path, r.URL.RawQuery)
+	for _, s := range state {
+		fmt.Fprintf(h, "\x00%v", s)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// catalogState returns the version of the catalog, which moves on every change
+// to a book, review, edition, author, series, genre or tag, for responses that
+// can be affected by changes to any of them.
+func (app *application) catalogState() ([]interface{}, error) {
+	version, err := app.models.Catalog.Version()
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{version}, nil
+}
+
+// notModified sets the validators and Cache-Control header of a successful read
+// and reports whether the copy the client already has is current, in which case
+// a 304 has been sent and the handler should return without building the body.
+// A zero lastModified leaves out Last-Modified, for responses whose rows can be
+// deleted without anything recording when.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", app.cacheControl(r))
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-Modified-Since is only looked at when there is no If-None-Match.
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		if !etagMatches(header, etag) {
+			return false
+		}
+	} else if header := r.Header.Get("If-Modified-Since"); header != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(header)
+		if err != nil || lastModified.Truncate(time.Second).After(since) {
+			return false
+		}
+	} else {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using the weak
+// comparison RFC 7232 prescribes for it.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheControl lets browsers and CDNs reuse responses to anonymous requests for
+// the configured times. Responses to authenticated requests may only be kept by
+// the client, and must be revalidated.
+func (app *application) cacheControl(r *http.Request) string {
+	if !app.contextGetUser(r).IsAnonymous() {
+		return "private, no-cache"
+	}
+	return fmt.Sprintf("public, max-age=%d, s-maxage=%d",
+		int(app.config.cache.maxAge.Seconds()), int(app.config.cache.sharedMaxAge.Seconds()))
+}
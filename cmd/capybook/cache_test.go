@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+)
+
+func TestEntityTag(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/v1/books/1?fields=title", nil)
+	etag := entityTag(r, int64(3))
+	if etag != entityTag(r, int64(3)) {
+		t.Error("the same state gave different tags")
+	}
+	if etag == entityTag(r, int64(4)) {
+		t.Error("a new version kept the tag")
+	}
+	other := httptest.NewRequest("GET", "/api/v1/books/1?fields=author", nil)
+	if etag == entityTag(other, int64(3)) {
+		t.Error("a different query kept the tag")
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`*`, true},
+		{`"xyz"`, false},
+		{`abc`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, `"abc"`); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestGetBookIsConditional(t *testing.T) {
+	app, db := newTestApplication(t)
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: stubBookColumns, Rows: [][]driver.Value{stubBook(1, "Dune", "Frank Herbert")}}
+	})
+	get := func(header, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/v1/books/1", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, app.contextSetUser(r, model.AnonymousUser))
+		return w
+	}
+
+	first := get("", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got %d with ETag %q, want 200 with a tag", first.Code, etag)
+	}
+	if got, want := first.Header().Get("Last-Modified"), stubUpdatedAt.Format(http.TimeFormat); got != want {
+		t.Errorf("Last-Modified = %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		header, value string
+		want          int
+	}{
+		{"If-None-Match", etag, http.StatusNotModified},
+		{"If-None-Match", `"stale"`, http.StatusOK},
+		{"If-Modified-Since", stubUpdatedAt.Format(http.TimeFormat), http.StatusNotModified},
+		{"If-Modified-Since", stubUpdatedAt.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := get(tt.header, tt.value)
+		if w.Code != tt.want {
+			t.Errorf("%s: %s: got %d, want %d", tt.header, tt.value, w.Code, tt.want)
+		}
+		if tt.want == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("%s: %s: a 304 carried a body", tt.header, tt.value)
+		}
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	app, _ := newTestApplication(t)
+	app.config.cache.maxAge = 30 * time.Second
+	app.config.cache.sharedMaxAge = time.Minute
+	r := httptest.NewRequest("GET", "/api/v1/books/1", nil)
+
+	if got, want := app.cacheControl(app.contextSetUser(r, model.AnonymousUser)), "public, max-age=30, s-maxage=60"; got != want {
+		t.Errorf("anonymous: got %q, want %q", got, want)
+	}
+	if got, want := app.cacheControl(app.contextSetUser(r, activatedUser)), "private, no-cache"; got != want {
+		t.Errorf("authenticated: got %q, want %q", got, want)
+	}
+}
+
+func TestErrorsAreNotCacheable(t *testing.T) {
+	app, _ := newTestApplication(t)
+	r := httptest.NewRequest("GET", "/api/v1/books/1", nil)
+	w := httptest.NewRecorder()
+	w.Header().Set("ETag", `"abc"`)
+	w.Header().Set("Last-Modified", stubUpdatedAt.Format(http.TimeFormat))
+	app.notFoundResponse(w, r)
+
+	if w.Header().Get("ETag") != "" || w.Header().Get("Last-Modified") != "" {
+		t.Errorf("the error kept its validators: %v", w.Header())
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+}
+
+// An update made against a version someone else has already replaced matches
+// no row, and is reported as a conflict rather than lost.
+func TestPatchBookReportsEditConflicts(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	db.On("set_config", func([]driver.NamedValue) stubResult { return stubResult{} })
+	db.On("UPDATE books", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: stubBookColumns}
+	})
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: stubBookColumns, Rows: [][]driver.Value{stubBook(1, "Dune", "Frank Herbert")}}
+	})
+	w, body := patchBook(t, app, "application/json", `{"year": 1966}`)
+	if w.Code != http.StatusConflict || body["code"] != codeEditConflict {
+		t.Errorf("got %d %v, want 409 %s", w.Code, body, codeEditConflict)
+	}
+}
+
+// Revalidating a listing costs one lookup of the catalog version, whatever the
+// size of the catalog.
+func TestListBooksRevalidatesWithTheCatalogVersion(t *testing.T) {
+	app, db := newTestApplication(t)
+	version := int64(41)
+	db.On("FROM catalog_version", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"version"}, Rows: [][]driver.Value{{version}}}
+	})
+	get := func(etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/v1/books?title=dune", nil)
+		r.Header.Set("If-None-Match", etag)
+		r = app.contextSetUser(r, model.AnonymousUser)
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, r)
+		return w
+	}
+
+	first := get("*")
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag on the listing")
+	}
+	before := len(db.Statements())
+	if res := get(etag); res.Code != http.StatusNotModified {
+		t.Fatalf("got %d, want 304", res.Code)
+	}
+	if got := db.Statements()[before:]; len(got) != 1 {
+		t.Errorf("revalidating ran %d statements, want the catalog version only: %v", len(got), got)
+	}
+
+	version++
+	if res := get(etag); res.Code == http.StatusNotModified {
+		t.Error("the ETag did not change with the catalog version")
+	}
+}
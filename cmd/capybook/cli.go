@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/i18n"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+// runCommand runs a one-off command given after the server flags instead of
+// starting the server, e.g.
+//
+//	capybook export books -format csv -o books.csv
+//	capybook import books -dry-run books.csv
+//	capybook duplicates
+//	capybook purge
+func (app *application) runCommand(args []string) error {
+	switch args[0] {
+	case "export":
+		return app.exportCommand(args[1:])
+	case "import":
+		return app.importCommand(args[1:])
+	case "duplicates":
+		return app.duplicatesCommand(args[1:])
+	case "purge":
+		return app.purgeCommand(args[1:])
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// exportCommand writes a whole dataset to a file or to stdout, the same way
+// GET /api/v1/export/{dataset} streams it but without the server's timeouts.
+func (app *application) exportCommand(args []string) error {
+	usage := fmt.Sprintf("usage: capybook export {%s} [-format %s] [-o file]",
+		strings.Join(exportDatasets, "|"), strings.Join(exportFormats, "|"))
+	if len(args) == 0 || !validator.In(args[0], exportDatasets...) {
+		return errors.New(usage)
+	}
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", formatNDJSON, "Output format ("+strings.Join(exportFormats, "|")+")")
+	output := fs.String("o", "", "File to write to instead of standard output")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if !validator.In(*format, exportFormats...) {
+		return errors.New(usage)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	if err := app.export(context.Background(), bw, args[0], *format, nil); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if f, ok := w.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
+
+// importCommand imports books from a file, or from stdin when the file is "-",
+// like POST /api/v1/books/import, and prints the report to stdout. The format
+// follows the file extension unless -format is given.
+func (app *application) importCommand(args []string) error {
+	usage := "usage: capybook import books [-format csv|ndjson] [-dry-run] file"
+	if len(args) == 0 || args[0] != "books" {
+		return errors.New(usage)
+	}
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "", "Input format (csv|ndjson)")
+	dryRun := fs.Bool("dry-run", false, "Check the file and report what would be imported without writing")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(usage)
+	}
+	name := fs.Arg(0)
+	if *format == "" {
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".csv":
+			*format = formatCSV
+		case ".ndjson", ".jsonl":
+			*format = formatNDJSON
+		}
+	}
+	if !validator.In(*format, formatCSV, formatNDJSON) {
+		return errors.New(usage)
+	}
+
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	rows, err := readImport(bufio.NewReader(r), *format)
+	if err != nil {
+		return err
+	}
+	report, err := app.importBooks(rows, *dryRun, 0, i18n.Default)
+	if err != nil {
+		return err
+	}
+	js, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(os.Stdout, "%s\n", js)
+	return err
+}
+
+// duplicatesCommand looks for duplicate books once, as the server does every
+// -duplicates-interval, and prints how many pairs await review.
+func (app *application) duplicatesCommand(args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: capybook [-duplicates-threshold score] duplicates")
+	}
+	pending, err := app.detectDuplicates()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(os.Stdout, "%d likely duplicate books awaiting review\n", pending)
+	return err
+}
+
+// purgeCommand purges the trash once, as the server does every hour, and prints
+// how much went.
+func (app *application) purgeCommand(args []string) error {
+	if len(args) != 0 || app.config.trash.retention <= 0 {
+		return errors.New("usage: capybook [-trash-retention duration] purge")
+	}
+	books, reviews, users, err := app.purgeTrash()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(os.Stdout, "purged %d books, %d reviews and %d users from the trash\n", books, reviews, users)
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// negotiateEncoding picks the content coding for a response from the client's
+// Accept-Encoding header, preferring brotli over gzip when both are equally
+// welcome. An empty result means the body is sent as is.
+func negotiateEncoding(header string) string {
+	quality := map[string]float64{}
+	for _, entry := range parseAccept(header) {
+		if _, seen := quality[entry.value]; !seen {
+			quality[entry.value] = entry.q
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, encoding := range []string{"br", "gzip"} {
+		q, ok := quality[encoding]
+		if !ok {
+			q = quality["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// stripEncodingTags undoes what compressWriter does to entity tags, so that
+// handlers can compare If-None-Match against the tags they compute.
+func stripEncodingTags(header string) string {
+	for _, encoding := range []string{"br", "gzip"} {
+		header = strings.ReplaceAll(header, "-"+encoding+`"`, `"`)
+	}
+	return header
+}
+
+// compressWriter encodes everything written to it with the negotiated coding.
+// As each coding produces different bytes, it also gives strong entity tags a
+// per-coding suffix.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	encoder     io.WriteCloser
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	h := cw.Header()
+	// Bodiless responses and ones a handler already encoded are left alone.
+	compress := status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == ""
+	if etag := h.Get("ETag"); (compress || status == http.StatusNotModified) && strings.HasSuffix(etag, `"`) {
+		h.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+cw.encoding+`"`)
+	}
+	if compress {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		if cw.encoding == "br" {
+			cw.encoder = brotli.NewWriter(cw.ResponseWriter)
+		} else {
+			cw.encoder = gzip.NewWriter(cw.ResponseWriter)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.encoder == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.encoder.Write(b)
+}
+
+// Flush pushes out whatever the encoder holds, for streamed responses.
+func (cw *compressWriter) Flush() {
+	if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close finishes the encoded stream.
+func (cw *compressWriter) Close() error {
+	if cw.encoder == nil {
+		return nil
+	}
+	return cw.encoder.Close()
+}
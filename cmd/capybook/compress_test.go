@@ -0,0 +1,76 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                        "",
+		"gzip":                    "gzip",
+		"gzip, br":                "br",
+		"br;q=0.5, gzip":          "gzip",
+		"*":                       "br",
+		"*;q=0.2, br;q=0":         "gzip",
+		"identity, deflate":       "",
+		"gzip;q=0, br;q=0, *;q=1": "",
+	}
+	for header, want := range tests {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestCompressTagsAndEncodesResponses(t *testing.T) {
+	app := &application{}
+	var ifNoneMatch string
+	handler := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch = r.Header.Get("If-None-Match")
+		w.Header().Set("ETag", `"abc"`)
+		io.WriteString(w, "hello")
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("If-None-Match", `"abc-gzip"`)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if ifNoneMatch != `"abc"` {
+		t.Errorf("the handler saw If-None-Match %s, want the tag it computes", ifNoneMatch)
+	}
+	res := w.Result()
+	if got := res.Header.Get("ETag"); got != `"abc-gzip"` {
+		t.Errorf("got ETag %s, want a gzip tag", got)
+	}
+	if got := res.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("got Content-Encoding %q, want gzip", got)
+	}
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil || string(body) != "hello" {
+		t.Errorf("got body %q, %v", body, err)
+	}
+}
+
+func TestCompressLeavesBodilessResponsesAlone(t *testing.T) {
+	app := &application{}
+	handler := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	r := httptest.NewRequest("DELETE", "/", nil)
+	r.Header.Set("Accept-Encoding", "br")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got := w.Result().Header.Get("Content-Encoding"); got != "" || w.Body.Len() != 0 {
+		t.Errorf("a 204 was encoded as %q with %d bytes", got, w.Body.Len())
+	}
+}
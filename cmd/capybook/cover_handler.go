@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/cover"
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+)
+
+// maxCoverBytes caps the size of an uploaded cover image.
+const maxCoverBytes = 5 << 20
+
+// readCoverUpload returns the image uploaded as the "file" field of a
+// multipart form.
+func (app *application) readCoverUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return nil, errUnsupportedUpload
+	}
+	// Leave room for the boundaries and headers of the form.
+	r.Body = http.MaxBytesReader(w, r.Body, maxCoverBytes+64<<10)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, newRequestError("empty_body")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(part, maxCoverBytes+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > maxCoverBytes {
+			return nil, errors.New("http: request body too large")
+		}
+		return data, nil
+	}
+}
+
+// uploadCoverHandler replaces the cover of a book with an uploaded image,
+// stored in every size of cover.Sizes.
+func (app *application) uploadCoverHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	book, err := app.models.Books.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	data, err := app.readCoverUpload(w, r)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnsupportedUpload):
+			app.unsupportedMediaTypeResponse(w, r, "multipart/form-data")
+		case err.Error() == "http: request body too large":
+			app.badRequestResponse(w, r, newRequestError("body_too_large", "bytes", strconv.Itoa(maxCoverBytes)))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	renditions, err := cover.Process(data)
+	if err != nil {
+		switch {
+		case errors.Is(err, cover.ErrUnsupported):
+			app.badRequestResponse(w, r, newRequestError("unsupported_image",
+				"type", cover.Sniff(data), "supported", strings.Join(cover.MediaTypes, ", ")))
+		case errors.Is(err, cover.ErrInvalid):
+			app.badRequestResponse(w, r, newRequestError("invalid_image"))
+		case errors.Is(err, cover.ErrTooLarge):
+			app.badRequestResponse(w, r, newRequestError("image_too_large", "pixels", strconv.Itoa(cover.MaxPixels)))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Each image gets its own key, so caches never serve the renditions of an
+	// earlier cover in its place.
+	sum := sha256.Sum256(data)
+	key := fmt.Sprintf("covers/%d/%s", book.ID, hex.EncodeToString(sum[:8]))
+	for _, rendition := range renditions {
+		err = app.storage.Put(r.Context(), key+"/"+rendition.Name+".jpg", rendition.Data, cover.ContentType)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	book, oldKey, err := app.models.Books.SetCover(book.ID, key, app.storage.URL(key), app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.deleteCover(key)
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if oldKey != "" && oldKey != key {
+		app.deleteCover(oldKey)
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCoverHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	book, oldKey, err := app.models.Books.SetCover(id, "", "", app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if oldKey != "" {
+		app.deleteCover(oldKey)
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCover removes the renditions stored under key in the background.
+func (app *application) deleteCover(key string) {
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		app.deleteCoverFiles(ctx, key)
+	})
+}
+
+// deleteCoverFiles removes the renditions stored under key. Failures only leave
+// unused files behind, so they are logged.
+func (app *application) deleteCoverFiles(ctx context.Context, key string) {
+	for _, size := range cover.Sizes {
+		if err := app.storage.Delete(ctx, key+"/"+size.Name+".jpg"); err != nil {
+			app.logger.Printf("delete cover %s: %v", key, err)
+		}
+	}
+}
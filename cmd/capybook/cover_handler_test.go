@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/storage"
+)
+
+// postCover uploads data as the file of a multipart form.
+func postCover(t *testing.T, app *application, data []byte) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "cover.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+	r := httptest.NewRequest("PUT", "/api/v1/books/1/cover", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, app.contextSetUser(r, activatedUser))
+	var js map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &js)
+	return w, js
+}
+
+func TestUploadCoverStoresEverySize(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	db.On("set_config", func([]driver.NamedValue) stubResult { return stubResult{} })
+	dir := t.TempDir()
+	app.storage = storage.NewLocal(dir, "/uploads")
+	db.On("UPDATE books", func(args []driver.NamedValue) stubResult {
+		book := stubBook(1, "Dune", "Frank Herbert")
+		book[len(book)-1] = args[2].Value
+		return stubResult{Columns: append(stubBookColumns, "cover_key"), Rows: [][]driver.Value{append(book, "")}}
+	})
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: stubBookColumns, Rows: [][]driver.Value{stubBook(1, "Dune", "Frank Herbert")}}
+	})
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 40, 60))); err != nil {
+		t.Fatal(err)
+	}
+
+	w, body := postCover(t, app, img.Bytes())
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %v", w.Code, body)
+	}
+	cover, _ := body["book"].(map[string]interface{})["cover"].(map[string]interface{})
+	small, _ := cover["small"].(string)
+	if !strings.HasPrefix(small, "/uploads/covers/1/") || !strings.HasSuffix(small, "/small.jpg") {
+		t.Fatalf("got cover %v", cover)
+	}
+	for _, size := range []string{"original", "large", "medium", "small"} {
+		name := filepath.Join(dir, strings.TrimPrefix(cover[size].(string), "/uploads/"))
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("%s: %v", size, err)
+		}
+	}
+}
+
+func TestUploadCoverRejectsWhatIsNotAnImage(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	app.storage = storage.NewLocal(t.TempDir(), "/uploads")
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: stubBookColumns, Rows: [][]driver.Value{stubBook(1, "Dune", "Frank Herbert")}}
+	})
+	w, body := postCover(t, app, []byte("#!/bin/sh\necho hello\n"))
+	if detail, _ := body["detail"].(string); w.Code != http.StatusBadRequest || !strings.Contains(detail, "not text/plain") {
+		t.Errorf("got %d %v, want 400 for a text file", w.Code, body)
+	}
+
+	res, body := serve(t, app, activatedUser, "PUT", "/api/v1/books/1/cover", `{"file": "cover.png"}`)
+	if res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("got %d %v for JSON, want 415", res.StatusCode, body)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+// detectDuplicates flags likely duplicate books, run by the server every
+// -duplicates-interval and by the duplicates command.
+func (app *application) detectDuplicates() (int, error) {
+	return app.models.Duplicates.Detect(app.config.duplicates.threshold)
+}
+
+// flagSameISBN flags the book of edition and the book that already has an
+// edition with its ISBN as likely duplicates. The edition has been turned away
+// by then, so failures are only logged.
+func (app *application) flagSameISBN(edition *model.Edition) {
+	books, err := app.models.Editions.FindBooks([]string{edition.ISBN13})
+	if err == nil {
+		if other, ok := books[edition.ISBN13]; ok && other != edition.BookID {
+			err = app.models.Duplicates.FlagISBN(edition.BookID, other)
+		}
+	}
+	if err != nil {
+		app.logger.Println(err)
+	}
+}
+
+// listDuplicatesHandler serves the pairs of books flagged as likely
+// duplicates, most alike first by default, with both books of each pair.
+func (app *application) listDuplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Dismissed bool
+		model.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Dismissed = app.readBool(qs, "dismissed", false, v)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.Limit = app.readInt(qs, "limit", 20, v)
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.Sort = app.readString(qs, "sort", "-score")
+	input.Filters.SortSafelist = []string{"score", "id", "-score", "-id"}
+
+	model.ValidateFilters(v, input.Filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	duplicates, metadata, err := app.models.Duplicates.GetAll(input.Dismissed, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.embedDuplicateBooks(duplicates...); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	if links := app.paginationLinks(r, metadata); links != "" {
+		headers.Set("Link", links)
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"duplicates": duplicates, "metadata": metadata}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateDuplicateHandler dismisses a flagged pair as not being duplicates, or
+// takes it back into the queue.
+func (app *application) updateDuplicateHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Dismissed *bool `json:"dismissed"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if input.Dismissed == nil {
+		v.Fail("dismissed", "required", "must be provided")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	duplicate, err := app.models.Duplicates.SetDismissed(id, *input.Dismissed)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if err = app.embedDuplicateBooks(duplicate); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"duplicate": duplicate}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// mergeBookHandler folds another book, typically a duplicate, into a book.
+// Requests for the other book are redirected to this one afterwards.
+func (app *application) mergeBookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		BookID int64 `json:"book_id"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	_, err = app.models.Books.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	v := validator.New()
+	v.Var("book_id", input.BookID, "min=1")
+	v.Check(input.BookID != id, "book_id", "must not be the book itself")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	book, err := app.models.Books.Merge(id, input.BookID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrUnknownBook):
+			v.Fail("book_id", "unknown_book", "must be an existing book")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// redirectMergedBook sends requests for a book that no longer exists on to the
+// book it was merged into, if it was, keeping the query string.
+func (app *application) redirectMergedBook(w http.ResponseWriter, r *http.Request, id int64) {
+	bookID, err := app.models.Books.Redirect(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	target := *r.URL
+	target.Path = fmt.Sprintf("/api/v1/books/%d", bookID)
+	http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+}
+
+// embedDuplicateBooks fills in both books of each pair.
+func (app *application) embedDuplicateBooks(duplicates ...*model.Duplicate) error {
+	var ids []int64
+	for _, d := range duplicates {
+		ids = append(ids, d.BookID, d.DuplicateID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	books, err := app.models.Books.GetMany(ids)
+	if err != nil {
+		return err
+	}
+	for _, d := range duplicates {
+		d.Book = books[d.BookID]
+		d.Duplicate = books[d.DuplicateID]
+	}
+	return nil
+}
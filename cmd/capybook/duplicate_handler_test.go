@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+)
+
+// A book merged into another is gone, but its address leads to the other book.
+func TestGetMergedBookRedirects(t *testing.T) {
+	app, db := newTestApplication(t)
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: stubBookColumns}
+	})
+	db.On("FROM book_redirects", func(args []driver.NamedValue) stubResult {
+		if args[0].Value != int64(5) {
+			return stubResult{Columns: []string{"book_id"}}
+		}
+		return stubResult{Columns: []string{"book_id"}, Rows: [][]driver.Value{{int64(1)}}}
+	})
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, app.contextSetUser(r, model.AnonymousUser))
+		return w
+	}
+	w := get("/api/v1/books/5?fields=title")
+	if w.Code != http.StatusMovedPermanently {
+		t.Fatalf("got %d, want 301", w.Code)
+	}
+	if got, want := w.Header().Get("Location"), "/api/v1/books/1?fields=title"; got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+	if w := get("/api/v1/books/6"); w.Code != http.StatusNotFound {
+		t.Errorf("a book that never existed: got %d, want 404", w.Code)
+	}
+}
+
+func TestMergeBookValidatesTheOtherBook(t *testing.T) {
+	tests := []struct {
+		body string
+		code string
+	}{
+		{`{"book_id": 1}`, "invalid"},
+		{`{"book_id": 0}`, "too_small"},
+		{`{"book_id": 2}`, "unknown_book"},
+	}
+	for _, tt := range tests {
+		app, db := newTestApplication(t, "books:write")
+		db.On("set_config", func([]driver.NamedValue) stubResult { return stubResult{} })
+		db.On("FOR UPDATE", func([]driver.NamedValue) stubResult {
+			return stubResult{Columns: []string{"id"}}
+		})
+		db.On("FROM books", func([]driver.NamedValue) stubResult {
+			return stubResult{Columns: stubBookColumns, Rows: [][]driver.Value{stubBook(1, "Dune", "Frank Herbert")}}
+		})
+		res, body := serve(t, app, activatedUser, "POST", "/api/v1/books/1/merge", tt.body)
+		if res.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got %d %v, want 422", tt.body, res.StatusCode, body)
+			continue
+		}
+		params, _ := body["invalid_params"].([]interface{})
+		if len(params) != 1 || params[0].(map[string]interface{})["code"] != tt.code {
+			t.Errorf("%s: got %v, want %s", tt.body, params, tt.code)
+		}
+	}
+}
+
+func TestUpdateDuplicateRequiresDismissed(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	res, body := serve(t, app, activatedUser, "PATCH", "/api/v1/duplicates/3", `{}`)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got %d %v, want 422", res.StatusCode, body)
+	}
+	if got := db.Logged("UPDATE book_duplicates"); len(got) != 0 {
+		t.Errorf("the pair was updated: %q", got)
+	}
+}
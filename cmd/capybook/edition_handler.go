@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shyndaliu/capybook/pkg/capybook/isbn"
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+// editionInput holds the fields of an edition clients can write. The ISBN may
+// be given in either form and is stored as an ISBN-13.
+type editionInput struct {
+	ISBN      string `json:"isbn"`
+	Title     string `json:"title"`
+	Publisher string `json:"publisher"`
+	Language  string `json:"language"`
+	Format    string `json:"format"`
+	Pages     int32  `json:"pages"`
+	Year      int32  `json:"year"`
+}
+
+func (input editionInput) applyTo(edition *model.Edition) {
+	edition.ISBN13 = input.ISBN
+	edition.Title = input.Title
+	edition.Publisher = input.Publisher
+	edition.Language = input.Language
+	edition.Format = input.Format
+	edition.Pages = input.Pages
+	edition.Year = input.Year
+}
+
+func inputFromEdition(edition *model.Edition) editionInput {
+	return editionInput{
+		ISBN:      edition.ISBN13,
+		Title:     edition.Title,
+		Publisher: edition.Publisher,
+		Language:  edition.Language,
+		Format:    edition.Format,
+		Pages:     edition.Pages,
+		Year:      edition.Year,
+	}
+}
+
+func (app *application) createEditionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input editionInput
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	book, err := app.models.Books.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	edition := &model.Edition{BookID: book.ID}
+	input.applyTo(edition)
+
+	v := validator.New()
+	if model.ValidateEdition(v, edition); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Editions.Insert(edition)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateISBN):
+			app.flagSameISBN(edition)
+			v.Fail("isbn", "already_exists", "an edition with this ISBN already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/editions/%d", edition.ID))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"edition": edition}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listEditionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	book, err := app.models.Books.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	freshness, err := app.models.Editions.Freshness(book.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.notModified(w, r, entityTag(r, book.Version, freshness), time.Time{}) {
+		return
+	}
+	editions, err := app.models.Editions.GetForBooks([]int64{book.ID})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	bookEditions := editions[book.ID]
+	if bookEditions == nil {
+		bookEditions = []*model.Edition{}
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"editions": bookEditions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getEditionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	edition, err := app.models.Editions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if app.notModified(w, r, entityTag(r, edition.Version), edition.UpdatedAt) {
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"edition": edition}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// isbnLookupHandler finds the edition with an ISBN, given in either form, and
+// the book it is an edition of.
+func (app *application) isbnLookupHandler(w http.ResponseWriter, r *http.Request) {
+	isbn13, err := isbn.Normalize(mux.Vars(r)["isbn"])
+	if err != nil {
+		v := validator.New()
+		v.Fail("isbn", "invalid_isbn", "must be a valid ISBN-10 or ISBN-13")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	edition, err := app.models.Editions.GetByISBN(isbn13)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	book, err := app.models.Books.Get(edition.BookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if app.notModified(w, r, entityTag(r, edition.Version, book.Version), time.Time{}) {
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"edition": edition, "book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateEditionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	edition, err := app.models.Editions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var input editionInput
+	err = app.readPatch(w, r, inputFromEdition(edition), &input)
+	if err != nil {
+		app.patchErrorResponse(w, r, err)
+		return
+	}
+	input.applyTo(edition)
+
+	v := validator.New()
+	if model.ValidateEdition(v, edition); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Editions.Update(edition)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateISBN):
+			app.flagSameISBN(edition)
+			v.Fail("isbn", "already_exists", "an edition with this ISBN already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"edition": edition}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteEditionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Editions.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "edition successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var stubEditionColumns = []string{"id", "book_id", "isbn13", "title", "publisher", "language", "format", "pages", "year", "version", "updated_at"}
+
+func stubEdition(id, bookID int64, isbn13 string) []driver.Value {
+	return []driver.Value{id, bookID, isbn13, "", "", "", "paperback", int64(0), int64(0), int64(1), stubUpdatedAt}
+}
+
+func TestCreateEditionNormalizesTheISBN(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: stubBookColumns, Rows: [][]driver.Value{stubBook(1, "Dune", "Frank Herbert")}}
+	})
+	var stored driver.Value
+	db.On("INSERT INTO editions", func(args []driver.NamedValue) stubResult {
+		stored = args[1].Value
+		return stubResult{Columns: []string{"id", "version", "updated_at"}, Rows: [][]driver.Value{{int64(5), int64(1), stubUpdatedAt}}}
+	})
+	res, body := serve(t, app, activatedUser, "POST", "/api/v1/books/1/editions", `{"isbn": "0-306-40615-2", "format": "paperback"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got %d %v", res.StatusCode, body)
+	}
+	edition := body["edition"].(map[string]interface{})
+	if stored != "9780306406157" || edition["isbn_13"] != "9780306406157" || edition["isbn_10"] != "0306406152" {
+		t.Errorf("stored %v and answered %v", stored, edition)
+	}
+	if got := res.Header.Get("Location"); got != "/api/v1/editions/5" {
+		t.Errorf("Location = %q", got)
+	}
+}
+
+func TestCreateEditionRejectsBadAndTakenISBNs(t *testing.T) {
+	tests := []struct {
+		body, code string
+	}{
+		{`{"isbn": "0-306-40615-3"}`, "invalid_isbn"},
+		{`{"isbn": "0-306-40615-2", "format": "scroll"}`, "not_allowed"},
+		{`{"isbn": "0-306-40615-2"}`, "already_exists"},
+	}
+	for _, tt := range tests {
+		app, db := newTestApplication(t, "books:write")
+		db.On("FROM books", func([]driver.NamedValue) stubResult {
+			return stubResult{Columns: stubBookColumns, Rows: [][]driver.Value{stubBook(1, "Dune", "Frank Herbert")}}
+		})
+		db.On("INSERT INTO editions", func([]driver.NamedValue) stubResult {
+			return stubResult{Err: errors.New(`pq: duplicate key value violates unique constraint "editions_isbn13_key"`)}
+		})
+		res, body := serve(t, app, activatedUser, "POST", "/api/v1/books/1/editions", tt.body)
+		params, _ := body["invalid_params"].([]interface{})
+		if res.StatusCode != http.StatusUnprocessableEntity || len(params) != 1 || params[0].(map[string]interface{})["code"] != tt.code {
+			t.Errorf("%s: got %d %v, want 422 %s", tt.body, res.StatusCode, body, tt.code)
+		}
+	}
+}
+
+func TestISBNLookup(t *testing.T) {
+	app, db := newTestApplication(t)
+	var looked driver.Value
+	db.On("FROM editions", func(args []driver.NamedValue) stubResult {
+		looked = args[0].Value
+		return stubResult{Columns: stubEditionColumns, Rows: [][]driver.Value{stubEdition(5, 1, "9780306406157")}}
+	})
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: stubBookColumns, Rows: [][]driver.Value{stubBook(1, "Dune", "Frank Herbert")}}
+	})
+
+	res, body := serve(t, app, activatedUser, "GET", "/api/v1/isbn/0306406152", "")
+	if res.StatusCode != http.StatusOK || looked != "9780306406157" {
+		t.Fatalf("got %d %v after looking up %v", res.StatusCode, body, looked)
+	}
+	if book := body["book"].(map[string]interface{}); book["title"] != "Dune" {
+		t.Errorf("got book %v", book)
+	}
+	if res, body := serve(t, app, activatedUser, "GET", "/api/v1/isbn/12345", ""); res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got %d %v for an invalid ISBN, want 422", res.StatusCode, body)
+	}
+}
+
+func TestISBNLookupOfATrashedBookIsNotFound(t *testing.T) {
+	app, db := newTestApplication(t)
+	db.On("FROM editions", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"id", "book_id", "isbn13", "title", "publisher", "language", "format", "pages", "year", "version", "updated_at"},
+			Rows:    [][]driver.Value{{int64(1), int64(2), "9780306406157", "", "", "", "", int64(0), int64(0), int64(1), time.Now()}},
+		}
+	})
+	// The book was trashed after its edition was found.
+	db.On("FROM books", func([]driver.NamedValue) stubResult { return stubResult{} })
+
+	res, js := serve(t, app, activatedUser, "GET", "/api/v1/isbn/0-306-40615-2", "")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("got %d %v, want 404", res.StatusCode, js)
+	}
+}
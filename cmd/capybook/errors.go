@@ -1,66 +1,197 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
+	"strings"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/i18n"
+	"github.com/shyndaliu/capybook/pkg/capybook/patch"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
 )
 
 type envelope map[string]interface{}
 
+// Clients that still expect the original {"error": ...} bodies ask for them with
+// this media type in their Accept header.
+const legacyErrorMediaType = "application/vnd.capybook.legacy-error+json"
+
+// problemTypeBase prefixes the error code to form the problem type URI.
+const problemTypeBase = "https://capybook.net/problems/"
+
+// Stable, machine-readable error codes. Clients should match on these rather
+// than on the human-readable detail. Each has a "title.<code>" and an
+// "error.<code>" message in the i18n catalogs.
+const (
+	codeServerError                = "server_error"
+	codeNotFound                   = "not_found"
+	codeMethodNotAllowed           = "method_not_allowed"
+	codeBadRequest                 = "bad_request"
+	codeFailedValidation           = "failed_validation"
+	codeEditConflict               = "edit_conflict"
+	codeInvalidCredentials         = "invalid_credentials"
+	codeInvalidAuthenticationToken = "invalid_authentication_token"
+	codeAuthenticationRequired     = "authentication_required"
+	codeInactiveAccount            = "inactive_account"
+	codeNotPermitted               = "not_permitted"
+	codeUnsupportedMediaType       = "unsupported_media_type"
+	codePatchTestFailed            = "patch_test_failed"
+)
+
+// problem is an RFC 7807 problem details object.
+type problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	Code          string         `json:"code"`
+	RequestID     string         `json:"request_id,omitempty"`
+	InvalidParams []invalidParam `json:"invalid_params,omitempty"`
+}
+
+type invalidParam struct {
+	Name   string `json:"name"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.Println(err)
+	app.logger.Printf("request_id=%s %s %s: %v", app.contextGetRequestID(r), r.Method, r.URL.RequestURI(), err)
+}
+
+// wantsLegacyErrors reports whether the client negotiated the original error format.
+func wantsLegacyErrors(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), legacyErrorMediaType)
 }
 
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
-	env := envelope{"error": message}
+// writeProblem sends p as application/problem+json, or in the legacy
+// {"error": ...} shape when the client asked for it.
+func (app *application) writeProblem(w http.ResponseWriter, r *http.Request, p problem) {
+	p.Type = problemTypeBase + strings.ReplaceAll(p.Code, "_", "-")
+	p.Title = i18n.T(app.contextGetLocale(r), "title."+p.Code)
+	p.Instance = r.URL.Path
+	p.RequestID = app.contextGetRequestID(r)
+	// Validators set before the failure must not make the error cacheable.
+	w.Header().Del("ETag")
+	w.Header().Del("Last-Modified")
+	w.Header().Set("Cache-Control", "no-store")
 
-	err := app.writeJSON(w, status, env, nil)
+	var err error
+	if wantsLegacyErrors(r) {
+		var message interface{} = p.Detail
+		if p.InvalidParams != nil {
+			// The legacy format only had room for one message per field.
+			errors := make(map[string]string)
+			for _, param := range p.InvalidParams {
+				if _, exists := errors[param.Name]; !exists {
+					errors[param.Name] = param.Reason
+				}
+			}
+			message = errors
+		}
+		err = app.writeJSON(w, r, p.Status, envelope{"error": message}, nil)
+	} else {
+		headers := make(http.Header)
+		headers.Set("Content-Type", "application/problem+json")
+		err = app.writeJSON(w, r, p.Status, p, headers)
+	}
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
 	}
 }
+
+// errorResponse sends the problem identified by code, with its detail message
+// localized for the client. params fill in the placeholders of the message.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, params ...string) {
+	detail := i18n.T(app.contextGetLocale(r), "error."+code, params...)
+	app.writeProblem(w, r, problem{Status: status, Code: code, Detail: detail})
+}
+
+// localizeFieldError translates a validation failure, preferring a message
+// specific to the field ("validation.<code>.<field>"), then one for the unit
+// that was measured ("validation.<code>.<unit>"), over the generic one.
+func localizeFieldError(locale string, e validator.FieldError) string {
+	keys := []string{"validation." + e.Code + "." + e.Field}
+	if e.Unit != "" {
+		keys = append(keys, "validation."+e.Code+"."+e.Unit)
+	}
+	for _, key := range append(keys, "validation."+e.Code) {
+		if message, ok := i18n.Lookup(locale, key, "param", e.Param); ok {
+			return message
+		}
+	}
+	return e.Message
+}
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
-	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, codeServerError)
 }
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
-	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, codeNotFound)
 }
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
-	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method", r.Method)
 }
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	detail := err.Error()
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		detail = i18n.T(app.contextGetLocale(r), "request."+reqErr.code, reqErr.params...)
+	}
+	app.writeProblem(w, r, problem{Status: http.StatusBadRequest, Code: codeBadRequest, Detail: detail})
 }
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors []validator.FieldError) {
+	locale := app.contextGetLocale(r)
+	params := make([]invalidParam, len(errors))
+	for i, e := range errors {
+		params[i] = invalidParam{Name: e.Field, Code: e.Code, Reason: localizeFieldError(locale, e)}
+	}
+	app.writeProblem(w, r, problem{
+		Status:        http.StatusUnprocessableEntity,
+		Code:          codeFailedValidation,
+		Detail:        i18n.T(locale, "error."+codeFailedValidation),
+		InvalidParams: params,
+	})
 }
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
-	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, codeEditConflict)
 }
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
-	message := "invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidCredentials)
 }
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
-	message := "invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidAuthenticationToken)
 }
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
-	message := "you must be authenticated to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeAuthenticationRequired)
 }
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your user account must be activated to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, codeInactiveAccount)
 }
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
-	message := "your user account doesn't have the necessary permissions to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, codeNotPermitted)
+}
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	list := strings.Join(supported, ", ")
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "type", r.Header.Get("Content-Type"), "supported", list)
+}
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request) {
+	app.errorResponse(w, r, http.StatusConflict, codePatchTestFailed)
+}
+
+// patchErrorResponse reports an error returned by readPatch.
+func (app *application) patchErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errUnsupportedPatchType):
+		w.Header().Set("Accept-Patch", strings.Join(patchMediaTypes, ", "))
+		app.unsupportedMediaTypeResponse(w, r, patchMediaTypes...)
+	case errors.Is(err, patch.ErrTestFailed):
+		app.patchTestFailedResponse(w, r)
+	default:
+		app.badRequestResponse(w, r, err)
+	}
 }
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/i18n"
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+func TestErrorsAreProblems(t *testing.T) {
+	app, _ := newTestApplication(t)
+	res, body := serve(t, app, model.AnonymousUser, "GET", "/api/v1/nowhere", "")
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("got %d, want 404", res.StatusCode)
+	}
+	if got := res.Header.Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("got Content-Type %s, want application/problem+json", got)
+	}
+	want := map[string]interface{}{
+		"type":     "https://capybook.net/problems/not-found",
+		"title":    "Resource not found",
+		"status":   404.0,
+		"code":     "not_found",
+		"instance": "/api/v1/nowhere",
+	}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("got %s %v, want %v", key, body[key], value)
+		}
+	}
+}
+
+func TestValidationProblemsListInvalidParams(t *testing.T) {
+	app, _ := newTestApplication(t)
+	res, body := serve(t, app, model.AnonymousUser, "GET", "/api/v1/books?page=0&limit=0", "")
+	if res.StatusCode != http.StatusUnprocessableEntity || body["code"] != "failed_validation" {
+		t.Fatalf("got %d %v, want 422 failed_validation", res.StatusCode, body["code"])
+	}
+	params := body["invalid_params"].([]interface{})
+	if len(params) != 2 || params[0].(map[string]interface{})["name"] != "page" || params[1].(map[string]interface{})["name"] != "limit" {
+		t.Errorf("got invalid_params %v, want page then limit", params)
+	}
+	if code := params[0].(map[string]interface{})["code"]; code != "too_small" {
+		t.Errorf("got code %v for page, want too_small", code)
+	}
+}
+
+func TestLegacyErrors(t *testing.T) {
+	app, _ := newTestApplication(t)
+	r := httptest.NewRequest("GET", "/api/v1/books?page=0", nil)
+	r.Header.Set("Accept", legacyErrorMediaType)
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, app.contextSetUser(r, model.AnonymousUser))
+	var body struct {
+		Error map[string]string `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusUnprocessableEntity || body.Error["page"] != "must be at least 1" {
+		t.Errorf("got %d %s, want the legacy error shape", w.Code, w.Body)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	app, _ := newTestApplication(t)
+	tests := []struct {
+		sent string
+		kept bool
+	}{
+		{"", false},
+		{"proxy-assigned_42", true},
+		{"not a sane id", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/nowhere", nil)
+		if tt.sent != "" {
+			r.Header.Set("X-Request-ID", tt.sent)
+		}
+		w := httptest.NewRecorder()
+		app.requestID(app.authenticate(app.routes())).ServeHTTP(w, r)
+		id := w.Header().Get("X-Request-ID")
+		if (id == tt.sent) != tt.kept || !requestIDRX.MatchString(id) {
+			t.Errorf("sent %q, got X-Request-ID %q", tt.sent, id)
+		}
+		var body problem
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.RequestID != id {
+			t.Errorf("got request_id %q in the body, want %q", body.RequestID, id)
+		}
+	}
+}
+
+func TestProblemsAreLocalized(t *testing.T) {
+	app, _ := newTestApplication(t)
+	r := httptest.NewRequest("GET", "/api/v1/books?page=0", nil)
+	r.Header.Set("Accept-Language", "ru-RU,ru;q=0.9,en;q=0.8")
+	w := httptest.NewRecorder()
+	app.localize(app.routes()).ServeHTTP(w, app.contextSetUser(r, model.AnonymousUser))
+	if got := w.Header().Get("Content-Language"); got != "ru" {
+		t.Errorf("got Content-Language %q, want ru", got)
+	}
+	var body problem
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.InvalidParams) != 1 || body.InvalidParams[0].Reason != "значение должно быть не меньше 1" {
+		t.Errorf("got invalid_params %+v, want a Russian reason", body.InvalidParams)
+	}
+	if body.Code != "failed_validation" || body.Title == problemTitle(t, "en", body.Code) {
+		t.Errorf("got code %q titled %q, want a Russian title", body.Code, body.Title)
+	}
+}
+
+// problemTitle returns the title of the problem code in locale.
+func problemTitle(t *testing.T, locale, code string) string {
+	t.Helper()
+	title, ok := i18n.Lookup(locale, "title."+code)
+	if !ok {
+		t.Fatalf("no title for %s", code)
+	}
+	return title
+}
+
+func TestLocalizeFieldErrorPrefersTheField(t *testing.T) {
+	e := validator.FieldError{Field: "email", Code: "already_exists", Message: "taken"}
+	if got := localizeFieldError("en", e); got != "a user with this email address already exists" {
+		t.Errorf("got %q, want the message for email", got)
+	}
+	e = validator.FieldError{Field: "title", Code: "too_long", Param: "500"}
+	if got := localizeFieldError("en", e); got != "must not be more than 500 characters long" {
+		t.Errorf("got %q, want the generic message", got)
+	}
+	e = validator.FieldError{Field: "title", Code: "no_such_code", Message: "as reported"}
+	if got := localizeFieldError("en", e); got != "as reported" {
+		t.Errorf("got %q, want the validator's own message", got)
+	}
+}
+
+func TestRequestErrorsAreLocalized(t *testing.T) {
+	app, _ := newTestApplication(t, "books:write")
+	r := httptest.NewRequest("POST", "/api/v1/books", strings.NewReader(`{"isbn": "x"}`))
+	r.Header.Set("Accept-Language", "kk")
+	w := httptest.NewRecorder()
+	app.localize(app.routes()).ServeHTTP(w, app.contextSetUser(r, activatedUser))
+	var body problem
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if want := i18n.T("kk", "request.unknown_key", "key", `"isbn"`); w.Code != http.StatusBadRequest || body.Detail != want {
+		t.Errorf("got %d %q, want 400 %q", w.Code, body.Detail, want)
+	}
+}
+
+func TestLocalizeFieldErrorByUnit(t *testing.T) {
+	tests := []struct {
+		err  validator.FieldError
+		want string
+	}{
+		{validator.FieldError{Field: "isbn", Code: "wrong_length", Param: "13"}, "must be exactly 13 characters long"},
+		{validator.FieldError{Field: "authors", Code: "wrong_length", Param: "2", Unit: "items"}, "must contain exactly 2 items"},
+		{validator.FieldError{Field: "title", Code: "too_long", Param: "200"}, "must not be more than 200 characters long"},
+		{validator.FieldError{Field: "password", Code: "too_long", Param: "72", Unit: "bytes"}, "must not be more than 72 bytes long"},
+		// Messages specific to a field win over the others.
+		{validator.FieldError{Field: "name", Code: "already_exists"}, "an author with this name already exists"},
+	}
+	for _, tt := range tests {
+		if got := localizeFieldError("en", tt.err); got != tt.want {
+			t.Errorf("localizeFieldError(%+v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+// emittedCodes collects the codes the non-test sources under dirs can report,
+// keyed by the prefix of their messages: "validation." for failed checks,
+// "import." for the issues of library imports, "title." for problems and
+// "error." for the problems whose detail is looked up by errorResponse.
+func emittedCodes(t *testing.T, dirs ...string) map[string][]string {
+	t.Helper()
+	codes := make(map[string][]string)
+	constants := make(map[string]ast.Expr)
+	var responses []string
+	add := func(prefix string, lit ast.Expr) {
+		if lit, ok := lit.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			code, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			codes[prefix] = append(codes[prefix], code)
+		}
+	}
+	// codeOf adds the Code of a FieldError or ImportIssue literal.
+	codeOf := func(prefix string, lit *ast.CompositeLit) {
+		for _, elt := range lit.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				if key, ok := kv.Key.(*ast.Ident); ok && key.Name == "Code" {
+					add(prefix, kv.Value)
+				}
+			}
+		}
+	}
+	inspect := func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.CallExpr:
+			var name string
+			switch fun := node.Fun.(type) {
+			case *ast.Ident:
+				name = fun.Name
+			case *ast.SelectorExpr:
+				name = fun.Sel.Name
+			}
+			switch {
+			case (name == "Fail" || name == "matchRule") && len(node.Args) == 3:
+				add("validation.", node.Args[1])
+			case name == "failure" && len(node.Args) == 2:
+				add("validation.", node.Args[0])
+			case name == "skip" && len(node.Args) == 1:
+				add("import.", node.Args[0])
+			case name == "Check" || name == "AddError":
+				codes["validation."] = append(codes["validation."], "invalid")
+			case name == "errorResponse" && len(node.Args) >= 4:
+				if code, ok := node.Args[3].(*ast.Ident); ok {
+					responses = append(responses, code.Name)
+				}
+			}
+		case *ast.CompositeLit:
+			switch typeName(node.Type) {
+			case "FieldError":
+				codeOf("validation.", node)
+			case "ImportIssue":
+				codeOf("import.", node)
+				if _, ok := node.Type.(*ast.ArrayType); ok {
+					for _, elt := range node.Elts {
+						if elt, ok := elt.(*ast.CompositeLit); ok {
+							codeOf("import.", elt)
+						}
+					}
+				}
+			}
+		case *ast.ValueSpec:
+			for i, name := range node.Names {
+				if strings.HasPrefix(name.Name, "code") && i < len(node.Values) {
+					constants[name.Name] = node.Values[i]
+					add("title.", node.Values[i])
+				}
+			}
+		}
+		return true
+	}
+	fset := token.NewFileSet()
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+				return err
+			}
+			file, err := parser.ParseFile(fset, path, nil, 0)
+			if err != nil {
+				return err
+			}
+			ast.Inspect(file, inspect)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range responses {
+		add("error.", constants[name])
+	}
+	return codes
+}
+
+// typeName returns the name of the type t, or of its elements for slices.
+func typeName(t ast.Expr) string {
+	switch t := t.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	case *ast.ArrayType:
+		return typeName(t.Elt)
+	case *ast.StarExpr:
+		return typeName(t.X)
+	}
+	return ""
+}
+
+// Every code the API can report has a message in every locale, rather than
+// falling back to the English text it was raised with.
+func TestEmittedCodesAreTranslated(t *testing.T) {
+	codes := emittedCodes(t, ".", "../../pkg/capybook")
+	for _, prefix := range []string{"validation.", "import.", "title.", "error."} {
+		if len(codes[prefix]) == 0 {
+			t.Fatalf("found no %s codes", prefix)
+		}
+	}
+	// Reviews that fail validation are reported as "review_" and the code of
+	// their first failure.
+	for _, review := range []*model.Review{{Rating: 5}, {Content: strings.Repeat("a", 1001), Rating: 5}} {
+		v := validator.New()
+		model.ValidateReview(v, review)
+		for _, e := range v.Errors {
+			codes["import."] = append(codes["import."], "review_"+e.Code)
+		}
+	}
+	checked := make(map[string]bool)
+	for prefix, list := range codes {
+		for _, code := range list {
+			key := prefix + code
+			if checked[key] {
+				continue
+			}
+			checked[key] = true
+			for _, locale := range i18n.Supported {
+				if _, ok := i18n.Lookup(locale, key); !ok {
+					t.Errorf("%s has no message for %s", locale, key)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+// Datasets that can be exported, and the formats they are streamed in.
+var (
+	exportDatasets = []string{"books", "reviews"}
+	exportFormats  = []string{formatNDJSON, formatCSV}
+)
+
+// How many records are written between flushes of an export to the client.
+const exportFlushEvery = 1000
+
+// How long each batch of an export may take to reach the client. Exports run
+// past the server's write timeout, so rather than one deadline for the whole
+// response, every flush gets a fresh one: a slow export keeps going while a
+// client that stops reading is still cut off.
+const exportWriteTimeout = 30 * time.Second
+
+// exportedReview is a review as exported. Unlike the API representation it
+// refers to the book and the user by id, so that rows can be joined back up.
+type exportedReview struct {
+	*model.Review
+	BookID int64 `json:"book_id"`
+	UserID int64 `json:"user_id"`
+}
+
+func exportColumns(dataset string) []string {
+	switch dataset {
+	case "books":
+		var columns []string
+		for _, field := range jsonFields(model.Book{}) {
+			if field != "highlight" {
+				columns = append(columns, field)
+			}
+		}
+		return columns
+	case "reviews":
+		return append(jsonFields(model.Review{}), "book_id", "user_id")
+	}
+	return nil
+}
+
+// export streams every row of dataset to w. flush, when not nil, is called
+// regularly so that records reach the client while the export is running.
+func (app *application) export(ctx context.Context, w io.Writer, dataset, format string, flush func()) error {
+	enc, err := newRecordEncoder(w, format, exportColumns(dataset))
+	if err != nil {
+		return err
+	}
+	n := 0
+	emit := func(record interface{}) error {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+		if n++; flush != nil && n%exportFlushEvery == 0 {
+			if err := enc.Flush(); err != nil {
+				return err
+			}
+			flush()
+		}
+		return nil
+	}
+	switch dataset {
+	case "books":
+		err = app.models.Books.Export(ctx, func(book *model.Book) error {
+			return emit(book)
+		})
+	case "reviews":
+		err = app.models.Reviews.Export(ctx, func(review *model.Review) error {
+			return emit(exportedReview{Review: review, BookID: review.BookId, UserID: review.AuthorId})
+		})
+	default:
+		err = fmt.Errorf("unknown dataset %q", dataset)
+	}
+	if err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// readExportFormat picks the format of an export from the format query
+// parameter or the Accept header. Exports default to NDJSON.
+func (app *application) readExportFormat(w http.ResponseWriter, r *http.Request, v *validator.Validator) string {
+	w.Header().Add("Vary", "Accept")
+	if format := r.URL.Query().Get("format"); format != "" {
+		v.Var("format", format, "oneof="+strings.Join(exportFormats, " "))
+		return format
+	}
+	for _, entry := range parseAccept(r.Header.Get("Accept")) {
+		if entry.q > 0 && entry.value == formatMediaTypes[formatCSV] {
+			return formatCSV
+		}
+	}
+	return formatNDJSON
+}
+
+// exportWriter sets the headers of an export on the first write, so that
+// anything failing before the first row can still be reported as an error.
+type exportWriter struct {
+	http.ResponseWriter
+	dataset, format string
+	started         bool
+}
+
+func (ew *exportWriter) start() {
+	ew.started = true
+	h := ew.Header()
+	h.Set("Content-Type", formatContentType(ew.format))
+	h.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, ew.dataset, ew.format))
+	h.Set("Cache-Control", "no-store")
+	ew.WriteHeader(http.StatusOK)
+}
+
+func (ew *exportWriter) Write(b []byte) (int, error) {
+	if !ew.started {
+		ew.start()
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+func (app *application) exportBooksHandler(w http.ResponseWriter, r *http.Request) {
+	app.exportResponse(w, r, "books")
+}
+
+func (app *application) exportReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	app.exportResponse(w, r, "reviews")
+}
+
+// exportResponse streams a whole dataset to the client straight from the
+// database. Once rows have been sent a failure can't be reported with a status
+// code any more, so the connection is aborted and the client sees a truncated
+// response rather than a seemingly complete one.
+func (app *application) exportResponse(w http.ResponseWriter, r *http.Request, dataset string) {
+	v := validator.New()
+	format := app.readExportFormat(w, r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	ew := &exportWriter{ResponseWriter: w, dataset: dataset, format: format}
+	rc := http.NewResponseController(w)
+	extendDeadline := func() {
+		// Servers without write deadlines, such as test recorders, don't
+		// support moving them, which is fine.
+		rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	}
+	extendDeadline()
+	flush := func() {
+		rc.Flush()
+		extendDeadline()
+	}
+	err := app.export(r.Context(), ew, dataset, format, flush)
+	switch {
+	case err == nil:
+		if !ew.started {
+			ew.start()
+		}
+	case !ew.started:
+		app.serverErrorResponse(w, r, err)
+	default:
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExportOutlastsTheServerWriteTimeout(t *testing.T) {
+	app, db := newTestApplication(t, "admin:read")
+	db.On("DECLARE export_cursor", func([]driver.NamedValue) stubResult {
+		time.Sleep(300 * time.Millisecond)
+		return stubResult{}
+	})
+	db.On("FETCH", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"id"}}
+	})
+	routes := app.routes()
+	srv := httptest.NewUnstartedServer(app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		routes.ServeHTTP(w, app.contextSetUser(r, activatedUser))
+	})))
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/api/v1/export/books")
+	if err != nil {
+		t.Fatalf("export was cut off: %v", err)
+	}
+	defer res.Body.Close()
+	if _, err := io.ReadAll(res.Body); err != nil {
+		t.Fatalf("export was cut off: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want 200", res.StatusCode)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubExport answers an export's cursor with rows, in a single batch.
+func stubExport(db *stubDB, columns []string, rows ...[]driver.Value) {
+	db.On("DECLARE export_cursor", func([]driver.NamedValue) stubResult {
+		return stubResult{}
+	})
+	db.On("FETCH", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: columns, Rows: rows}
+	})
+}
+
+func TestExportRequiresAdminRead(t *testing.T) {
+	app, _ := newTestApplication(t)
+	res, body := serve(t, app, activatedUser, "GET", "/api/v1/export/books", "")
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("got %d %v, want 403", res.StatusCode, body)
+	}
+}
+
+func TestExportBooks(t *testing.T) {
+	tests := []struct {
+		query, accept, contentType, filename, firstLine string
+	}{
+		{"", "", "application/x-ndjson", "books.ndjson", `{"id":1,"title":"Dune",`},
+		{"", "text/csv", "text/csv; charset=utf-8", "books.csv", "id,title,author,"},
+		{"?format=csv", "", "text/csv; charset=utf-8", "books.csv", "id,title,author,"},
+	}
+	for _, tt := range tests {
+		app, db := newTestApplication(t, "admin:read")
+		stubExport(db, stubBookColumns, stubBook(1, "Dune", "Frank Herbert"), stubBook(2, "Emma", "Jane Austen"))
+		r := httptest.NewRequest("GET", "/api/v1/export/books"+tt.query, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, app.contextSetUser(r, activatedUser))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%q: got %d %s", tt.query, w.Code, w.Body)
+		}
+		h := w.Header()
+		if h.Get("Content-Type") != tt.contentType || !strings.Contains(h.Get("Content-Disposition"), tt.filename) {
+			t.Errorf("%q, Accept %q: got %q, %q", tt.query, tt.accept, h.Get("Content-Type"), h.Get("Content-Disposition"))
+		}
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		if len(lines) < 2 || !strings.HasPrefix(lines[0], tt.firstLine) {
+			t.Errorf("%q, Accept %q: got\n%s", tt.query, tt.accept, w.Body)
+		}
+	}
+
+	app, _ := newTestApplication(t, "admin:read")
+	res, body := serve(t, app, activatedUser, "GET", "/api/v1/export/books?format=json", "")
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("format=json: got %d %v, want 422", res.StatusCode, body)
+	}
+}
+
+// Exported reviews refer to their book and author by id.
+func TestExportReviews(t *testing.T) {
+	app, db := newTestApplication(t, "admin:read")
+	stubExport(db,
+		[]string{"id", "created_at", "user_id", "username", "book_id", "title", "content", "rating", "version", "updated_at"},
+		[]driver.Value{int64(3), stubUpdatedAt, int64(7), "reader", int64(1), "Dune", "Spice.", int64(5), int64(1), stubUpdatedAt},
+	)
+	r := httptest.NewRequest("GET", "/api/v1/export/reviews?format=csv", nil)
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, app.contextSetUser(r, activatedUser))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], ",book_id,user_id") || !strings.HasSuffix(lines[1], ",1,7") {
+		t.Errorf("got %d\n%s", w.Code, w.Body)
+	}
+}
+
+// An export that fails before its first row is still an ordinary error.
+func TestExportFailingBeforeTheFirstRow(t *testing.T) {
+	app, db := newTestApplication(t, "admin:read")
+	db.On("DECLARE export_cursor", func([]driver.NamedValue) stubResult {
+		return stubResult{Err: os.ErrDeadlineExceeded}
+	})
+	res, body := serve(t, app, activatedUser, "GET", "/api/v1/export/books", "")
+	if res.StatusCode != http.StatusInternalServerError || res.Header.Get("Content-Disposition") != "" {
+		t.Errorf("got %d %v %v, want a plain 500", res.StatusCode, res.Header, body)
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	app, db := newTestApplication(t)
+	stubExport(db, stubBookColumns, stubBook(1, "Dune", "Frank Herbert"))
+	out := filepath.Join(t.TempDir(), "books.csv")
+	if err := app.runCommand([]string{"export", "books", "-format", "csv", "-o", out}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "1,Dune,Frank Herbert,") {
+		t.Errorf("wrote\n%s", b)
+	}
+
+	for _, args := range [][]string{{"export"}, {"export", "users"}, {"export", "books", "-format", "xml"}, {"import"}} {
+		if err := app.runCommand(args); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+// How many reviews are embedded per book or user with include=reviews.
+const includedReviewsLimit = 5
+
+// bookIncludes lists the resources that can be embedded in books.
+var bookIncludes = []string{"reviews", "author_stats", "editions", "authors", "tags"}
+
+// resource is the JSON object form of a record, so that members can be dropped
+// (fields=) or added (include=) before it is written out.
+type resource map[string]json.RawMessage
+
+// toResources converts a slice of records into resources.
+func toResources(records interface{}) ([]resource, error) {
+	js, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+	var resources []resource
+	err = json.Unmarshal(js, &resources)
+	return resources, err
+}
+
+// keep drops every member not named in fields. An empty fields keeps them all.
+func (res resource) keep(fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	for key := range res {
+		if !validator.In(key, fields...) {
+			delete(res, key)
+		}
+	}
+}
+
+func (res resource) set(key string, value interface{}) error {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	res[key] = js
+	return nil
+}
+
+// jsonFields lists the JSON member names of the struct record points to.
+func jsonFields(record interface{}) []string {
+	t := reflect.TypeOf(record)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// readFieldList reads a comma-separated list of names from the query string,
+// recording a validation error for any name not in allowed.
+func (app *application) readFieldList(qs url.Values, key string, allowed []string, v *validator.Validator) []string {
+	values := app.readCSV(qs, key, []string{})
+	v.Var(key, values, "dive,oneof="+strings.Join(allowed, " "))
+	return values
+}
+
+// projectBooks applies fields= and include= to books, resolving every include
+// with a single batched query.
+func (app *application) projectBooks(books []*model.Book, fields, include []string) ([]resource, error) {
+	resources, err := toResources(books)
+	if err != nil {
+		return nil, err
+	}
+	var reviews map[int64][]*model.Review
+	if validator.In("reviews", include...) {
+		ids := make([]int64, len(books))
+		for i, book := range books {
+			ids[i] = book.ID
+		}
+		reviews, err = app.models.Reviews.GetLatestForBooks(ids, includedReviewsLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var stats map[string]*model.AuthorStats
+	if validator.In("author_stats", include...) {
+		authors := make([]string, len(books))
+		for i, book := range books {
+			authors[i] = book.Author
+		}
+		stats, err = app.models.Books.AuthorStats(authors)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var editions map[int64][]*model.Edition
+	if validator.In("editions", include...) {
+		ids := make([]int64, len(books))
+		for i, book := range books {
+			ids[i] = book.ID
+		}
+		editions, err = app.models.Editions.GetForBooks(ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var credits map[int64][]*model.Credit
+	if validator.In("authors", include...) {
+		ids := make([]int64, len(books))
+		for i, book := range books {
+			ids[i] = book.ID
+		}
+		credits, err = app.models.Authors.GetCredits(ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var tags map[int64][]*model.BookTag
+	if validator.In("tags", include...) {
+		ids := make([]int64, len(books))
+		for i, book := range books {
+			ids[i] = book.ID
+		}
+		tags, err = app.models.Tags.GetForBooks(ids, 0, includedTagsLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, res := range resources {
+		res.keep(fields)
+		if reviews != nil {
+			bookReviews := reviews[books[i].ID]
+			if bookReviews == nil {
+				bookReviews = []*model.Review{}
+			}
+			if err := res.set("reviews", bookReviews); err != nil {
+				return nil, err
+			}
+		}
+		if stats != nil {
+			if err := res.set("author_stats", stats[books[i].Author]); err != nil {
+				return nil, err
+			}
+		}
+		if editions != nil {
+			bookEditions := editions[books[i].ID]
+			if bookEditions == nil {
+				bookEditions = []*model.Edition{}
+			}
+			if err := res.set("editions", bookEditions); err != nil {
+				return nil, err
+			}
+		}
+		if credits != nil {
+			bookCredits := credits[books[i].ID]
+			if bookCredits == nil {
+				bookCredits = []*model.Credit{}
+			}
+			if err := res.set("authors", bookCredits); err != nil {
+				return nil, err
+			}
+		}
+		if tags != nil {
+			bookTags := tags[books[i].ID]
+			if bookTags == nil {
+				bookTags = []*model.BookTag{}
+			}
+			if err := res.set("tags", bookTags); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return resources, nil
+}
+
+// projectReviews applies fields= and include= to reviews.
+func (app *application) projectReviews(reviews []*model.Review, fields, include []string) ([]resource, error) {
+	resources, err := toResources(reviews)
+	if err != nil {
+		return nil, err
+	}
+	var books map[int64]*model.Book
+	if validator.In("book", include...) {
+		ids := make([]int64, len(reviews))
+		for i, review := range reviews {
+			ids[i] = review.BookId
+		}
+		books, err = app.models.Books.GetMany(ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, res := range resources {
+		res.keep(fields)
+		if books != nil {
+			if err := res.set("book", books[reviews[i].BookId]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return resources, nil
+}
+
+// projectUsers applies fields= and include= to users.
+func (app *application) projectUsers(users []*model.User, fields, include []string) ([]resource, error) {
+	resources, err := toResources(users)
+	if err != nil {
+		return nil, err
+	}
+	var reviews map[int64][]*model.Review
+	if validator.In("reviews", include...) {
+		ids := make([]int64, len(users))
+		for i, user := range users {
+			ids[i] = user.ID
+		}
+		reviews, err = app.models.Reviews.GetLatestForUsers(ids, includedReviewsLimit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, res := range resources {
+		res.keep(fields)
+		if reviews != nil {
+			userReviews := reviews[users[i].ID]
+			if userReviews == nil {
+				userReviews = []*model.Review{}
+			}
+			if err := res.set("reviews", userReviews); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return resources, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+)
+
+func TestJSONFields(t *testing.T) {
+	var record struct {
+		ID      int64  `json:"id"`
+		Name    string `json:"name,omitempty"`
+		Secret  string `json:"-"`
+		Ignored string
+	}
+	if got, want := jsonFields(&record), []string{"id", "name"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestResourceKeep(t *testing.T) {
+	res := resource{"id": []byte("1"), "title": []byte(`"Dune"`), "year": []byte("1965")}
+	res.keep(nil)
+	if len(res) != 3 {
+		t.Errorf("an empty field list dropped members: %v", res)
+	}
+	res.keep([]string{"id", "year"})
+	var keys []string
+	for key := range res {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"id", "year"}) {
+		t.Errorf("kept %v, want id and year", keys)
+	}
+}
+
+func TestListBooksFieldsAndIncludes(t *testing.T) {
+	app, db := newTestApplication(t)
+	stubFreshness(db)
+	var reviewedBooks interface{}
+	db.On("row_number()", func(args []driver.NamedValue) stubResult {
+		reviewedBooks = args[0].Value
+		return stubResult{
+			Columns: []string{"id", "created_at", "user_id", "username", "book_id", "title", "content", "rating", "version", "updated_at"},
+			Rows:    [][]driver.Value{{int64(3), time.Now(), int64(7), "reader", int64(1), "Dune", "A classic.", int64(5), int64(1), time.Now()}},
+		}
+	})
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: stubListColumns,
+			Rows: [][]driver.Value{
+				stubListed(stubBook(1, "Dune", "Frank Herbert"), "1", 2),
+				stubListed(stubBook(2, "Emma", "Jane Austen"), "2", 2),
+			},
+		}
+	})
+	res, body := serve(t, app, model.AnonymousUser, "GET", "/api/v1/books?fields=id,title&include=reviews", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v, want 200", res.StatusCode, body)
+	}
+	if reviewedBooks != "{1,2}" {
+		t.Errorf("looked up the reviews of books %v, want {1,2} in one query", reviewedBooks)
+	}
+	books := body["books"].([]interface{})
+	dune, emma := books[0].(map[string]interface{}), books[1].(map[string]interface{})
+	if len(dune) != 3 || dune["title"] != "Dune" || len(dune["reviews"].([]interface{})) != 1 {
+		t.Errorf("got %v, want the id, title and one review of Dune", dune)
+	}
+	if reviews, ok := emma["reviews"].([]interface{}); !ok || len(reviews) != 0 {
+		t.Errorf("got reviews %v for Emma, want an empty list", emma["reviews"])
+	}
+}
+
+func TestFieldsMustBeKnown(t *testing.T) {
+	app, _ := newTestApplication(t)
+	for _, path := range []string{"/api/v1/books?fields=id,isbn", "/api/v1/books/1?include=publishers"} {
+		res, body := serve(t, app, model.AnonymousUser, "GET", path, "")
+		if res.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("GET %s: got %d %v, want 422", path, res.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+// Representations list endpoints can be served in, with their media types.
+const (
+	formatJSON   = "json"
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+var listFormats = []string{formatJSON, formatCSV, formatNDJSON}
+
+var formatMediaTypes = map[string]string{
+	formatJSON:   "application/json",
+	formatCSV:    "text/csv",
+	formatNDJSON: "application/x-ndjson",
+}
+
+// acceptEntry is one member of an Accept or Accept-Encoding header.
+type acceptEntry struct {
+	value string
+	q     float64
+}
+
+// parseAccept splits an Accept-style header into its values and qualities,
+// ordered from most to least preferred.
+func parseAccept(header string) []acceptEntry {
+	var entries []acceptEntry
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		entry := acceptEntry{value: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		if entry.value == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.TrimSpace(name) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					entry.q = q
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].q > entries[j].q })
+	return entries
+}
+
+// readListFormat picks the representation of a listing: the format query
+// parameter wins over the Accept header, and JSON is the fallback.
+func (app *application) readListFormat(w http.ResponseWriter, r *http.Request, v *validator.Validator) string {
+	w.Header().Add("Vary", "Accept")
+	qs := r.URL.Query()
+	if format := qs.Get("format"); format != "" {
+		v.Var("format", format, "oneof="+strings.Join(listFormats, " "))
+		return format
+	}
+	for _, entry := range parseAccept(r.Header.Get("Accept")) {
+		if entry.q <= 0 {
+			continue
+		}
+		for _, format := range listFormats {
+			if entry.value == formatMediaTypes[format] {
+				return format
+			}
+		}
+		if entry.value == "*/*" || entry.value == "application/*" {
+			return formatJSON
+		}
+	}
+	return formatJSON
+}
+
+// writeList sends env as JSON, or only the records under env[key] as CSV or
+// NDJSON. columns sets the order of the CSV columns; pagination stays available
+// through the Link header.
+func (app *application) writeList(w http.ResponseWriter, r *http.Request, format string, env envelope, key string, columns []string, headers http.Header) error {
+	if format == formatJSON {
+		return app.writeJSON(w, r, http.StatusOK, env, headers)
+	}
+	for name, value := range headers {
+		w.Header()[name] = value
+	}
+	w.Header().Set("Content-Type", formatContentType(format))
+	w.WriteHeader(http.StatusOK)
+
+	enc, err := newRecordEncoder(w, format, columns)
+	if err != nil {
+		return err
+	}
+	records := reflect.ValueOf(env[key])
+	for i := 0; i < records.Len(); i++ {
+		if err := enc.Encode(records.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return enc.Flush()
+}
+
+// formatContentType returns the Content-Type header for a representation.
+func formatContentType(format string) string {
+	if format == formatCSV {
+		return formatMediaTypes[formatCSV] + "; charset=utf-8"
+	}
+	return formatMediaTypes[format]
+}
+
+// recordEncoder writes records one at a time in one of the streaming formats.
+type recordEncoder interface {
+	Encode(record interface{}) error
+	Flush() error
+}
+
+// newRecordEncoder returns an encoder writing NDJSON or CSV to w. CSV output
+// starts with a header row naming columns.
+func newRecordEncoder(w io.Writer, format string, columns []string) (recordEncoder, error) {
+	switch format {
+	case formatNDJSON:
+		return ndjsonEncoder{json.NewEncoder(w)}, nil
+	case formatCSV:
+		enc := &csvEncoder{w: csv.NewWriter(w), columns: columns, row: make([]string, len(columns))}
+		return enc, enc.w.Write(columns)
+	}
+	return nil, fmt.Errorf("no record encoder for %q", format)
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+// Encode writes record on a line of its own, keeping its member order.
+func (e ndjsonEncoder) Encode(record interface{}) error {
+	return e.enc.Encode(record)
+}
+
+func (e ndjsonEncoder) Flush() error {
+	return nil
+}
+
+type csvEncoder struct {
+	w       *csv.Writer
+	columns []string
+	row     []string
+}
+
+func (e *csvEncoder) Encode(record interface{}) error {
+	js, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	var res resource
+	if err := json.Unmarshal(js, &res); err != nil {
+		return err
+	}
+	for i, column := range e.columns {
+		e.row[i] = csvCell(res[column])
+	}
+	return e.w.Write(e.row)
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvCell flattens a JSON value into a spreadsheet cell: strings lose their
+// quotes, lists of strings are joined with commas, null is empty, and nested
+// objects are kept as JSON.
+func csvCell(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return csvText(s)
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		for i := range list {
+			list[i] = csvText(list[i])
+		}
+		return strings.Join(list, ", ")
+	}
+	return string(raw)
+}
+
+// csvText defuses text that spreadsheets would evaluate as a formula.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// parseCSVText undoes csvText, so that exported files can be imported again.
+func parseCSVText(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
+
+// listColumns returns the CSV columns of a listing: the requested fields, or
+// all of them, followed by the embedded resources.
+func listColumns(record interface{}, fields, include []string) []string {
+	columns := fields
+	if len(columns) == 0 {
+		columns = jsonFields(record)
+	}
+	return append(append([]string{}, columns...), include...)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+)
+
+func TestParseAccept(t *testing.T) {
+	got := parseAccept("text/html;q=0.5, application/x-ndjson, TEXT/CSV ; q=0.9, ,*/*;q=0")
+	want := []acceptEntry{{"application/x-ndjson", 1}, {"text/csv", 0.9}, {"text/html", 0.5}, {"*/*", 0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCSVTextDefusesFormulas(t *testing.T) {
+	tests := map[string]string{"=HYPERLINK()": "'=HYPERLINK()", "+1": "'+1", "-": "'-", "@home": "'@home", "plain": "plain", "": ""}
+	for s, want := range tests {
+		if got := csvText(s); got != want {
+			t.Errorf("csvText(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestCSVTextRoundTrips(t *testing.T) {
+	for _, s := range []string{"=SUM(A1)", "+1", "-", "@home", "'quoted", "plain", ""} {
+		if got := parseCSVText(csvText(s)); got != s {
+			t.Errorf("parseCSVText(csvText(%q)) = %q", s, got)
+		}
+	}
+	if got := csvText("=HYPERLINK()"); got != "'=HYPERLINK()" {
+		t.Errorf("csvText didn't defuse a formula: %q", got)
+	}
+}
+
+func TestCSVCell(t *testing.T) {
+	tests := map[string]string{
+		`"Dune"`:                 "Dune",
+		`["sci-fi", "=classic"]`: "sci-fi, '=classic",
+		`{"id":1}`:               `{"id":1}`,
+		`1965`:                   "1965",
+		`null`:                   "",
+	}
+	for raw, want := range tests {
+		if got := csvCell(json.RawMessage(raw)); got != want {
+			t.Errorf("csvCell(%s) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestWriteListAsCSVAndNDJSON(t *testing.T) {
+	app, _ := newTestApplication(t)
+	env := envelope{"books": []map[string]interface{}{
+		{"title": "Dune", "genres": []string{"sci-fi"}, "year": 1965},
+		{"title": "-30-", "genres": nil},
+	}}
+	tests := []struct {
+		format, contentType, want string
+	}{
+		{formatCSV, "text/csv; charset=utf-8", "title,genres,year\nDune,sci-fi,1965\n'-30-,,\n"},
+		{formatNDJSON, "application/x-ndjson", `{"genres":["sci-fi"],"title":"Dune","year":1965}` + "\n" + `{"genres":null,"title":"-30-"}` + "\n"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/books", nil)
+		w := httptest.NewRecorder()
+		if err := app.writeList(w, r, tt.format, env, "books", []string{"title", "genres", "year"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: Content-Type = %q, want %q", tt.format, got, tt.contentType)
+		}
+		if w.Body.String() != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.format, w.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestListBooksFormat(t *testing.T) {
+	tests := []struct {
+		query, accept, want string
+	}{
+		{"", "", "application/json"},
+		{"", "text/csv", "text/csv; charset=utf-8"},
+		{"", "text/html, application/x-ndjson;q=0.5", "application/x-ndjson"},
+		{"?format=csv", "application/x-ndjson", "text/csv; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		app, db := newTestApplication(t)
+		stubFreshness(db)
+		db.On("FROM books", func([]driver.NamedValue) stubResult {
+			return stubResult{Columns: stubListColumns, Rows: [][]driver.Value{stubListed(stubBook(1, "Dune", "Frank Herbert"), "1", 1)}}
+		})
+		r := httptest.NewRequest("GET", "/api/v1/books"+tt.query, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, app.contextSetUser(r, model.AnonymousUser))
+		if got := w.Header().Get("Content-Type"); w.Code != http.StatusOK || got != tt.want {
+			t.Errorf("%q, Accept %q: got %d %q, want %q", tt.query, tt.accept, w.Code, got, tt.want)
+		}
+	}
+	app, _ := newTestApplication(t)
+	res, body := serve(t, app, model.AnonymousUser, "GET", "/api/v1/books?format=xml", "")
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("format=xml: got %d %v, want 422", res.StatusCode, body)
+	}
+}
+func TestCSVEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc, err := newRecordEncoder(&buf, formatCSV, []string{"title", "genres", "series", "year"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []interface{}{
+		map[string]interface{}{"title": "Dune", "genres": []string{"sci-fi", "=classic"}, "series": map[string]int{"id": 1}, "year": 1965},
+		map[string]interface{}{"title": "-30-", "genres": nil},
+	}
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "title,genres,series,year\n" +
+		"Dune,\"sci-fi, '=classic\",\"{\"\"id\"\":1}\",1965\n" +
+		"'-30-,,,\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+// genreInput holds the fields of a genre clients can write. A null parent_id
+// makes the genre a top-level one.
+type genreInput struct {
+	Name     string `json:"name"`
+	ParentID *int64 `json:"parent_id"`
+}
+
+func (input genreInput) applyTo(genre *model.Genre) {
+	genre.Name = input.Name
+	genre.ParentID = input.ParentID
+}
+
+func inputFromGenre(genre *model.Genre) genreInput {
+	return genreInput{Name: genre.Name, ParentID: genre.ParentID}
+}
+
+// genreErrorResponse reports the failures of saving a genre that are caused by
+// what the client sent.
+func (app *application) genreErrorResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator, err error) {
+	switch {
+	case errors.Is(err, model.ErrDuplicateAlias):
+		v.Fail("name", "genre_exists", "a genre with this name already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, model.ErrUnknownGenre):
+		v.Fail("parent_id", "unknown_genre", "must be an existing genre")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, model.ErrGenreCycle):
+		v.Fail("parent_id", "genre_cycle", "must not be the genre itself or one of its subgenres")
+		app.failedValidationResponse(w, r, v.Errors)
+	case errors.Is(err, model.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listGenresHandler serves the taxonomy as a tree, with the number of books
+// filed under each genre.
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	catalog, err := app.catalogState()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.notModified(w, r, entityTag(r, catalog...), time.Time{}) {
+		return
+	}
+	tree, err := app.models.Genres.Tree()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"genres": tree}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var input genreInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	genre := &model.Genre{}
+	input.applyTo(genre)
+
+	v := validator.New()
+	if model.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Genres.Insert(genre, app.contextGetUser(r).ID)
+	if err != nil {
+		app.genreErrorResponse(w, r, v, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/genres/%d", genre.ID))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"genre": genre}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	genre, err := app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if app.notModified(w, r, entityTag(r, genre.Version), genre.UpdatedAt) {
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateGenreHandler renames a genre or moves it in the tree. Books filed under
+// the genre are renamed with it, and the old name stays one of its aliases.
+func (app *application) updateGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	genre, err := app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var input genreInput
+	err = app.readPatch(w, r, inputFromGenre(genre), &input)
+	if err != nil {
+		app.patchErrorResponse(w, r, err)
+		return
+	}
+	input.applyTo(genre)
+
+	v := validator.New()
+	if model.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Genres.Update(genre, app.contextGetUser(r).ID)
+	if err != nil {
+		app.genreErrorResponse(w, r, v, err)
+		return
+	}
+	app.showGenre(w, r, genre.ID)
+}
+
+// addGenreAliasHandler maps another spelling to a genre. Books filed under the
+// spelling are moved to the genre.
+func (app *application) addGenreAliasHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Name string `json:"name"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if v.Var("name", input.Name, "required,max=50"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Genres.AddAlias(id, input.Name, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.genreErrorResponse(w, r, v, err)
+		}
+		return
+	}
+	app.showGenre(w, r, id)
+}
+
+// mergeGenreHandler folds another genre, typically a duplicate spelling that
+// normalizes differently, into a genre.
+func (app *application) mergeGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		GenreID int64 `json:"genre_id"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	_, err = app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	v := validator.New()
+	v.Var("genre_id", input.GenreID, "min=1")
+	v.Check(input.GenreID != id, "genre_id", "must not be the genre itself")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Genres.Merge(id, input.GenreID, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrUnknownGenre):
+			v.Fail("genre_id", "unknown_genre", "must be an existing genre")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.showGenre(w, r, id)
+}
+
+// showGenre responds with the current state of a genre after it was changed.
+func (app *application) showGenre(w http.ResponseWriter, r *http.Request, id int64) {
+	genre, err := app.models.Genres.Get(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
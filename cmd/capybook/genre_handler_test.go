@@ -0,0 +1,30 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCreateGenreReportsATakenName(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	db.On("set_config", func([]driver.NamedValue) stubResult { return stubResult{} })
+	db.On("INSERT INTO genres", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"id", "version", "updated_at"}, Rows: [][]driver.Value{{int64(3), int64(1), time.Now()}}}
+	})
+	// The name is already an alias of genre 1.
+	db.On("INSERT INTO genre_aliases", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"genre_id"}, Rows: [][]driver.Value{{int64(1)}}}
+	})
+	res, js := serve(t, app, activatedUser, "POST", "/api/v1/genres", `{"name": "Fantasy"}`)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("got %d %v, want 422", res.StatusCode, js)
+	}
+	params, _ := js["invalid_params"].([]interface{})
+	want := map[string]interface{}{"name": "name", "code": "genre_exists", "reason": "a genre with this name already exists"}
+	if len(params) != 1 || !reflect.DeepEqual(params[0], want) {
+		t.Errorf("got invalid_params %v, want [%v]", params, want)
+	}
+}
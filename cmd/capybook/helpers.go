@@ -1,24 +1,49 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/shyndaliu/capybook/pkg/capybook/i18n"
 	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/patch"
 	"github.com/shyndaliu/capybook/pkg/capybook/validator"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey      = contextKey("user")
+	requestIDContextKey = contextKey("request_id")
+	localeContextKey    = contextKey("locale")
+)
+
+// requestError is a problem with the request itself whose message can be
+// localized: code selects the "request.<code>" catalog entry and params fill in
+// its placeholders.
+type requestError struct {
+	code   string
+	params []string
+}
+
+func newRequestError(code string, params ...string) *requestError {
+	return &requestError{code: code, params: params}
+}
+
+func (e *requestError) Error() string {
+	return i18n.T(i18n.Default, "request."+e.code, e.params...)
+}
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
 	param := mux.Vars(r)["id"]
@@ -34,8 +59,16 @@ func (app *application) readUsernameParam(r *http.Request) (string, error) {
 	return username, nil
 }
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, headers http.Header) error {
-	js, err := json.MarshalIndent(data, "", "\t")
+// writeJSON sends data as compact JSON, or indented when the request has
+// ?pretty=true.
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data interface{}, headers http.Header) error {
+	var js []byte
+	var err error
+	if pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty")); pretty {
+		js, err = json.MarshalIndent(data, "", "\t")
+	} else {
+		js, err = json.Marshal(data)
+	}
 	if err != nil {
 		return err
 	}
@@ -43,16 +76,26 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
-	w.Header().Set("Content-Type", "application/json")
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(status)
 	w.Write(js)
 	return nil
 }
+
+// maxBodyBytes caps the size of JSON request bodies.
+const maxBodyBytes = 1_048_576
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	return decodeJSON(r.Body, dst)
+}
 
-	maxBytes := 1_048_576
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
-	dec := json.NewDecoder(r.Body)
+// decodeJSON decodes the single JSON value in body into dst, translating
+// decoding failures into requestErrors.
+func decodeJSON(body io.Reader, dst interface{}) error {
+	dec := json.NewDecoder(body)
 	dec.DisallowUnknownFields()
 	// Decode the request body to the destination.
 	err := dec.Decode(dst)
@@ -62,21 +105,21 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 		var invalidUnmarshalError *json.InvalidUnmarshalError
 		switch {
 		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+			return newRequestError("malformed_json_at", "offset", strconv.FormatInt(syntaxError.Offset, 10))
 		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
+			return newRequestError("malformed_json")
 		case errors.As(err, &unmarshalTypeError):
 			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+				return newRequestError("wrong_type_field", "field", unmarshalTypeError.Field)
 			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+			return newRequestError("wrong_type_at", "offset", strconv.FormatInt(unmarshalTypeError.Offset, 10))
 		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+			return newRequestError("empty_body")
 		case strings.HasPrefix(err.Error(), "json: unknown field "):
 			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
+			return newRequestError("unknown_key", "key", fieldName)
 		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+			return newRequestError("body_too_large", "bytes", strconv.Itoa(maxBodyBytes))
 		case errors.As(err, &invalidUnmarshalError):
 			panic(err)
 		default:
@@ -85,11 +128,67 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	}
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
-		return errors.New("body must only contain a single JSON value")
+		return newRequestError("multiple_values")
 	}
 	return nil
 }
 
+// Media types accepted by PATCH endpoints. Plain application/json bodies are
+// treated as merge patches, which is what the endpoints always accepted.
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+var patchMediaTypes = []string{mergePatchMediaType, jsonPatchMediaType, "application/json"}
+
+var errUnsupportedPatchType = errors.New("unsupported patch media type")
+
+// readPatch applies the patch in the request body to current, the patchable
+// representation of a resource, and decodes the result into dst. dst should be
+// a fresh value of the same type so that removed members come out empty.
+func (app *application) readPatch(w http.ResponseWriter, r *http.Request, current, dst interface{}) error {
+	apply := patch.MergePatch
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return errUnsupportedPatchType
+		}
+	}
+	switch mediaType {
+	case mergePatchMediaType, "application/json":
+	case jsonPatchMediaType:
+		apply = patch.Apply
+	default:
+		return errUnsupportedPatchType
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	if err != nil {
+		if err.Error() == "http: request body too large" {
+			return newRequestError("body_too_large", "bytes", strconv.Itoa(maxBodyBytes))
+		}
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return newRequestError("empty_body")
+	}
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	doc, err = apply(doc, body)
+	if err != nil {
+		var patchErr *patch.Error
+		if errors.As(err, &patchErr) {
+			return newRequestError("invalid_patch", "reason", patchErr.Error())
+		}
+		return err
+	}
+	return decodeJSON(bytes.NewReader(doc), dst)
+}
+
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
 	s := qs.Get(key)
 	if s == "" {
@@ -120,13 +219,60 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// validator instance and return the default value.
 	i, err := strconv.Atoi(s)
 	if err != nil {
-		v.AddError(key, "must be an integer value")
+		v.Fail(key, "not_integer", "must be an integer value")
 		return defaultValue
 	}
 	// Otherwise, return the converted integer value.
 	return i
 }
 
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.Fail(key, "not_boolean", "must be true or false")
+		return defaultValue
+	}
+	return b
+}
+
+// paginationLinks builds an RFC 8288 Link header value pointing at the first,
+// previous, next and last pages of the listing requested by r. Cursor-paginated
+// listings only know their next page.
+func (app *application) paginationLinks(r *http.Request, metadata model.Metadata) string {
+	link := func(key, value, rel string) string {
+		u := *r.URL
+		qs := u.Query()
+		qs.Set(key, value)
+		if key == "cursor" {
+			qs.Del("page")
+		}
+		u.RawQuery = qs.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+	}
+	if r.URL.Query().Get("cursor") != "" {
+		if metadata.NextCursor == "" {
+			return ""
+		}
+		return link("cursor", metadata.NextCursor, "next")
+	}
+	if metadata.TotalRecords == 0 {
+		return ""
+	}
+	links := []string{link("page", strconv.Itoa(metadata.FirstPage), "first")}
+	if metadata.CurrentPage > metadata.FirstPage {
+		links = append(links, link("page", strconv.Itoa(metadata.CurrentPage-1), "prev"))
+	}
+	if metadata.CurrentPage < metadata.LastPage {
+		links = append(links, link("page", strconv.Itoa(metadata.CurrentPage+1), "next"))
+	}
+	links = append(links, link("page", strconv.Itoa(metadata.LastPage), "last"))
+	return strings.Join(links, ", ")
+}
+
 func (app *application) contextSetUser(r *http.Request, user *model.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
@@ -140,6 +286,33 @@ func (app *application) contextGetUser(r *http.Request) *model.User {
 	return user
 }
 
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the ID assigned to the request by the requestID
+// middleware, or an empty string outside of it.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+func (app *application) contextSetLocale(r *http.Request, locale string) *http.Request {
+	ctx := context.WithValue(r.Context(), localeContextKey, locale)
+	return r.WithContext(ctx)
+}
+
+// contextGetLocale returns the locale negotiated for the request, or the
+// default locale outside of the localize middleware.
+func (app *application) contextGetLocale(r *http.Request) string {
+	locale, ok := r.Context().Value(localeContextKey).(string)
+	if !ok {
+		return i18n.Default
+	}
+	return locale
+}
+
 func (app *application) background(fn func()) {
 	// Launch a background goroutine.
 	go func() {
@@ -153,3 +326,19 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// every runs job in the background now and then every interval for as long as
+// the server runs. A zero interval never runs it.
+func (app *application) every(interval time.Duration, job func()) {
+	if interval <= 0 {
+		return
+	}
+	app.background(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			job()
+			<-ticker.C
+		}
+	})
+}
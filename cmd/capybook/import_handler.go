@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+// Media types imports can be uploaded as, and the format each is read in.
+var importMediaTypes = map[string]string{
+	"text/csv":                formatCSV,
+	"application/x-ndjson":    formatNDJSON,
+	"application/jsonl":       formatNDJSON,
+	"application/x-jsonlines": formatNDJSON,
+}
+
+// maxImportBytes caps the size of an import uploaded over HTTP.
+const maxImportBytes = 32 << 20
+
+// importedBook is a row of an import: the book read from it, and any problems
+// reading it.
+type importedBook struct {
+	line   int
+	book   *model.Book
+	errors []validator.FieldError
+}
+
+// importRow reports a row that was not imported and why.
+type importRow struct {
+	Line            int            `json:"line"`
+	Status          string         `json:"status"`
+	DuplicateOf     int64          `json:"duplicate_of,omitempty"`
+	DuplicateOfLine int            `json:"duplicate_of_line,omitempty"`
+	Errors          []invalidParam `json:"errors,omitempty"`
+}
+
+// importReport sums up an import. Rows only lists the rows that were skipped,
+// in file order; in a dry run nothing is written but the counts are the same.
+type importReport struct {
+	DryRun     bool        `json:"dry_run"`
+	Total      int         `json:"total"`
+	Created    int         `json:"created"`
+	Duplicates int         `json:"duplicates"`
+	Invalid    int         `json:"invalid"`
+	Rows       []importRow `json:"rows"`
+}
+
+func malformedRow(line int, err error) importedBook {
+	return importedBook{line: line, errors: []validator.FieldError{
+		{Field: "row", Code: "malformed_row", Param: err.Error(), Message: "the row could not be read: " + err.Error()},
+	}}
+}
+
+// readImport reads the books of an import in CSV or NDJSON. Rows that can't be
+// read are kept with their errors; only an unreadable file is an error.
+func readImport(r io.Reader, format string) ([]importedBook, error) {
+	if format == formatCSV {
+		return readImportCSV(r)
+	}
+	return readImportNDJSON(r)
+}
+
+// readImportCSV reads a CSV file whose header names the columns, as written by
+// the CSV export. Unknown columns are ignored and genres are comma-separated.
+func readImportCSV(r io.Reader) ([]importedBook, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil, newRequestError("empty_body")
+		case errors.As(err, &parseErr):
+			return nil, newRequestError("malformed_csv", "line", strconv.Itoa(parseErr.Line))
+		}
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		// Spreadsheets like to start UTF-8 files with a byte order mark.
+		name = strings.TrimPrefix(name, "\ufeff")
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	var rows []importedBook
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, malformedRow(parseErr.StartLine, parseErr.Err))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		get := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return parseCSVText(strings.TrimSpace(record[i]))
+		}
+
+		row := importedBook{line: line}
+		input := bookInput{
+			Title:       get("title"),
+			Author:      get("author"),
+			Description: get("description"),
+			Language:    get("language"),
+		}
+		if year := get("year"); year != "" {
+			n, err := strconv.ParseInt(year, 10, 32)
+			if err != nil {
+				row.errors = append(row.errors, validator.FieldError{Field: "year", Code: "not_integer", Message: "must be an integer value"})
+			}
+			input.Year = int32(n)
+		}
+		if genres := get("genres"); genres != "" {
+			for _, genre := range strings.Split(genres, ",") {
+				if genre = parseCSVText(strings.TrimSpace(genre)); genre != "" {
+					input.Genres = append(input.Genres, genre)
+				}
+			}
+		}
+		row.book = input.newBook()
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// readImportNDJSON reads one JSON book per line, as written by the NDJSON
+// export. Blank lines are skipped and unknown members are ignored.
+func readImportNDJSON(r io.Reader) ([]importedBook, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxBodyBytes)
+	var rows []importedBook
+	for line := 1; sc.Scan(); line++ {
+		text := bytes.TrimSpace(sc.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var input bookInput
+		if err := json.Unmarshal(text, &input); err != nil {
+			rows = append(rows, malformedRow(line, err))
+			continue
+		}
+		rows = append(rows, importedBook{line: line, book: input.newBook()})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		return nil, newRequestError("empty_body")
+	}
+	return rows, nil
+}
+
+// importBooks validates every row with ValidateBook, skips the ones that
+// duplicate an existing book or an earlier row, and inserts the rest unless
+// dryRun is set, on behalf of userID. Errors in the report are localized for
+// locale.
+func (app *application) importBooks(rows []importedBook, dryRun bool, userID int64, locale string) (*importReport, error) {
+	report := &importReport{DryRun: dryRun, Total: len(rows), Rows: []importRow{}}
+	skip := func(row importRow) {
+		report.Rows = append(report.Rows, row)
+	}
+
+	var valid []importedBook
+	var keys []model.BookKey
+	for _, row := range rows {
+		if row.book != nil {
+			v := validator.New()
+			model.ValidateBook(v, row.book)
+			for _, e := range v.Errors {
+				// A field that couldn't be parsed already has its error.
+				if !hasFieldError(row.errors, e.Field) {
+					row.errors = append(row.errors, e)
+				}
+			}
+		}
+		if len(row.errors) > 0 {
+			params := make([]invalidParam, len(row.errors))
+			for i, e := range row.errors {
+				params[i] = invalidParam{Name: e.Field, Code: e.Code, Reason: localizeFieldError(locale, e)}
+			}
+			skip(importRow{Line: row.line, Status: "invalid", Errors: params})
+			report.Invalid++
+			continue
+		}
+		valid = append(valid, row)
+		keys = append(keys, row.book.Key())
+	}
+
+	existing := map[model.BookKey]int64{}
+	if len(keys) > 0 {
+		var err error
+		existing, err = app.models.Books.FindDuplicates(keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+	seen := make(map[model.BookKey]int)
+	var books []*model.Book
+	for _, row := range valid {
+		key := row.book.Key()
+		if id, ok := existing[key]; ok {
+			skip(importRow{Line: row.line, Status: "duplicate", DuplicateOf: id})
+			report.Duplicates++
+			continue
+		}
+		if line, ok := seen[key]; ok {
+			skip(importRow{Line: row.line, Status: "duplicate", DuplicateOfLine: line})
+			report.Duplicates++
+			continue
+		}
+		seen[key] = row.line
+		books = append(books, row.book)
+	}
+	sort.SliceStable(report.Rows, func(i, j int) bool { return report.Rows[i].Line < report.Rows[j].Line })
+
+	if !dryRun && len(books) > 0 {
+		if err := app.models.Books.InsertMany(books, userID); err != nil {
+			return nil, err
+		}
+	}
+	report.Created = len(books)
+	return report, nil
+}
+
+func hasFieldError(errors []validator.FieldError, field string) bool {
+	for _, e := range errors {
+		if e.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *application) importBooksHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	dryRun := app.readBool(r.URL.Query(), "dry_run", false, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	format, ok := importMediaTypes[mediaType]
+	if !ok {
+		supported := make([]string, 0, len(importMediaTypes))
+		for mediaType := range importMediaTypes {
+			supported = append(supported, mediaType)
+		}
+		sort.Strings(supported)
+		app.unsupportedMediaTypeResponse(w, r, supported...)
+		return
+	}
+
+	rows, err := readImport(http.MaxBytesReader(w, r.Body, maxImportBytes), format)
+	if err != nil {
+		if err.Error() == "http: request body too large" {
+			err = newRequestError("body_too_large", "bytes", strconv.Itoa(maxImportBytes))
+		}
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	report, err := app.importBooks(rows, dryRun, app.contextGetUser(r).ID, app.contextGetLocale(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadImportCSV(t *testing.T) {
+	file := "\ufeffTitle,author,year,genres,extra\n" +
+		"Dune,Frank Herbert,1965,\"sci-fi, '=classic\",x\n" +
+		"Emma,Jane Austen,soon,,\n" +
+		"\"Bad,quote\"x,Nobody,1900,,\n"
+	rows, err := readImport(strings.NewReader(file), formatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("read %d rows, want 3", len(rows))
+	}
+
+	dune := rows[0]
+	if dune.line != 2 || dune.book.Title != "Dune" || dune.book.Year != 1965 || !reflect.DeepEqual(dune.book.Genres, []string{"sci-fi", "=classic"}) {
+		t.Errorf("row 2: got line %d, %+v", dune.line, dune.book)
+	}
+	if emma := rows[1]; len(emma.errors) != 1 || emma.errors[0].Field != "year" || emma.errors[0].Code != "not_integer" {
+		t.Errorf("row 3: got errors %v, want a year that isn't an integer", emma.errors)
+	}
+	if bad := rows[2]; bad.book != nil || len(bad.errors) != 1 || bad.errors[0].Code != "malformed_row" {
+		t.Errorf("row 4: got %+v, want a malformed row", bad)
+	}
+}
+
+func TestReadImportNDJSON(t *testing.T) {
+	file := `{"title": "Dune", "author": "Frank Herbert", "unknown": true}` + "\n\n" + `{"title": ` + "\n"
+	rows, err := readImport(strings.NewReader(file), formatNDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].line != 1 || rows[0].book.Author != "Frank Herbert" || rows[1].line != 3 || rows[1].book != nil {
+		t.Errorf("got %+v", rows)
+	}
+
+	if _, err := readImport(strings.NewReader("\n\n"), formatNDJSON); err == nil {
+		t.Error("an empty file was read")
+	}
+}
+
+// postImport uploads an import of contentType as a user allowed to write books.
+func postImport(t *testing.T, app *application, query, contentType, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/api/v1/books/import"+query, strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, app.contextSetUser(r, activatedUser))
+	var js map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &js)
+	return w, js
+}
+
+func TestImportBooksDryRun(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	db.On("unnest($1::text[], $2::text[])", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"min", "lower", "lower"},
+			Rows:    [][]driver.Value{{int64(12), "dune", "frank herbert"}},
+		}
+	})
+	file := "title,author,year,description,genres,language\n" +
+		" DUNE ,Frank Herbert,1965,A desert planet.,novel,english\n" +
+		"Emma,Jane Austen,1815,A matchmaker.,novel,english\n" +
+		"emma,jane austen,1815,A matchmaker.,novel,english\n" +
+		",Nobody,1900,Nothing.,novel,english\n"
+	w, body := postImport(t, app, "?dry_run=true", "text/csv", file)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %v", w.Code, body)
+	}
+
+	var got struct {
+		Import importReport `json:"import"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	report := got.Import
+	if !report.DryRun || report.Total != 4 || report.Created != 1 || report.Duplicates != 2 || report.Invalid != 1 {
+		t.Errorf("got %+v", report)
+	}
+	want := []importRow{
+		{Line: 2, Status: "duplicate", DuplicateOf: 12},
+		{Line: 4, Status: "duplicate", DuplicateOfLine: 3},
+		{Line: 5, Status: "invalid"},
+	}
+	if len(report.Rows) != len(want) {
+		t.Fatalf("got rows %+v, want %+v", report.Rows, want)
+	}
+	for i, row := range report.Rows {
+		row.Errors = nil
+		if !reflect.DeepEqual(row, want[i]) {
+			t.Errorf("row %d: got %+v, want %+v", i, row, want[i])
+		}
+	}
+	if errs := report.Rows[2].Errors; len(errs) != 1 || errs[0].Name != "title" {
+		t.Errorf("got errors %v for the row without a title", errs)
+	}
+	if n := len(db.Logged("COPY")); n != 0 {
+		t.Errorf("a dry run copied %d times", n)
+	}
+}
+
+func TestImportBooksRejectsBadUploads(t *testing.T) {
+	tests := []struct {
+		query, contentType, body string
+		want                     int
+	}{
+		{"", "application/json", `[]`, http.StatusUnsupportedMediaType},
+		{"?dry_run=maybe", "text/csv", "title\n", http.StatusUnprocessableEntity},
+		{"", "text/csv", "", http.StatusBadRequest},
+		{"", "text/csv", "title,\"author\n", http.StatusBadRequest},
+		{"", "application/x-ndjson", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		app, _ := newTestApplication(t, "books:write")
+		w, body := postImport(t, app, tt.query, tt.contentType, tt.body)
+		if w.Code != tt.want {
+			t.Errorf("%s %q %q: got %d %v, want %d", tt.contentType, tt.query, tt.body, w.Code, body, tt.want)
+		}
+	}
+}
+
+func TestImportCommandUsage(t *testing.T) {
+	app, _ := newTestApplication(t)
+	for _, args := range [][]string{{"import"}, {"import", "reviews", "books.csv"}, {"import", "books"}, {"import", "books", "books.txt"}} {
+		if err := app.runCommand(args); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
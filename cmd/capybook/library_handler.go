@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/i18n"
+	"github.com/shyndaliu/capybook/pkg/capybook/isbn"
+	"github.com/shyndaliu/capybook/pkg/capybook/library"
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+// How many rows a library import processes between saving its progress.
+const libraryProgressEvery = 25
+
+// readUpload returns the file uploaded with a request, either as a text/csv
+// body or as the "file" field of a multipart form.
+func (app *application) readUpload(w http.ResponseWriter, r *http.Request) (io.Reader, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		return r.Body, nil
+	case "multipart/form-data":
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+		for {
+			part, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				return nil, newRequestError("empty_body")
+			}
+			if err != nil {
+				return nil, err
+			}
+			if part.FormName() == "file" {
+				return part, nil
+			}
+		}
+	}
+	return nil, errUnsupportedUpload
+}
+
+var errUnsupportedUpload = errors.New("unsupported upload content type")
+
+// importLibraryHandler accepts a Goodreads or StoryGraph library export and
+// imports it for the authenticated user in the background. The response
+// points at the job, which reports progress until it is done.
+func (app *application) importLibraryHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	v := validator.New()
+	source := app.readString(r.URL.Query(), "source", "")
+	if source != "" {
+		v.Var("source", source, "oneof="+strings.Join(library.Sources, " "))
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	body, err := app.readUpload(w, r)
+	var entries []library.Entry
+	if err == nil {
+		source, entries, err = library.Read(body, source)
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		switch {
+		case errors.Is(err, errUnsupportedUpload):
+			app.unsupportedMediaTypeResponse(w, r, "text/csv", "multipart/form-data")
+		case errors.Is(err, library.ErrUnknownSource):
+			app.badRequestResponse(w, r, newRequestError("unknown_library_export"))
+		case errors.As(err, &parseErr):
+			app.badRequestResponse(w, r, newRequestError("malformed_csv", "line", strconv.Itoa(parseErr.Line)))
+		case err.Error() == "http: request body too large":
+			app.badRequestResponse(w, r, newRequestError("body_too_large", "bytes", strconv.Itoa(maxImportBytes)))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	job := &model.ImportJob{UserID: user.ID, Source: source, Total: len(entries), Issues: []model.ImportIssue{}}
+	err = app.models.ImportJobs.Insert(job)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/library/imports/%d", job.ID))
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"import_job": job}, headers)
+	// The job is only handed over once the response is written, as it is
+	// updated while it runs.
+	app.background(func() {
+		app.runLibraryImport(job, entries)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showLibraryImportHandler reports the progress of one of the user's imports,
+// with the rows that were skipped so far.
+func (app *application) showLibraryImportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	job, err := app.models.ImportJobs.Get(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	locale := app.contextGetLocale(r)
+	for i := range job.Issues {
+		job.Issues[i].Reason = i18n.T(locale, "import."+job.Issues[i].Code)
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"import_job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runLibraryImport processes an import job and records how it ended.
+func (app *application) runLibraryImport(job *model.ImportJob, entries []library.Entry) {
+	if err := app.importLibrary(job, entries); err != nil {
+		app.logger.Printf("import job %d: %v", job.ID, err)
+		job.Status = model.ImportFailed
+	}
+	if err := app.models.ImportJobs.Update(job); err != nil {
+		app.logger.Printf("import job %d: %v", job.ID, err)
+	}
+}
+
+// libraryBooks holds the books of the catalog an import's entries may refer
+// to, by the ISBN of one of their editions and by title and author.
+type libraryBooks struct {
+	byISBN map[string]int64
+	byKey  map[model.BookKey]int64
+}
+
+// importLibrary turns the rated entries of a library into reviews by the
+// owner of job. Books are matched by ISBN first, then by title and author,
+// with and without the series Goodreads appends to titles; books that aren't
+// in the catalog yet are created as pending, for an editor to complete.
+func (app *application) importLibrary(job *model.ImportJob, entries []library.Entry) error {
+	job.Status = model.ImportRunning
+	if err := app.models.ImportJobs.Update(job); err != nil {
+		return err
+	}
+	var isbns []string
+	var keys []model.BookKey
+	for i, entry := range entries {
+		// Exports carry ISBNs as they were entered; ones that don't check out
+		// are ignored rather than stored.
+		entries[i].ISBN, _ = isbn.Normalize(entry.ISBN)
+		if entries[i].ISBN != "" {
+			isbns = append(isbns, entries[i].ISBN)
+		}
+		keys = append(keys, libraryKeys(entry)...)
+	}
+	books := libraryBooks{byISBN: map[string]int64{}, byKey: map[model.BookKey]int64{}}
+	var err error
+	if len(isbns) > 0 {
+		if books.byISBN, err = app.models.Editions.FindBooks(isbns); err != nil {
+			return err
+		}
+	}
+	if len(keys) > 0 {
+		if books.byKey, err = app.models.Books.FindDuplicates(keys); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		if err := app.importLibraryEntry(job, entry, books); err != nil {
+			return err
+		}
+		job.Processed++
+		if job.Processed%libraryProgressEvery == 0 {
+			if err := app.models.ImportJobs.Update(job); err != nil {
+				return err
+			}
+		}
+	}
+	job.Status = model.ImportCompleted
+	return nil
+}
+
+// libraryKeys returns the keys an entry's book may be found under: its title
+// as given, then without a series suffix.
+func libraryKeys(entry library.Entry) []model.BookKey {
+	keys := []model.BookKey{(&model.Book{Title: entry.Title, Author: entry.Author}).Key()}
+	if base := library.BaseTitle(entry.Title); base != entry.Title {
+		keys = append(keys, (&model.Book{Title: base, Author: entry.Author}).Key())
+	}
+	return keys
+}
+
+// importLibraryEntry imports a single entry. Entries that can't be imported
+// are recorded as issues of the job; only database failures are errors.
+func (app *application) importLibraryEntry(job *model.ImportJob, entry library.Entry, books libraryBooks) error {
+	skip := func(code string) error {
+		job.Issues = append(job.Issues, model.ImportIssue{Line: entry.Line, Title: entry.Title, Code: code})
+		job.Skipped++
+		return nil
+	}
+	if entry.Title == "" || entry.Author == "" {
+		return skip("missing_book")
+	}
+	// Reviews need a rating. Books that were only shelved have nothing to
+	// bring over, and are not worth reporting.
+	if entry.Rating == 0 {
+		if entry.Review == "" {
+			job.Skipped++
+			return nil
+		}
+		return skip("not_rated")
+	}
+	// Imported reviews are held to the same rules as the ones posted
+	// through the API.
+	review := &model.Review{AuthorId: job.UserID, Content: entry.Review, Rating: entry.Rating}
+	v := validator.New()
+	if model.ValidateReview(v, review); !v.Valid() {
+		return skip("review_" + v.Errors[0].Code)
+	}
+
+	keys := libraryKeys(entry)
+	bookID := books.byISBN[entry.ISBN]
+	for _, key := range keys {
+		if bookID != 0 {
+			break
+		}
+		bookID = books.byKey[key]
+	}
+	if bookID == 0 {
+		book := &model.Book{
+			Title:    library.BaseTitle(entry.Title),
+			Author:   entry.Author,
+			Year:     entry.Year,
+			Genres:   []string{},
+			Language: "english",
+			Pending:  true,
+		}
+		if err := app.models.Books.Insert(book, job.UserID); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			books.byKey[key] = book.ID
+		}
+		bookID = book.ID
+		job.CreatedBooks++
+		// The ISBN is the one thing known about the edition that was read, and
+		// lets later imports find the book.
+		if entry.ISBN != "" {
+			edition := &model.Edition{BookID: book.ID, ISBN13: entry.ISBN}
+			err := app.models.Editions.Insert(edition)
+			if err != nil && !errors.Is(err, model.ErrDuplicateISBN) {
+				return err
+			}
+			books.byISBN[entry.ISBN] = book.ID
+		}
+	} else {
+		job.Matched++
+		_, err := app.models.Reviews.Get(bookID, job.UserID)
+		switch {
+		case err == nil:
+			return skip("already_reviewed")
+		case !errors.Is(err, model.ErrRecordNotFound):
+			return err
+		}
+	}
+
+	review.BookId = bookID
+	if err := app.models.Reviews.Insert(review); err != nil {
+		return err
+	}
+	job.Reviews++
+	return nil
+}
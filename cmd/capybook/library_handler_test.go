@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/library"
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+)
+
+// upload posts a library export with the given content type.
+func upload(t *testing.T, app *application, contentType, body string) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/api/v1/library/imports", strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	r = app.contextSetUser(r, activatedUser)
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, r)
+	var js map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &js); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+	return w.Result(), js
+}
+
+func TestImportLibraryRejectsUnknownExports(t *testing.T) {
+	app, db := newTestApplication(t)
+	res, js := upload(t, app, "text/csv", "Name,Stars\nDune,5\n")
+	if res.StatusCode != http.StatusBadRequest || js["detail"] != "body is not a Goodreads or StoryGraph library export" {
+		t.Errorf("got %d %v, want 400 for an unknown export", res.StatusCode, js)
+	}
+	res, js = upload(t, app, "application/json", `{"books": []}`)
+	if res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("got %d %v, want 415 for JSON", res.StatusCode, js)
+	}
+	if got := db.Logged("import_jobs"); len(got) > 0 {
+		t.Errorf("a rejected upload created a job: %q", got)
+	}
+}
+
+func TestShowLibraryImportIsLimitedToItsOwner(t *testing.T) {
+	app, db := newTestApplication(t)
+	var owner interface{}
+	db.On("FROM import_jobs", func(args []driver.NamedValue) stubResult {
+		owner = args[1].Value
+		return stubResult{}
+	})
+	res, js := serve(t, app, activatedUser, "GET", "/api/v1/library/imports/5", "")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("got %d %v, want 404", res.StatusCode, js)
+	}
+	if owner != activatedUser.ID {
+		t.Errorf("looked the job up for user %v, want %d", owner, activatedUser.ID)
+	}
+}
+
+func TestImportLibraryEntryValidatesTheReview(t *testing.T) {
+	app, db := newTestApplication(t)
+	books := libraryBooks{byISBN: map[string]int64{}, byKey: map[model.BookKey]int64{}}
+	job := &model.ImportJob{UserID: activatedUser.ID}
+	entries := []library.Entry{
+		{Line: 2, Title: "Dune", Author: "Frank Herbert", Rating: 5},
+		{Line: 3, Title: "Emma", Author: "Jane Austen", Rating: 4, Review: "Loved it."},
+		{Line: 4, Title: "Ulysses", Author: "James Joyce", Rating: 3, Review: strings.Repeat("yes ", 300)},
+	}
+	for _, entry := range entries {
+		if err := app.importLibraryEntry(job, entry, books); err != nil {
+			t.Fatalf("importing line %d: %v", entry.Line, err)
+		}
+	}
+	want := []string{"review_too_short", "review_too_short", "review_too_long"}
+	if len(job.Issues) != len(want) {
+		t.Fatalf("got issues %+v, want %v", job.Issues, want)
+	}
+	for i, issue := range job.Issues {
+		if issue.Code != want[i] || issue.Line != entries[i].Line {
+			t.Errorf("issue %d is %+v, want %s on line %d", i, issue, want[i], entries[i].Line)
+		}
+	}
+	if job.Skipped != 3 || job.Reviews != 0 {
+		t.Errorf("skipped %d and imported %d reviews, want 3 and 0", job.Skipped, job.Reviews)
+	}
+	if got := db.Logged("INSERT"); len(got) > 0 {
+		t.Errorf("invalid reviews were written: %q", got)
+	}
+}
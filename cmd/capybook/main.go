@@ -15,6 +15,7 @@ import (
 	"github.com/shyndaliu/capybook/pkg/capybook/auth"
 	"github.com/shyndaliu/capybook/pkg/capybook/mailer"
 	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/storage"
 )
 
 type config struct {
@@ -35,14 +36,32 @@ type config struct {
 	jwt struct {
 		secret string
 	}
+	cache struct {
+		maxAge       time.Duration
+		sharedMaxAge time.Duration
+	}
+	storage struct {
+		backend string
+		dir     string
+		baseURL string
+		s3      storage.S3Config
+	}
+	duplicates struct {
+		interval  time.Duration
+		threshold float64
+	}
+	trash struct {
+		retention time.Duration
+	}
 }
 
 type application struct {
-	config config
-	logger *log.Logger
-	models model.Models
-	mailer mailer.Mailer
-	auth   auth.AuthService
+	config  config
+	logger  *log.Logger
+	models  model.Models
+	mailer  mailer.Mailer
+	auth    auth.AuthService
+	storage storage.Storage
 }
 
 func main() {
@@ -66,9 +85,32 @@ func main() {
 
 	flag.StringVar(&cfg.jwt.secret, "jwt-secret", os.Getenv("JWT_SECRET"), "JWT secret")
 
+	flag.DurationVar(&cfg.cache.maxAge, "cache-max-age", 0, "How long browsers may reuse anonymous responses without revalidating")
+	flag.DurationVar(&cfg.cache.sharedMaxAge, "cache-shared-max-age", time.Minute, "How long shared caches (CDNs) may reuse anonymous responses without revalidating")
+
+	flag.StringVar(&cfg.storage.backend, "storage", "local", "Where uploaded files are kept (local|s3)")
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./uploads", "Directory of the local storage")
+	flag.StringVar(&cfg.storage.baseURL, "storage-url", "/uploads", "Base URL uploaded files are served from; the local storage serves them itself when it is a path")
+	flag.StringVar(&cfg.storage.s3.Endpoint, "s3-endpoint", os.Getenv("S3_ENDPOINT"), "S3 endpoint URL")
+	flag.StringVar(&cfg.storage.s3.Region, "s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&cfg.storage.s3.Bucket, "s3-bucket", os.Getenv("S3_BUCKET"), "S3 bucket")
+	flag.StringVar(&cfg.storage.s3.AccessKey, "s3-access-key", os.Getenv("S3_ACCESS_KEY"), "S3 access key")
+	flag.StringVar(&cfg.storage.s3.SecretKey, "s3-secret-key", os.Getenv("S3_SECRET_KEY"), "S3 secret key")
+	flag.BoolVar(&cfg.storage.s3.PathStyle, "s3-path-style", false, "Address the S3 bucket in the path, as MinIO expects")
+
+	flag.DurationVar(&cfg.duplicates.interval, "duplicates-interval", 24*time.Hour, "How often to look for duplicate books (0 disables it)")
+	flag.Float64Var(&cfg.duplicates.threshold, "duplicates-threshold", 0.6, "Score from 0 to 1 two books need to be flagged as likely duplicates")
+
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted books, reviews and users stay in the trash before they are purged (0 keeps them)")
+
 	flag.Parse()
 
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	// Commands such as export may write their output to stdout.
+	logOutput := os.Stdout
+	if flag.NArg() > 0 {
+		logOutput = os.Stderr
+	}
+	logger := log.New(logOutput, "", log.Ldate|log.Ltime)
 
 	db, err := openDB(cfg)
 	if err != nil {
@@ -77,16 +119,59 @@ func main() {
 	defer db.Close()
 	logger.Printf("database connection pool established")
 
+	store, err := openStorage(cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: model.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		models:  model.NewModels(db),
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: store,
+	}
+
+	if flag.NArg() > 0 {
+		err = app.runCommand(flag.Args())
+		if err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+
+	interrupted, err := app.models.ImportJobs.FailUnfinished()
+	if err != nil {
+		logger.Printf("failing interrupted library imports: %v", err)
+	} else if interrupted > 0 {
+		logger.Printf("%d library imports were interrupted by a restart and marked failed", interrupted)
 	}
 
+	app.every(cfg.duplicates.interval, func() {
+		pending, err := app.detectDuplicates()
+		if err != nil {
+			logger.Printf("detecting duplicate books: %v", err)
+			return
+		}
+		logger.Printf("%d likely duplicate books awaiting review", pending)
+	})
+	if cfg.trash.retention > 0 {
+		app.every(time.Hour, func() {
+			books, reviews, users, err := app.purgeTrash()
+			if err != nil {
+				logger.Printf("purging the trash: %v", err)
+				return
+			}
+			if books+reviews+users > 0 {
+				logger.Printf("purged %d books, %d reviews and %d users from the trash", books, reviews, users)
+			}
+		})
+	}
+
+	// Exports outlast the write timeout, moving the deadline along as they stream.
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.port),
-		Handler:      app.authenticate(app.routes()),
+		Handler:      app.compress(app.requestID(app.localize(app.authenticate(app.routes())))),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
@@ -112,3 +197,18 @@ func openDB(cfg config) (*sql.DB, error) {
 	}
 	return db, nil
 }
+
+func openStorage(cfg config) (storage.Storage, error) {
+	switch cfg.storage.backend {
+	case "local":
+		return storage.NewLocal(cfg.storage.dir, cfg.storage.baseURL), nil
+	case "s3":
+		s3 := cfg.storage.s3
+		// The default only makes sense for the local storage.
+		if cfg.storage.baseURL != "/uploads" {
+			s3.BaseURL = cfg.storage.baseURL
+		}
+		return storage.NewS3(s3)
+	}
+	return nil, fmt.Errorf("unknown storage %q", cfg.storage.backend)
+}
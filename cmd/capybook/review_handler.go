@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/shyndaliu/capybook/pkg/capybook/model"
 	"github.com/shyndaliu/capybook/pkg/capybook/validator"
@@ -69,7 +70,7 @@ func (app *application) postReviewHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"review": review}, nil)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"review": review}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -80,7 +81,7 @@ func (app *application) listReviewsHandler(w http.ResponseWriter, r *http.Reques
 		app.notFoundResponse(w, r)
 		return
 	}
-	_, err = app.models.Books.Get(id)
+	book, err := app.models.Books.Get(id)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
@@ -88,28 +89,57 @@ func (app *application) listReviewsHandler(w http.ResponseWriter, r *http.Reques
 
 	var input struct {
 		model.Filters
+		Fields  []string
+		Include []string
+		Format  string
 	}
 	v := validator.New()
 	qs := r.URL.Query()
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.Limit = app.readInt(qs, "limit", 20, v)
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
 	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
 	input.Filters.SortSafelist = []string{"created_at", "-created_at", "rating", "-rating"}
+	input.Fields = app.readFieldList(qs, "fields", jsonFields(model.Review{}), v)
+	input.Include = app.readFieldList(qs, "include", []string{"book"}, v)
+	input.Format = app.readListFormat(w, r, v)
 
 	model.ValidateFilters(v, input.Filters)
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	// Every review carries the book's title, so the book's version counts too.
+	freshness, err := app.models.Reviews.Freshness(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.notModified(w, r, entityTag(r, book.Version, freshness, input.Format), time.Time{}) {
+		return
+	}
 
-	reviews, err := app.models.Reviews.GetAll(id, input.Filters)
+	reviews, metadata, err := app.models.Reviews.GetAll(id, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	// Send a JSON response containing the movie data.
-	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews}, nil)
+	env := envelope{"reviews": reviews, "metadata": metadata}
+	if len(input.Fields) > 0 || len(input.Include) > 0 {
+		resources, err := app.projectReviews(reviews, input.Fields, input.Include)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["reviews"] = resources
+	}
+	headers := make(http.Header)
+	if links := app.paginationLinks(r, metadata); links != "" {
+		headers.Set("Link", links)
+	}
+	columns := listColumns(model.Review{}, input.Fields, input.Include)
+	err = app.writeList(w, r, input.Format, env, "reviews", columns, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -130,18 +160,20 @@ func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
-	var input struct {
-		Content *string `json:"content"`
-		Rating  *int    `json:"rating"`
+	type reviewDocument struct {
+		Content string `json:"content"`
+		Rating  int    `json:"rating"`
 	}
-	err = app.readJSON(w, r, &input)
+	current := reviewDocument{Content: review.Content, Rating: review.Rating}
+	var input reviewDocument
+	err = app.readPatch(w, r, current, &input)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.patchErrorResponse(w, r, err)
 		return
 	}
 
-	review.Content = *input.Content
-	review.Rating = *input.Rating
+	review.Content = input.Content
+	review.Rating = input.Rating
 
 	v := validator.New()
 	model.ValidateReview(v, review)
@@ -152,10 +184,15 @@ func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Reque
 
 	err = app.models.Reviews.Update(review)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"review": review}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -177,7 +214,7 @@ func (app *application) deleteReviewHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "review successfully deleted"}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "review successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
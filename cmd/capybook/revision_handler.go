@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+func (app *application) readRevisionParam(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["revision"], 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid revision parameter")
+	}
+	return id, nil
+}
+
+// listRevisionsHandler serves the history of a book, newest change first by
+// default. The history of a deleted book can still be read.
+func (app *application) listRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		model.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.Limit = app.readInt(qs, "limit", 20, v)
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "-id"}
+
+	model.ValidateFilters(v, input.Filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	freshness, err := app.models.Revisions.Freshness(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if freshness.Count == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+	if app.notModified(w, r, entityTag(r, freshness), freshness.LastModified) {
+		return
+	}
+	revisions, metadata, err := app.models.Revisions.GetAll(id, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	if links := app.paginationLinks(r, metadata); links != "" {
+		headers.Set("Link", links)
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	revisionID, err := app.readRevisionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	revision, err := app.models.Revisions.Get(id, revisionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// Revisions never change, but the username of their author can.
+	author := ""
+	if revision.User != nil {
+		author = *revision.User
+	}
+	if app.notModified(w, r, entityTag(r, revision.ID, author), time.Time{}) {
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revertRevisionHandler restores the title, author, year, description, genres
+// and language of a book to what they were after a revision. The revert is a
+// change like any other, recorded as a new revision.
+func (app *application) revertRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	revisionID, err := app.readRevisionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	book, err := app.models.Books.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	revision, err := app.models.Revisions.Get(id, revisionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	revision.Snapshot.ApplyTo(book)
+
+	// The rules may have tightened since the revision was made.
+	v := validator.New()
+	if model.ValidateBook(v, book); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	book, err = app.models.Books.Update(book, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
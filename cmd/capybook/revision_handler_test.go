@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+)
+
+var stubRevisionColumns = []string{"id", "book_id", "version", "action", "username", "changes", "snapshot", "created_at"}
+
+func stubRevision(id int64, snapshot string) []driver.Value {
+	return []driver.Value{
+		id, int64(1), int64(1), "create", "alice",
+		[]byte(`{"title": {"old": null, "new": "Dune"}}`), []byte(snapshot), stubUpdatedAt,
+	}
+}
+
+func TestGetRevision(t *testing.T) {
+	app, db := newTestApplication(t)
+	db.On("FROM book_revisions", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: stubRevisionColumns, Rows: [][]driver.Value{stubRevision(3, `{"title": "Dune"}`)}}
+	})
+	res, body := serve(t, app, activatedUser, "GET", "/api/v1/books/1/revisions/3", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v, want 200", res.StatusCode, body)
+	}
+	revision, _ := body["revision"].(map[string]interface{})
+	if revision["user"] != "alice" || revision["action"] != "create" {
+		t.Errorf("revision = %v", revision)
+	}
+	if _, ok := revision["snapshot"]; ok {
+		t.Error("the snapshot was exposed")
+	}
+
+	res, _ = serve(t, app, activatedUser, "GET", "/api/v1/books/1/revisions/nope", "")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("a malformed revision: got %d, want 404", res.StatusCode)
+	}
+}
+
+// A revert writes the snapshot back as an ordinary update, attributed to the
+// user who made it.
+func TestRevertRevisionRestoresTheSnapshot(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	var editor interface{}
+	db.On("set_config", func(args []driver.NamedValue) stubResult {
+		editor = args[0].Value
+		return stubResult{}
+	})
+	var updated []driver.NamedValue
+	db.On("UPDATE books", func(args []driver.NamedValue) stubResult {
+		updated = args
+		return stubResult{
+			Columns: append(stubBookColumns, "author"),
+			Rows:    [][]driver.Value{append(stubBook(1, "Dune", "Frank Herbert"), "Frank Herbert")},
+		}
+	})
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: stubBookColumns, Rows: [][]driver.Value{stubBook(1, "Dune Messiah", "Frank Herbert")}}
+	})
+	db.On("FROM book_revisions", func([]driver.NamedValue) stubResult {
+		snapshot := `{"title": "Dune", "author": "Frank Herbert", "year": 1965, "description": "A desert planet.", "genres": ["novel"], "language": "english"}`
+		return stubResult{Columns: stubRevisionColumns, Rows: [][]driver.Value{stubRevision(3, snapshot)}}
+	})
+	res, body := serve(t, app, activatedUser, "POST", "/api/v1/books/1/revisions/3/revert", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v, want 200", res.StatusCode, body)
+	}
+	if updated == nil || updated[0].Value != "Dune" {
+		t.Errorf("updated with %v, want the title Dune", updated)
+	}
+	if editor != "7" {
+		t.Errorf("attributed the revert to %v, want user 7", editor)
+	}
+}
+
+// A snapshot that no longer passes validation is not restored.
+func TestRevertRevisionValidatesTheSnapshot(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: stubBookColumns, Rows: [][]driver.Value{stubBook(1, "Dune", "Frank Herbert")}}
+	})
+	db.On("FROM book_revisions", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: stubRevisionColumns, Rows: [][]driver.Value{stubRevision(3, `{"title": ""}`)}}
+	})
+	res, body := serve(t, app, activatedUser, "POST", "/api/v1/books/1/revisions/3/revert", "")
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got %d %v, want 422", res.StatusCode, body)
+	}
+	if got := db.Logged("UPDATE books"); len(got) != 0 {
+		t.Errorf("an invalid snapshot was written: %q", got)
+	}
+}
+
+func TestGetRevisionETagIsStable(t *testing.T) {
+	app, db := newTestApplication(t)
+	username := "librarian"
+	db.On("FROM book_revisions", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: stubRevisionColumns,
+			Rows:    [][]driver.Value{{int64(3), int64(1), int64(2), "update", username, []byte(`{}`), []byte(`{}`), time.Unix(0, 0)}},
+		}
+	})
+	first, _ := serve(t, app, model.AnonymousUser, "GET", "/api/v1/books/1/revisions/3", "")
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("got %d, want 200", first.StatusCode)
+	}
+	second, _ := serve(t, app, model.AnonymousUser, "GET", "/api/v1/books/1/revisions/3", "")
+	if first.Header.Get("ETag") != second.Header.Get("ETag") {
+		t.Errorf("ETag changed between identical requests: %s, %s", first.Header.Get("ETag"), second.Header.Get("ETag"))
+	}
+
+	username = "archivist"
+	renamed, _ := serve(t, app, model.AnonymousUser, "GET", "/api/v1/books/1/revisions/3", "")
+	if renamed.Header.Get("ETag") == first.Header.Get("ETag") {
+		t.Error("ETag did not change when the author was renamed")
+	}
+}
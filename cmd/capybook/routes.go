@@ -2,8 +2,10 @@ package main
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/shyndaliu/capybook/pkg/capybook/storage"
 )
 
 func (app *application) routes() *mux.Router {
@@ -16,15 +18,80 @@ func (app *application) routes() *mux.Router {
 
 	// Book Singleton
 	// Create a new book
-	v1.HandleFunc("/books", app.createBookHandler).Methods("POST")
+	v1.HandleFunc("/books", app.requirePermission("books:write", app.createBookHandler)).Methods("POST")
 	// List books
 	v1.HandleFunc("/books", app.listBooksHandler).Methods("GET")
+	// Suggest titles and authors for the search box
+	v1.HandleFunc("/books/autocomplete", app.autocompleteBooksHandler).Methods("GET")
+	// Import books in bulk from CSV or JSONL
+	v1.HandleFunc("/books/import", app.requirePermission("books:write", app.importBooksHandler)).Methods("POST")
 	//Get specific book
 	v1.HandleFunc("/books/{id}", app.getBookHandler).Methods("GET")
 	// Update a specific book
-	v1.HandleFunc("/books/{id}", app.updateBookHandler).Methods("PATCH")
+	v1.HandleFunc("/books/{id}", app.requirePermission("books:write", app.updateBookHandler)).Methods("PATCH")
 	// Delete a specific book
-	v1.HandleFunc("/books/{id}", app.deleteBookHandler).Methods("DELETE")
+	v1.HandleFunc("/books/{id}", app.requirePermission("books:write", app.deleteBookHandler)).Methods("DELETE")
+
+	//Editions
+	//List the editions of a book
+	v1.HandleFunc("/books/{id}/editions", app.listEditionsHandler).Methods("GET")
+	//Add an edition to a book
+	v1.HandleFunc("/books/{id}/editions", app.requirePermission("books:write", app.createEditionHandler)).Methods("POST")
+	//Get, update or delete a specific edition
+	v1.HandleFunc("/editions/{id}", app.getEditionHandler).Methods("GET")
+	v1.HandleFunc("/editions/{id}", app.requirePermission("books:write", app.updateEditionHandler)).Methods("PATCH")
+	v1.HandleFunc("/editions/{id}", app.requirePermission("books:write", app.deleteEditionHandler)).Methods("DELETE")
+	//Look an edition up by ISBN-10 or ISBN-13
+	v1.HandleFunc("/isbn/{isbn}", app.isbnLookupHandler).Methods("GET")
+	//Add an author, or get an author's page with their bibliography and ratings
+	v1.HandleFunc("/authors", app.requirePermission("books:write", app.createAuthorHandler)).Methods("POST")
+	v1.HandleFunc("/authors/{id}", app.showAuthorHandler).Methods("GET")
+	v1.HandleFunc("/authors/{id}", app.requirePermission("books:write", app.updateAuthorHandler)).Methods("PATCH")
+	//Record another name an author is known by
+	v1.HandleFunc("/authors/{id}/aliases", app.requirePermission("books:write", app.addAuthorAliasHandler)).Methods("POST")
+	//Replace the people credited on a book
+	v1.HandleFunc("/books/{id}/authors", app.requirePermission("books:write", app.setBookAuthorsHandler)).Methods("PUT")
+	//Add a series, or get a series with its volumes in reading order
+	v1.HandleFunc("/series", app.requirePermission("books:write", app.createSeriesHandler)).Methods("POST")
+	v1.HandleFunc("/series/{id}", app.showSeriesHandler).Methods("GET")
+	v1.HandleFunc("/series/{id}", app.requirePermission("books:write", app.updateSeriesHandler)).Methods("PATCH")
+	//Place a book in a series, or take it out
+	v1.HandleFunc("/books/{id}/series", app.requirePermission("books:write", app.setBookSeriesHandler)).Methods("PUT")
+	v1.HandleFunc("/books/{id}/series", app.requirePermission("books:write", app.removeBookSeriesHandler)).Methods("DELETE")
+	//Browse the genre taxonomy, or add to it
+	v1.HandleFunc("/genres", app.listGenresHandler).Methods("GET")
+	v1.HandleFunc("/genres", app.requirePermission("books:write", app.createGenreHandler)).Methods("POST")
+	v1.HandleFunc("/genres/{id}", app.getGenreHandler).Methods("GET")
+	v1.HandleFunc("/genres/{id}", app.requirePermission("books:write", app.updateGenreHandler)).Methods("PATCH")
+	//Map another spelling to a genre, or fold another genre into it
+	v1.HandleFunc("/genres/{id}/aliases", app.requirePermission("books:write", app.addGenreAliasHandler)).Methods("POST")
+	v1.HandleFunc("/genres/{id}/merge", app.requirePermission("books:write", app.mergeGenreHandler)).Methods("POST")
+	//Suggest a new book or a change to one, and review the suggestions
+	v1.HandleFunc("/suggestions", app.requireActivatedUser(app.createSuggestionHandler)).Methods("POST")
+	v1.HandleFunc("/suggestions", app.requireActivatedUser(app.listSuggestionsHandler)).Methods("GET")
+	v1.HandleFunc("/suggestions/{id}", app.requireActivatedUser(app.getSuggestionHandler)).Methods("GET")
+	v1.HandleFunc("/suggestions/{id}/review", app.requirePermission("books:write", app.reviewSuggestionHandler)).Methods("POST")
+	//Likely duplicate books, and merging a duplicate into a book
+	v1.HandleFunc("/duplicates", app.requirePermission("books:write", app.listDuplicatesHandler)).Methods("GET")
+	v1.HandleFunc("/duplicates/{id}", app.requirePermission("books:write", app.updateDuplicateHandler)).Methods("PATCH")
+	v1.HandleFunc("/books/{id}/merge", app.requirePermission("books:write", app.mergeBookHandler)).Methods("POST")
+	//Book history: every change to a book, and reverting to an earlier one
+	v1.HandleFunc("/books/{id}/revisions", app.listRevisionsHandler).Methods("GET")
+	v1.HandleFunc("/books/{id}/revisions/{revision}", app.getRevisionHandler).Methods("GET")
+	v1.HandleFunc("/books/{id}/revisions/{revision}/revert", app.requirePermission("books:write", app.revertRevisionHandler)).Methods("POST")
+	//Community tags: attach a tag to a book, vote for it, or withdraw the vote
+	v1.HandleFunc("/books/{id}/tags", app.listBookTagsHandler).Methods("GET")
+	v1.HandleFunc("/books/{id}/tags", app.requireActivatedUser(app.addBookTagHandler)).Methods("POST")
+	v1.HandleFunc("/books/{id}/tags/{tag}/vote", app.requireActivatedUser(app.voteBookTagHandler)).Methods("PUT")
+	v1.HandleFunc("/books/{id}/tags/{tag}/vote", app.requireActivatedUser(app.unvoteBookTagHandler)).Methods("DELETE")
+	v1.HandleFunc("/tags", app.listTagsHandler).Methods("GET")
+	//Moderate tags: remove a tag from a book, or ban it everywhere
+	v1.HandleFunc("/books/{id}/tags/{tag}", app.requirePermission("tags:moderate", app.removeBookTagHandler)).Methods("DELETE")
+	v1.HandleFunc("/tags/banned", app.requirePermission("tags:moderate", app.listBannedTagsHandler)).Methods("GET")
+	v1.HandleFunc("/tags/{tag}", app.requirePermission("tags:moderate", app.updateTagHandler)).Methods("PATCH")
+	//Upload or remove the cover of a book
+	v1.HandleFunc("/books/{id}/cover", app.requirePermission("books:write", app.uploadCoverHandler)).Methods("PUT", "POST")
+	v1.HandleFunc("/books/{id}/cover", app.requirePermission("books:write", app.deleteCoverHandler)).Methods("DELETE")
 
 	//Users
 	//Register new user
@@ -52,5 +119,28 @@ func (app *application) routes() *mux.Router {
 	//Delete review
 	v1.HandleFunc("/books/{id}/reviews", app.deleteReviewHandler).Methods("DELETE")
 
-	return v1
+	//Library imports from other sites
+	v1.HandleFunc("/library/imports", app.requireActivatedUser(app.importLibraryHandler)).Methods("POST")
+	v1.HandleFunc("/library/imports/{id}", app.requireActivatedUser(app.showLibraryImportHandler)).Methods("GET")
+
+	//Trash: deleted books, reviews and users until they are purged, and restoring them
+	v1.HandleFunc("/trash/books", app.requirePermission("admin:read", app.listTrashedBooksHandler)).Methods("GET")
+	v1.HandleFunc("/trash/books/{id}/restore", app.requirePermission("books:write", app.restoreBookHandler)).Methods("POST")
+	v1.HandleFunc("/trash/reviews", app.requirePermission("admin:read", app.listTrashedReviewsHandler)).Methods("GET")
+	v1.HandleFunc("/trash/reviews/{id}/restore", app.requirePermission("reviews:write", app.restoreReviewHandler)).Methods("POST")
+	v1.HandleFunc("/trash/users", app.requirePermission("admin:read", app.listTrashedUsersHandler)).Methods("GET")
+	v1.HandleFunc("/trash/users/{username}/restore", app.requirePermission("users:write", app.restoreUserHandler)).Methods("POST")
+
+	//Bulk export
+	v1.HandleFunc("/export/books", app.requirePermission("admin:read", app.exportBooksHandler)).Methods("GET")
+	v1.HandleFunc("/export/reviews", app.requirePermission("admin:read", app.exportReviewsHandler)).Methods("GET")
+
+	//Uploaded files, when the local storage serves them itself
+	if local, ok := app.storage.(*storage.Local); ok && strings.HasPrefix(app.config.storage.baseURL, "/") {
+		prefix := strings.TrimSuffix(app.config.storage.baseURL, "/") + "/"
+		r.PathPrefix(prefix).Handler(http.StripPrefix(prefix, local)).Methods("GET", "HEAD")
+	}
+	r.NotFoundHandler = v1.NotFoundHandler
+
+	return r
 }
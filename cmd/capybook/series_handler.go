@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+// seriesInput holds the fields of a series clients can write.
+type seriesInput struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+func (input seriesInput) applyTo(series *model.Series) {
+	series.Title = input.Title
+	series.Description = input.Description
+}
+
+func inputFromSeries(series *model.Series) seriesInput {
+	return seriesInput{Title: series.Title, Description: series.Description}
+}
+
+func (app *application) createSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	var input seriesInput
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	series := &model.Series{}
+	input.applyTo(series)
+
+	v := validator.New()
+	if model.ValidateSeries(v, series); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Series.Insert(series)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/series/%d", series.ID))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"series": series}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showSeriesHandler serves a series with its volumes in reading order, each
+// with its own ratings.
+func (app *application) showSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	series, err := app.models.Series.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	// The volumes change with the books and their reviews.
+	catalog, err := app.catalogState()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.notModified(w, r, entityTag(r, append([]interface{}{series.Version}, catalog...)...), time.Time{}) {
+		return
+	}
+	volumes, err := app.models.Series.Volumes(series.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"series": series, "volumes": volumes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	series, err := app.models.Series.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	var input seriesInput
+	err = app.readPatch(w, r, inputFromSeries(series), &input)
+	if err != nil {
+		app.patchErrorResponse(w, r, err)
+		return
+	}
+	input.applyTo(series)
+
+	v := validator.New()
+	if model.ValidateSeries(v, series); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Series.Update(series)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"series": series}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// setBookSeriesHandler places a book in a series, moving it out of the one it
+// was in.
+func (app *application) setBookSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		SeriesID int64   `json:"series_id"`
+		Position float64 `json:"position"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Var("series_id", input.SeriesID, "min=1")
+	v.Var("position", input.Position, "min=0,max=9999.99")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	book, err := app.models.Books.SetSeries(id, input.SeriesID, input.Position, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrUnknownSeries):
+			v.Fail("series_id", "unknown_series", "must be an existing series")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrDuplicatePosition):
+			v.Fail("position", "already_exists", "another book of the series is at this position")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeBookSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	book, err := app.models.Books.SetSeries(id, 0, 0, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
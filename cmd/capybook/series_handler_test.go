@@ -0,0 +1,143 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+)
+
+func TestShowSeriesListsVolumesInReadingOrder(t *testing.T) {
+	app, db := newTestApplication(t)
+	stubFreshness(db)
+	db.On("FROM series", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"id", "title", "description", "version", "updated_at"},
+			Rows:    [][]driver.Value{{int64(2), "Dune", "", int64(1), stubUpdatedAt}},
+		}
+	})
+	db.On("books.series_position", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"id", "title", "author", "year", "series_position", "count", "avg"},
+			Rows: [][]driver.Value{
+				{int64(1), "Dune", "Frank Herbert", int64(1965), "1", int64(3), "4.5"},
+				{int64(5), "Dune: Interlude", "Frank Herbert", int64(1966), "1.5", int64(0), "0"},
+			},
+		}
+	})
+	res, body := serve(t, app, model.AnonymousUser, "GET", "/api/v1/series/2", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v", res.StatusCode, body)
+	}
+	volumes := body["volumes"].([]interface{})
+	if len(volumes) != 2 || volumes[1].(map[string]interface{})["position"] != 1.5 || volumes[0].(map[string]interface{})["average_rating"] != 4.5 {
+		t.Errorf("got volumes %v", volumes)
+	}
+}
+
+func TestSetBookSeries(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	db.On("set_config", func([]driver.NamedValue) stubResult { return stubResult{} })
+	var args []driver.NamedValue
+	db.On("UPDATE books", func(a []driver.NamedValue) stubResult {
+		args = a
+		book := stubBook(1, "Dune", "Frank Herbert")
+		for i, column := range stubBookColumns {
+			if column == "series" {
+				book[i] = `{"id": 2, "title": "Dune", "position": 1}`
+			}
+		}
+		return stubResult{Columns: stubBookColumns, Rows: [][]driver.Value{book}}
+	})
+	res, body := serve(t, app, activatedUser, "PUT", "/api/v1/books/1/series", `{"series_id": 2, "position": 1}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v", res.StatusCode, body)
+	}
+	if series := body["book"].(map[string]interface{})["series"].(map[string]interface{}); series["title"] != "Dune" {
+		t.Errorf("got series %v", series)
+	}
+	if args[1].Value != int64(2) || args[2].Value != float64(1) {
+		t.Errorf("stored %v", args)
+	}
+}
+
+func TestSetBookSeriesValidation(t *testing.T) {
+	tests := []struct {
+		body, err, name, code string
+	}{
+		{`{"series_id": 0, "position": 1}`, "", "series_id", "too_small"},
+		{`{"series_id": 2, "position": -1}`, "", "position", "too_small"},
+		{`{"series_id": 404, "position": 1}`, `pq: insert or update on table "books" violates foreign key constraint "books_series_id_fkey"`, "series_id", "unknown_series"},
+		{`{"series_id": 2, "position": 1}`, `pq: duplicate key value violates unique constraint "books_series_position_idx"`, "position", "already_exists"},
+	}
+	for _, tt := range tests {
+		app, db := newTestApplication(t, "books:write")
+		db.On("set_config", func([]driver.NamedValue) stubResult { return stubResult{} })
+		db.On("UPDATE books", func([]driver.NamedValue) stubResult {
+			return stubResult{Err: errors.New(tt.err)}
+		})
+		res, body := serve(t, app, activatedUser, "PUT", "/api/v1/books/1/series", tt.body)
+		params, _ := body["invalid_params"].([]interface{})
+		if res.StatusCode != http.StatusUnprocessableEntity || len(params) != 1 {
+			t.Errorf("%s: got %d %v, want 422", tt.body, res.StatusCode, body)
+			continue
+		}
+		if param := params[0].(map[string]interface{}); param["name"] != tt.name || param["code"] != tt.code {
+			t.Errorf("%s: got %v, want %s %s", tt.body, param, tt.name, tt.code)
+		}
+	}
+}
+
+// Renaming a series changes what its books show, so a cached book in the
+// series must not revalidate.
+func TestGetBookRevalidatesAfterTheSeriesIsRenamed(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	title, version, updatedAt := "Dune Chronicles", int64(1), stubUpdatedAt
+	db.On("FROM books", func([]driver.NamedValue) stubResult {
+		book := stubBook(1, "Dune", "Frank Herbert")
+		book[10] = fmt.Sprintf(`{"id": 2, "title": %q, "position": 1, "version": %d, "updated_at": %q}`,
+			title, version, updatedAt.Format(time.RFC3339Nano))
+		return stubResult{Columns: stubBookColumns, Rows: [][]driver.Value{book}}
+	})
+	db.On("UPDATE series", func(args []driver.NamedValue) stubResult {
+		title, version, updatedAt = args[0].Value.(string), version+1, updatedAt.Add(time.Hour)
+		return stubResult{Columns: []string{"version", "updated_at"}, Rows: [][]driver.Value{{version, updatedAt}}}
+	})
+	db.On("FROM series", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"id", "title", "description", "version", "updated_at"},
+			Rows:    [][]driver.Value{{int64(2), title, "", version, updatedAt}},
+		}
+	})
+	get := func(header, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/v1/books/1", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		app.routes().ServeHTTP(w, app.contextSetUser(r, model.AnonymousUser))
+		return w
+	}
+	first := get("", "")
+	etag, lastModified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got %d with ETag %q, want 200 with a tag", first.Code, etag)
+	}
+
+	res, body := serve(t, app, activatedUser, "PATCH", "/api/v1/series/2", `{"title": "The Dune Saga"}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("renaming the series: got %d %v", res.StatusCode, body)
+	}
+	for _, header := range [][2]string{{"If-None-Match", etag}, {"If-Modified-Since", lastModified}} {
+		w := get(header[0], header[1])
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "The Dune Saga") {
+			t.Errorf("%s after the rename: got %d %s, want 200 with the new title", header[0], w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/patch"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+// suggestedBook works out the book a suggestion leads to: a new book made of
+// changes when current is nil, or current with changes merged into its
+// writable fields.
+func suggestedBook(current *model.Book, changes json.RawMessage) (*model.Book, error) {
+	var input bookInput
+	if current == nil {
+		if err := decodeJSON(bytes.NewReader(changes), &input); err != nil {
+			return nil, err
+		}
+		return input.newBook(), nil
+	}
+	doc, err := json.Marshal(inputFromBook(current))
+	if err != nil {
+		return nil, err
+	}
+	doc, err = patch.MergePatch(doc, changes)
+	if err != nil {
+		var patchErr *patch.Error
+		if errors.As(err, &patchErr) {
+			return nil, newRequestError("invalid_patch", "reason", patchErr.Error())
+		}
+		return nil, err
+	}
+	if err = decodeJSON(bytes.NewReader(doc), &input); err != nil {
+		return nil, err
+	}
+	book := *current
+	input.applyTo(&book)
+	return &book, nil
+}
+
+// bookChanges returns the writable fields of book that differ from current, as
+// a merge patch. Every field is returned for a new book.
+func bookChanges(current, book *model.Book) (json.RawMessage, error) {
+	var before, after map[string]json.RawMessage
+	if current != nil {
+		doc, err := json.Marshal(inputFromBook(current))
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(doc, &before); err != nil {
+			return nil, err
+		}
+	}
+	doc, err := json.Marshal(inputFromBook(book))
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(doc, &after); err != nil {
+		return nil, err
+	}
+	for key, value := range after {
+		if old, ok := before[key]; ok && bytes.Equal(old, value) {
+			delete(after, key)
+		}
+	}
+	return json.Marshal(after)
+}
+
+// createSuggestionHandler lets any activated user propose a new book, or a
+// change to one, for a librarian to review. Changes to a book are a merge
+// patch of its writable fields; a new book is given in full, as when creating
+// one.
+func (app *application) createSuggestionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		BookID  *int64          `json:"book_id"`
+		Changes json.RawMessage `json:"changes"`
+		Comment string          `json:"comment"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Check(len(input.Changes) != 0 && string(input.Changes) != "null", "changes", "must be provided")
+	v.Var("comment", input.Comment, "max=1000")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	var current *model.Book
+	if input.BookID != nil {
+		current, err = app.models.Books.Get(*input.BookID)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrRecordNotFound):
+				v.Fail("book_id", "unknown_book", "must be an existing book")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+	book, err := suggestedBook(current, input.Changes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if model.ValidateBook(v, book); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	changes, err := bookChanges(current, book)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if string(changes) == "{}" {
+		v.Fail("changes", "no_changes", "must change at least one field of the book")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	user := app.contextGetUser(r)
+	suggestion := &model.BookSuggestion{
+		BookID:  input.BookID,
+		Changes: changes,
+		Comment: input.Comment,
+		User:    user.Username,
+		UserID:  user.ID,
+		Locale:  app.contextGetLocale(r),
+	}
+	err = app.models.Suggestions.Insert(suggestion)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			v.Fail("book_id", "unknown_book", "must be an existing book")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/suggestions/%d", suggestion.ID))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"suggestion": suggestion}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listSuggestionsHandler serves the moderation queue to librarians, oldest
+// suggestion first by default, and their own suggestions to everyone else.
+func (app *application) listSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string
+		model.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.Limit = app.readInt(qs, "limit", 20, v)
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "-id"}
+
+	v.Var("status", input.Status, "omitempty,oneof="+strings.Join(model.SuggestionStatuses, " "))
+	model.ValidateFilters(v, input.Filters)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	librarian, err := app.hasPermission(r, "books:write")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	userID := app.contextGetUser(r).ID
+	if librarian {
+		userID = 0
+	}
+	suggestions, metadata, err := app.models.Suggestions.GetAll(userID, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	if links := app.paginationLinks(r, metadata); links != "" {
+		headers.Set("Link", links)
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"suggestions": suggestions, "metadata": metadata}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getSuggestionHandler serves a suggestion to the user who made it and to
+// librarians. Other users are told it does not exist.
+func (app *application) getSuggestionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	suggestion, err := app.models.Suggestions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if suggestion.UserID != app.contextGetUser(r).ID {
+		librarian, err := app.hasPermission(r, "books:write")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !librarian {
+			app.notFoundResponse(w, r)
+			return
+		}
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"suggestion": suggestion}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reviewSuggestionHandler approves or rejects a pending suggestion. Approved
+// changes are applied to the catalog in the name of the user who suggested
+// them, and that user is emailed the outcome either way.
+func (app *application) reviewSuggestionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Status  string `json:"status"`
+		Comment string `json:"comment"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	v.Var("status", input.Status, "required,oneof="+model.SuggestionApproved+" "+model.SuggestionRejected)
+	v.Var("comment", input.Comment, "max=1000")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	suggestion, err := app.models.Suggestions.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if suggestion.Status != model.SuggestionPending {
+		v.Fail("status", "already_reviewed", "the suggestion has already been reviewed")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	approved := input.Status == model.SuggestionApproved
+
+	// Only an approval needs the book: the changes were checked when they were
+	// suggested, but the book or the rules may have changed since.
+	var book *model.Book
+	if approved {
+		var current *model.Book
+		if suggestion.BookID != nil {
+			current, err = app.models.Books.Get(*suggestion.BookID)
+			if err != nil {
+				switch {
+				case errors.Is(err, model.ErrRecordNotFound):
+					app.notFoundResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+		}
+		book, err = suggestedBook(current, suggestion.Changes)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if model.ValidateBook(v, book); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+	reviewer := app.contextGetUser(r)
+	suggestion.Status = input.Status
+	suggestion.Reviewer = &reviewer.Username
+	suggestion.ReviewerID = reviewer.ID
+	suggestion.ReviewComment = input.Comment
+
+	// Claiming the suggestion and applying it happen together, so that two
+	// librarians cannot apply it twice and a failed change leaves it pending.
+	book, err = app.models.Suggestions.Decide(suggestion, book)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrAlreadyReviewed):
+			v.Fail("status", "already_reviewed", "the suggestion has already been reviewed")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Edits are named after the book as the user knew it, new books after the
+	// title they were suggested with.
+	title := suggestion.BookTitle
+	if title == "" {
+		var changes struct {
+			Title string `json:"title"`
+		}
+		if json.Unmarshal(suggestion.Changes, &changes) == nil {
+			title = changes.Title
+		}
+	}
+	app.background(func() {
+		user, err := app.models.Users.GetByID(suggestion.UserID)
+		if err != nil {
+			app.logger.Println(err)
+			return
+		}
+		data := map[string]interface{}{
+			"approved": approved,
+			"title":    title,
+			"comment":  suggestion.ReviewComment,
+		}
+		err = app.mailer.Send(user.Email, suggestion.Locale, "suggestion_reviewed.tmpl", data)
+		if err != nil {
+			app.logger.Println(err)
+		}
+	})
+
+	env := envelope{"suggestion": suggestion}
+	if approved {
+		env["book"] = book
+	}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
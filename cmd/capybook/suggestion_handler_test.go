@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+)
+
+func TestSuggestionRoutesRequireActivatedUser(t *testing.T) {
+	app, _ := newTestApplication(t)
+	routes := []struct{ method, path string }{
+		{"POST", "/api/v1/suggestions"},
+		{"GET", "/api/v1/suggestions"},
+		{"GET", "/api/v1/suggestions/1"},
+		{"POST", "/api/v1/suggestions/1/review"},
+	}
+	for _, route := range routes {
+		res, body := serve(t, app, model.AnonymousUser, route.method, route.path, "")
+		if res.StatusCode != http.StatusUnauthorized || body["code"] != "authentication_required" {
+			t.Errorf("%s %s as anonymous: got %d %v, want 401 authentication_required", route.method, route.path, res.StatusCode, body["code"])
+		}
+		inactive := &model.User{ID: 8, Username: "newcomer"}
+		res, body = serve(t, app, inactive, route.method, route.path, "")
+		if res.StatusCode != http.StatusForbidden || body["code"] != "inactive_account" {
+			t.Errorf("%s %s as inactive user: got %d %v, want 403 inactive_account", route.method, route.path, res.StatusCode, body["code"])
+		}
+	}
+}
+
+func TestCreateSuggestionValidatesChanges(t *testing.T) {
+	app, _ := newTestApplication(t)
+	res, body := serve(t, app, activatedUser, "POST", "/api/v1/suggestions", `{"comment": "no changes"}`)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("got %d, want 422", res.StatusCode)
+	}
+	params, _ := body["invalid_params"].([]interface{})
+	if len(params) != 1 || params[0].(map[string]interface{})["name"] != "changes" {
+		t.Errorf("got invalid_params %v, want one for changes", params)
+	}
+}
+
+func TestListSuggestionsScope(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions []string
+		wantUserID  int64
+	}{
+		{"reader sees their own", nil, activatedUser.ID},
+		{"librarian sees everyone's", []string{"books:write"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, db := newTestApplication(t, tt.permissions...)
+			var gotUserID interface{}
+			db.On("FROM suggestions", func(args []driver.NamedValue) stubResult {
+				gotUserID = args[0].Value
+				return stubResult{Columns: []string{"count"}}
+			})
+			res, body := serve(t, app, activatedUser, "GET", "/api/v1/suggestions?status=pending", "")
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got %d %v, want 200", res.StatusCode, body)
+			}
+			if gotUserID != tt.wantUserID {
+				t.Errorf("listed suggestions of user %v, want %v", gotUserID, tt.wantUserID)
+			}
+		})
+	}
+}
+
+func TestReviewSuggestionRequiresLibrarian(t *testing.T) {
+	app, _ := newTestApplication(t)
+	res, body := serve(t, app, activatedUser, "POST", "/api/v1/suggestions/1/review", `{"status": "approved"}`)
+	if res.StatusCode != http.StatusForbidden || body["code"] != "not_permitted" {
+		t.Errorf("got %d %v, want 403 not_permitted", res.StatusCode, body["code"])
+	}
+
+	app, _ = newTestApplication(t, "books:write")
+	res, _ = serve(t, app, activatedUser, "POST", "/api/v1/suggestions/1/review", `{"status": "maybe"}`)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("got %d, want 422 for an unknown status", res.StatusCode)
+	}
+}
+
+// pendingSuggestion stubs the lookup of suggestion 1, an edit of book 5 by
+// activatedUser, or a new book when bookID is nil.
+func pendingSuggestion(db *stubDB, bookID interface{}, changes string) {
+	db.On("FROM suggestions", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"id", "book_id", "changes", "comment", "status", "username", "username",
+				"review_comment", "created_at", "reviewed_at", "user_id", "locale"},
+			Rows: [][]driver.Value{{int64(1), bookID, []byte(changes), "", "pending", "reader", nil,
+				"", time.Unix(0, 0), nil, activatedUser.ID, "en"}},
+		}
+	})
+}
+
+func TestRejectSuggestionSkipsTheBook(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	// The book was trashed since, and the patch no longer applies to anything.
+	pendingSuggestion(db, int64(5), `"not a patch"`)
+	db.On("UPDATE suggestions", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"reviewed_at", "coalesce"},
+			Rows:    [][]driver.Value{{time.Unix(0, 0), "Old Title"}},
+		}
+	})
+	res, body := serve(t, app, activatedUser, "POST", "/api/v1/suggestions/1/review", `{"status": "rejected"}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v, want 200", res.StatusCode, body)
+	}
+	if got := db.Logged("books.id, books.title", "INSERT INTO books", "UPDATE books"); len(got) != 0 {
+		t.Errorf("rejecting touched the book: %v", got)
+	}
+}
+
+func TestApproveSuggestionIsAtomic(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	pendingSuggestion(db, nil, `{"title": "Dune", "author": "Frank Herbert", "year": 1965, "description": "Spice.", "genres": ["science fiction"], "language": "english"}`)
+	db.On("UPDATE suggestions", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"reviewed_at", "coalesce"},
+			Rows:    [][]driver.Value{{time.Unix(0, 0), ""}},
+		}
+	})
+	db.On("set_config", func([]driver.NamedValue) stubResult { return stubResult{} })
+	db.On("INSERT INTO books", func([]driver.NamedValue) stubResult {
+		return stubResult{Err: errors.New("connection reset")}
+	})
+	res, body := serve(t, app, activatedUser, "POST", "/api/v1/suggestions/1/review", `{"status": "approved"}`)
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("got %d %v, want 500", res.StatusCode, body)
+	}
+	want := []string{"BEGIN", "UPDATE suggestions", "INSERT INTO books", "ROLLBACK"}
+	if got := db.Logged("BEGIN", "UPDATE suggestions", "INSERT INTO books", "COMMIT", "ROLLBACK"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+// How many tags are embedded per book with include=tags.
+const includedTagsLimit = 5
+
+// readTagParam returns the key of the tag named in the URL, which may be
+// written as any spelling of the tag.
+func (app *application) readTagParam(r *http.Request) string {
+	return model.TagKey(mux.Vars(r)["tag"])
+}
+
+// listBookTagsHandler serves the tags of a book, most voted first, telling
+// the requesting user which ones they voted for.
+func (app *application) listBookTagsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	_, err = app.models.Books.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	freshness, err := app.models.Tags.Freshness(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	user := app.contextGetUser(r)
+	if app.notModified(w, r, entityTag(r, freshness, user.ID), time.Time{}) {
+		return
+	}
+	app.showBookTags(w, r, id)
+}
+
+// addBookTagHandler attaches a tag to a book, or votes for it when the book
+// already carries it.
+func (app *application) addBookTagHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	var input struct {
+		Name string `json:"name"`
+	}
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if model.ValidateTagName(v, input.Name); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	err = app.models.Tags.Add(id, app.contextGetUser(r).ID, input.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrBannedTag):
+			v.Fail("name", "banned_tag", "is not allowed")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrRemovedTag):
+			v.Fail("name", "removed_tag", "was removed from this book by a moderator")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.showBookTags(w, r, id)
+}
+
+func (app *application) voteBookTagHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Tags.Vote(id, app.contextGetUser(r).ID, app.readTagParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.showBookTags(w, r, id)
+}
+
+func (app *application) unvoteBookTagHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Tags.Unvote(id, app.contextGetUser(r).ID, app.readTagParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.showBookTags(w, r, id)
+}
+
+// removeBookTagHandler lets a moderator take an abusive or wrong tag off a
+// book, for good.
+func (app *application) removeBookTagHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	err = app.models.Tags.Remove(id, app.readTagParam(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.showBookTags(w, r, id)
+}
+
+// showBookTags responds with the current tags of a book.
+func (app *application) showBookTags(w http.ResponseWriter, r *http.Request, bookID int64) {
+	tags, err := app.models.Tags.GetForBooks([]int64{bookID}, app.contextGetUser(r).ID, 0)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	bookTags := tags[bookID]
+	if bookTags == nil {
+		bookTags = []*model.BookTag{}
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"tags": bookTags}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listTagsHandler serves the most used tags, optionally only those starting
+// with a prefix, for browsing and suggesting tags.
+func (app *application) listTagsHandler(w http.ResponseWriter, r *http.Request) {
+	app.searchTags(w, r, false)
+}
+
+// listBannedTagsHandler serves the banned tags to moderators.
+func (app *application) listBannedTagsHandler(w http.ResponseWriter, r *http.Request) {
+	app.searchTags(w, r, true)
+}
+
+func (app *application) searchTags(w http.ResponseWriter, r *http.Request, banned bool) {
+	v := validator.New()
+	qs := r.URL.Query()
+	prefix := app.readString(qs, "prefix", "")
+	limit := app.readInt(qs, "limit", 20, v)
+	v.Var("prefix", prefix, "max=50")
+	v.Var("limit", limit, "min=1,max=100")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	freshness, err := app.models.Tags.Freshness(0)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.notModified(w, r, entityTag(r, freshness), time.Time{}) {
+		return
+	}
+	tags, err := app.models.Tags.Search(prefix, banned, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"tags": tags}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateTagHandler lets a moderator ban a tag from every book, or lift the
+// ban.
+func (app *application) updateTagHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Banned *bool `json:"banned"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if input.Banned == nil {
+		v.Fail("banned", "required", "must be provided")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	tag, err := app.models.Tags.SetBanned(app.readTagParam(r), *input.Banned)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"tag": tag}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
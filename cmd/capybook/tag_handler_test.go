@@ -0,0 +1,36 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"testing"
+)
+
+func TestAddBannedTagIsRejected(t *testing.T) {
+	app, db := newTestApplication(t)
+	db.On("INSERT INTO tags", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"id", "banned"}, Rows: [][]driver.Value{{int64(4), true}}}
+	})
+	res, js := serve(t, app, activatedUser, "POST", "/api/v1/books/1/tags", `{"name": "Spoilers"}`)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("got %d %v, want 422", res.StatusCode, js)
+	}
+	params, _ := js["invalid_params"].([]interface{})
+	if len(params) != 1 || params[0].(map[string]interface{})["code"] != "banned_tag" {
+		t.Errorf("got invalid_params %v, want banned_tag", params)
+	}
+	if got := db.Logged("book_tags", "COMMIT"); len(got) > 0 {
+		t.Errorf("a banned tag went on to %q", got)
+	}
+}
+
+func TestAddTagNeedsALetterOrDigit(t *testing.T) {
+	app, db := newTestApplication(t)
+	res, js := serve(t, app, activatedUser, "POST", "/api/v1/books/1/tags", `{"name": "?!"}`)
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("got %d %v, want 422", res.StatusCode, js)
+	}
+	if got := db.Logged("tags"); len(got) > 0 {
+		t.Errorf("an invalid tag reached the database: %q", got)
+	}
+}
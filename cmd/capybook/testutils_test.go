@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/shyndaliu/capybook/internal/stubdb"
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+)
+
+// stubResult is what a stubbed query answers with.
+type stubResult = stubdb.Result
+
+// stubDB answers the queries the handlers under test make. Any query without a
+// stub fails the request, so tests see exactly which queries a handler
+// reaches.
+type stubDB = stubdb.DB
+
+// newTestApplication returns an application whose models talk to a stub
+// database that, unless told otherwise, grants users the given permissions.
+func newTestApplication(t *testing.T, permissions ...string) (*application, *stubDB) {
+	t.Helper()
+	conn, db := stubdb.OpenStrict(t)
+	db.On("FROM permissions", func([]driver.NamedValue) stubResult {
+		res := stubResult{Columns: []string{"code"}}
+		for _, p := range permissions {
+			res.Rows = append(res.Rows, []driver.Value{p})
+		}
+		return res
+	})
+	app := &application{
+		logger: log.New(io.Discard, "", 0),
+		models: model.NewModels(conn),
+	}
+	return app, db
+}
+
+// activatedUser is a signed-in user with an activated account.
+var activatedUser = &model.User{ID: 7, Username: "reader", Email: "reader@example.com", Activated: true}
+
+// serve sends a request through the application's routes as user, and returns
+// the response with its decoded JSON body.
+func serve(t *testing.T, app *application, user *model.User, method, path, body string) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = bytes.NewBufferString(body)
+	}
+	r := httptest.NewRequest(method, path, reader)
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r = app.contextSetUser(r, user)
+	w := httptest.NewRecorder()
+	app.routes().ServeHTTP(w, r)
+	res := w.Result()
+	var js map[string]interface{}
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &js); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return res, js
+}
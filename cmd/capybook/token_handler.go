@@ -26,10 +26,14 @@ func (app *application) createAuthTokenHandler(w http.ResponseWriter, r *http.Re
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+	// Each identity is checked on its own, so that a missing username doesn't
+	// count against an email that is fine.
 	model.ValidateUsername(v, input.Username)
 	usernameValid := v.Valid()
-	model.ValidateEmail(v, input.Email)
-	emailValid := v.Valid()
+	ve := validator.New()
+	model.ValidateEmail(ve, input.Email)
+	emailValid := ve.Valid()
+	v.Errors = append(v.Errors, ve.Errors...)
 	if !usernameValid && !emailValid {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -71,7 +75,7 @@ func (app *application) createAuthTokenHandler(w http.ResponseWriter, r *http.Re
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusCreated, envelope{"access_token": accessToken, "refresh_token": refreshToken}, nil)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"access_token": accessToken, "refresh_token": refreshToken}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -87,7 +91,7 @@ func (app *application) refreshTokenandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusCreated, envelope{"access_token": accessToken}, nil)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"access_token": accessToken}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"testing"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/auth"
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The refresh token carries a key derived from the user's token hash, so a
+// login by email must read the hash just like a login by username.
+func TestCreateAuthTokenByEmailKeepsTokenHash(t *testing.T) {
+	app, db := newTestApplication(t)
+	app.auth = *auth.NewAuthService("secret")
+	hash, err := bcrypt.GenerateFromPassword([]byte("pa55word!"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.On("WHERE email = $1", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"id", "username", "email", "password", "token_hash", "activated"},
+			Rows:    [][]driver.Value{{int64(7), "reader", "reader@example.com", hash, "token-hash", true}},
+		}
+	})
+	body := `{"email": "reader@example.com", "password": "pa55word!"}`
+	res, js := serve(t, app, model.AnonymousUser, "GET", "/api/v1/token", body)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("got %d %v, want 201", res.StatusCode, js)
+	}
+	claims, err := app.auth.ValidateRefreshToken(js["refresh_token"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := app.auth.GenerateCustomKey("reader", "token-hash"); claims.CustomKey != want {
+		t.Errorf("refresh token key %q was not derived from the token hash", claims.CustomKey)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/model"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+// purgeTrash permanently deletes the books, reviews and users that have been in
+// the trash for longer than -trash-retention, run by the server every hour and
+// by the purge command, and reports how many of each there were.
+func (app *application) purgeTrash() (books, reviews, users int64, err error) {
+	before := time.Now().Add(-app.config.trash.retention)
+	// Reviews go first so that the count takes in those that went to the
+	// trash along with their book or author.
+	if reviews, err = app.models.Reviews.Purge(before); err != nil {
+		return 0, 0, 0, err
+	}
+	books, coverKeys, err := app.models.Books.Purge(before)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	// The purge command exits as soon as this returns, so the covers are
+	// deleted here rather than in the background.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for _, key := range coverKeys {
+		app.deleteCoverFiles(ctx, key)
+	}
+	if users, err = app.models.Users.Purge(before); err != nil {
+		return 0, 0, 0, err
+	}
+	return books, reviews, users, nil
+}
+
+// readTrashFilters reads the pagination parameters of the trash listings,
+// which show what went to the trash last first by default.
+func (app *application) readTrashFilters(qs url.Values, v *validator.Validator) model.Filters {
+	var filters model.Filters
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.Limit = app.readInt(qs, "limit", 20, v)
+	filters.Cursor = app.readString(qs, "cursor", "")
+	filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	filters.SortSafelist = []string{"deleted_at", "id", "-deleted_at", "-id"}
+	model.ValidateFilters(v, filters)
+	return filters
+}
+
+func (app *application) listTrashedBooksHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readTrashFilters(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	books, metadata, err := app.models.Books.GetTrashed(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	if links := app.paginationLinks(r, metadata); links != "" {
+		headers.Set("Link", links)
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"books": books, "metadata": metadata}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listTrashedReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readTrashFilters(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	reviews, metadata, err := app.models.Reviews.GetTrashed(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	if links := app.paginationLinks(r, metadata); links != "" {
+		headers.Set("Link", links)
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listTrashedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filters := app.readTrashFilters(r.URL.Query(), v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	users, metadata, err := app.models.Users.GetTrashed(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := make(http.Header)
+	if links := app.paginationLinks(r, metadata); links != "" {
+		headers.Set("Link", links)
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"users": users, "metadata": metadata}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreBookHandler takes a book out of the trash, with the reviews that went
+// there along with it.
+func (app *application) restoreBookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	book, err := app.models.Books.Restore(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrDuplicatePosition):
+			v := validator.New()
+			v.Fail("series", "already_exists", "another book of the series is at this position")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreReviewHandler takes a review out of the trash, as long as its book
+// and its author aren't in the trash themselves.
+func (app *application) restoreReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	review, err := app.models.Reviews.Restore(id)
+	if err != nil {
+		v := validator.New()
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, model.ErrInTrash):
+			v.Fail("review", "in_trash", "its book or author must be restored first")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrEditConflict):
+			v.Fail("review", "reviewed_again", "its author has reviewed the book again since")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreUserHandler takes a user out of the trash, with the reviews that went
+// there along with them.
+func (app *application) restoreUserHandler(w http.ResponseWriter, r *http.Request) {
+	username, err := app.readUsernameParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	user, err := app.models.Users.Restore(username)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
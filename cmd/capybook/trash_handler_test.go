@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/cover"
+)
+
+func TestTrashListingsRequireAdmin(t *testing.T) {
+	app, _ := newTestApplication(t, "books:write", "reviews:write", "users:write")
+	for _, path := range []string{"/api/v1/trash/books", "/api/v1/trash/reviews", "/api/v1/trash/users"} {
+		res, _ := serve(t, app, activatedUser, "GET", path, "")
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: got %d, want 403", path, res.StatusCode)
+		}
+	}
+}
+
+func TestRestoreBook(t *testing.T) {
+	app, db := newTestApplication(t, "books:write")
+	db.On("set_config", func([]driver.NamedValue) stubResult { return stubResult{} })
+	db.On("UPDATE reviews", func([]driver.NamedValue) stubResult { return stubResult{} })
+	db.On("UPDATE books", func(args []driver.NamedValue) stubResult {
+		if args[0].Value != int64(1) {
+			return stubResult{Columns: stubBookColumns}
+		}
+		return stubResult{
+			Columns: stubBookColumns,
+			Rows:    [][]driver.Value{stubBook(1, "Dune", "Frank Herbert")},
+		}
+	})
+	res, body := serve(t, app, activatedUser, "POST", "/api/v1/trash/books/1/restore", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v, want 200", res.StatusCode, body)
+	}
+	if book, _ := body["book"].(map[string]interface{}); book["title"] != "Dune" {
+		t.Errorf("restored %v, want Dune", body["book"])
+	}
+	if res, _ := serve(t, app, activatedUser, "POST", "/api/v1/trash/books/2/restore", ""); res.StatusCode != http.StatusNotFound {
+		t.Errorf("a book not in the trash: got %d, want 404", res.StatusCode)
+	}
+}
+
+// recordingStorage remembers the keys deleted from it.
+type recordingStorage struct {
+	deleted []string
+}
+
+func (s *recordingStorage) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	return nil
+}
+
+func (s *recordingStorage) Delete(ctx context.Context, key string) error {
+	s.deleted = append(s.deleted, key)
+	return nil
+}
+
+func (s *recordingStorage) URL(key string) string { return "/covers/" + key }
+
+func TestPurgeTrashDeletesCovers(t *testing.T) {
+	app, db := newTestApplication(t)
+	store := &recordingStorage{}
+	app.storage = store
+	app.config.trash.retention = 30 * 24 * time.Hour
+	db.On("DELETE FROM reviews", func([]driver.NamedValue) stubResult {
+		return stubResult{Rows: [][]driver.Value{{}, {}, {}}}
+	})
+	db.On("DELETE FROM books", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"count", "keys"}, Rows: [][]driver.Value{{int64(2), "{covers/1,covers/2}"}}}
+	})
+	db.On("DELETE FROM users", func([]driver.NamedValue) stubResult {
+		return stubResult{Rows: [][]driver.Value{{}}}
+	})
+	books, reviews, users, err := app.purgeTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if books != 2 || reviews != 3 || users != 1 {
+		t.Errorf("purged %d books, %d reviews and %d users, want 2, 3 and 1", books, reviews, users)
+	}
+	var want []string
+	for _, key := range []string{"covers/1", "covers/2"} {
+		for _, size := range cover.Sizes {
+			want = append(want, key+"/"+size.Name+".jpg")
+		}
+	}
+	sort.Strings(want)
+	sort.Strings(store.deleted)
+	if len(store.deleted) != len(want) {
+		t.Fatalf("deleted %v, want %v", store.deleted, want)
+	}
+	for i := range want {
+		if store.deleted[i] != want[i] {
+			t.Errorf("deleted %v, want %v", store.deleted, want)
+			break
+		}
+	}
+}
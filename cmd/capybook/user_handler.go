@@ -45,10 +45,10 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrDuplicateEmail):
-			v.AddError("email", "a user with this email address already exists")
+			v.Fail("email", "already_exists", "a user with this email address already exists")
 			app.failedValidationResponse(w, r, v.Errors)
 		case errors.Is(err, model.ErrDuplicateUsername):
-			v.AddError("username", "a user with this username already exists")
+			v.Fail("username", "already_exists", "a user with this username already exists")
 			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -62,17 +62,18 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	locale := app.contextGetLocale(r)
 	app.background(func() {
 		data := map[string]interface{}{
 			"verificationCode": code.PlainText,
 		}
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		err = app.mailer.Send(user.Email, locale, "user_welcome.tmpl", data)
 		if err != nil {
-			app.logger.Printf(err.Error(), nil)
+			app.logger.Println(err)
 		}
 	})
 
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -82,6 +83,9 @@ func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 	username, _ := app.readUsernameParam(r)
 	v := validator.New()
 	model.ValidateUsername(v, username)
+	qs := r.URL.Query()
+	fields := app.readFieldList(qs, "fields", jsonFields(model.User{}), v)
+	include := app.readFieldList(qs, "include", []string{"reviews"}, v)
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -96,7 +100,16 @@ func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	env := envelope{"user": user}
+	if len(fields) > 0 || len(include) > 0 {
+		resources, err := app.projectUsers([]*model.User{user}, fields, include)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["user"] = resources[0]
+	}
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -121,31 +134,79 @@ func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
-	var input struct {
-		Password *string `json:"password"`
+	// The password is write-only: it is absent from the document being patched
+	// and only changes when the patch adds it.
+	type userDocument struct {
+		Email    string  `json:"email"`
+		Password *string `json:"password,omitempty"`
 	}
-	err = app.readJSON(w, r, &input)
+	var input userDocument
+	err = app.readPatch(w, r, userDocument{Email: user.Email}, &input)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.patchErrorResponse(w, r, err)
 		return
 	}
-	model.ValidatePasswordPlaintext(v, *input.Password)
+	emailChanged := input.Email != user.Email
+	user.Email = input.Email
+	model.ValidateEmail(v, user.Email)
+	if input.Password != nil {
+		model.ValidatePasswordPlaintext(v, *input.Password)
+	}
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	err = user.Password.Set(*input.Password)
-	user.TokenHash = app.auth.GenerateRandomString(15)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
+	if input.Password != nil {
+		err = user.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		// Changing the password signs the user out everywhere else.
+		user.TokenHash = app.auth.GenerateRandomString(15)
+	}
+	if emailChanged {
+		// A new address has to be verified like the first one was, and until
+		// it is the account is deactivated.
+		user.Activated = false
 	}
 	err = app.models.Users.Update(user)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, model.ErrDuplicateEmail):
+			v.Fail("email", "already_exists", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, model.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if emailChanged {
+		// Codes sent to the old address mustn't verify the new one.
+		err = app.models.Verifications.Delete(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		code, err := app.models.Verifications.New(user.ID, 3*24*time.Hour)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		locale := app.contextGetLocale(r)
+		app.background(func() {
+			data := map[string]interface{}{
+				"verificationCode": code.PlainText,
+			}
+			err := app.mailer.Send(user.Email, locale, "email_changed.tmpl", data)
+			if err != nil {
+				app.logger.Println(err)
+			}
+		})
+	}
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -169,7 +230,7 @@ func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -195,7 +256,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrRecordNotFound):
-			v.AddError("token", "invalid or expired activation token")
+			v.Fail("token", "invalid_token", "invalid or expired activation token")
 			app.failedValidationResponse(w, r, v.Errors)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -221,7 +282,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	// Send the updated user details to the client in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
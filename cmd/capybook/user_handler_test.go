@@ -0,0 +1,60 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// stubReader answers the lookup of the reader account and records the
+// activation state the handler saves.
+func stubReader(db *stubDB, activated *bool) {
+	db.On("WHERE username = lower($1)", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"id", "username", "email", "password", "token_hash", "activated"},
+			Rows:    [][]driver.Value{{int64(7), "reader", "reader@example.com", []byte("hash"), "token-hash", true}},
+		}
+	})
+	db.On("UPDATE users", func(args []driver.NamedValue) stubResult {
+		*activated = args[3].Value.(bool)
+		return stubResult{Columns: []string{"id"}, Rows: [][]driver.Value{{int64(7)}}}
+	})
+	db.On("verifications", func([]driver.NamedValue) stubResult { return stubResult{} })
+}
+
+func TestChangingTheEmailRequiresVerifyingIt(t *testing.T) {
+	app, db := newTestApplication(t)
+	var activated bool
+	stubReader(db, &activated)
+
+	res, js := serve(t, app, activatedUser, "PATCH", "/api/v1/users/reader", `{"email": "new@example.com"}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v, want 200", res.StatusCode, js)
+	}
+	if activated {
+		t.Error("the account stayed activated with an unverified email")
+	}
+	got := db.Logged("UPDATE users", "DELETE FROM verifications", "INSERT INTO verifications")
+	want := []string{"UPDATE users", "DELETE FROM verifications", "INSERT INTO verifications"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %q, want %q", got, want)
+	}
+}
+
+func TestChangingThePasswordKeepsTheAccountActivated(t *testing.T) {
+	app, db := newTestApplication(t)
+	var activated bool
+	stubReader(db, &activated)
+
+	res, js := serve(t, app, activatedUser, "PATCH", "/api/v1/users/reader", `{"password": "n3w-pa55word"}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %v, want 200", res.StatusCode, js)
+	}
+	if !activated {
+		t.Error("changing the password deactivated the account")
+	}
+	if got := db.Logged("verifications"); len(got) > 0 {
+		t.Errorf("got %d verification statements, want none", len(got))
+	}
+}
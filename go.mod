@@ -1,13 +1,15 @@
 module github.com/shyndaliu/capybook
 
-go 1.18
+go 1.20
 
 require (
-	github.com/go-mail/mail/v2 v2.3.0 // indirect
-	github.com/golang-jwt/jwt/v4 v4.5.0 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/lib/pq v1.10.2 // indirect
-	golang.org/x/crypto v0.21.0 // indirect
-	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+	github.com/andybalholm/brotli v1.1.0
+	github.com/go-mail/mail/v2 v2.3.0
+	github.com/golang-jwt/jwt/v4 v4.5.0
+	github.com/gorilla/mux v1.8.1
+	github.com/joho/godotenv v1.5.1
+	github.com/lib/pq v1.10.2
+	golang.org/x/crypto v0.21.0
 )
+
+require gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
@@ -0,0 +1,229 @@
+// Package stubdb provides a database/sql driver that answers statements from
+// stubs instead of a database, so that tests can check the SQL that code
+// sends, and feed it rows, without a PostgreSQL server.
+package stubdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// Result is what a stubbed statement answers with. A statement that doesn't
+// return rows reports as many rows affected as there are in Rows.
+type Result struct {
+	Columns []string
+	Rows    [][]driver.Value
+	Err     error
+}
+
+// DB answers the statements sent to it, picking the first stub whose key is
+// part of the statement. Every statement, and the beginning and end of every
+// transaction, is logged in order.
+type DB struct {
+	mu     sync.Mutex
+	strict bool
+	log    []string
+	stubs  []stub
+}
+
+type stub struct {
+	key string
+	fn  func(args []driver.NamedValue) Result
+}
+
+// On answers the statements that contain key with fn.
+func (db *DB) On(key string, fn func(args []driver.NamedValue) Result) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.stubs = append(db.stubs, stub{key, fn})
+}
+
+func (db *DB) record(statement string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.log = append(db.log, statement)
+}
+
+// Statements returns the logged statements.
+func (db *DB) Statements() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]string(nil), db.log...)
+}
+
+// Logged returns the logged statements that contain one of keys, each
+// shortened to the first key it contains.
+func (db *DB) Logged(keys ...string) []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var matched []string
+	for _, statement := range db.log {
+		for _, key := range keys {
+			if strings.Contains(statement, key) {
+				matched = append(matched, key)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+func (db *DB) answer(query string, args []driver.NamedValue) Result {
+	db.record(query)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, stub := range db.stubs {
+		if strings.Contains(query, stub.key) {
+			return stub.fn(args)
+		}
+	}
+	if db.strict {
+		return Result{Err: fmt.Errorf("unexpected query: %s", query)}
+	}
+	return Result{}
+}
+
+var (
+	dbs  sync.Map
+	seq  int64
+	once sync.Once
+)
+
+// Open returns a connection to a new stub database that answers any statement
+// without a stub with no rows.
+func Open(t *testing.T) (*sql.DB, *DB) {
+	t.Helper()
+	return open(t, &DB{})
+}
+
+// OpenStrict returns a connection to a new stub database that fails any
+// statement without a stub, and any prepared statement, so that tests see
+// exactly which statements are reached.
+func OpenStrict(t *testing.T) (*sql.DB, *DB) {
+	t.Helper()
+	return open(t, &DB{strict: true})
+}
+
+func open(t *testing.T, db *DB) (*sql.DB, *DB) {
+	t.Helper()
+	once.Do(func() { sql.Register("stubdb", stubDriver{}) })
+	name := fmt.Sprintf("stub-%d", atomic.AddInt64(&seq, 1))
+	dbs.Store(name, db)
+	conn, err := sql.Open("stubdb", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		dbs.Delete(name)
+	})
+	return conn, db
+}
+
+type stubDriver struct{}
+
+func (stubDriver) Open(name string) (driver.Conn, error) {
+	db, ok := dbs.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("no stub database %q", name)
+	}
+	return &conn{db: db.(*DB)}, nil
+}
+
+type conn struct {
+	db *DB
+}
+
+// Prepare only serves COPY, which lib/pq sends as a prepared statement. Each
+// row copied is logged as its own statement.
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	if c.db.strict {
+		return nil, errors.New("prepared statements are not stubbed")
+	}
+	c.db.record(query)
+	return stmt{db: c.db}, nil
+}
+
+func (c *conn) Close() error { return nil }
+
+func (c *conn) Begin() (driver.Tx, error) {
+	c.db.record("BEGIN")
+	return tx{db: c.db}, nil
+}
+
+// BeginTx accepts any options, such as those of read-only transactions.
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Begin()
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res := c.db.answer(query, args)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return &rows{columns: res.Columns, rows: res.Rows}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res := c.db.answer(query, args)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return driver.RowsAffected(len(res.Rows)), nil
+}
+
+type stmt struct {
+	db *DB
+}
+
+func (s stmt) Close() error  { return nil }
+func (s stmt) NumInput() int { return -1 }
+
+func (s stmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.record(fmt.Sprint("copy ", args))
+	return driver.RowsAffected(0), nil
+}
+
+func (s stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("queries are not stubbed on prepared statements")
+}
+
+type tx struct {
+	db *DB
+}
+
+func (tx tx) Commit() error {
+	tx.db.record("COMMIT")
+	return nil
+}
+
+func (tx tx) Rollback() error {
+	tx.db.record("ROLLBACK")
+	return nil
+}
+
+type rows struct {
+	columns []string
+	rows    [][]driver.Value
+	next    int
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
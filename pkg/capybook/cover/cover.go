@@ -0,0 +1,170 @@
+// Package cover turns uploaded book cover images into the JPEG renditions the
+// API serves. Images are decoded and encoded again, which drops EXIF and any
+// other metadata the upload carried.
+package cover
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+
+	// Registered for image.Decode.
+	_ "image/gif"
+	_ "image/png"
+)
+
+var (
+	ErrUnsupported = errors.New("cover: unsupported image format")
+	ErrInvalid     = errors.New("cover: invalid image")
+	ErrTooLarge    = errors.New("cover: image dimensions too large")
+)
+
+// MediaTypes lists the sniffed content types accepted as covers.
+var MediaTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// MaxPixels bounds the size of images that are decoded at all, so that a small
+// file can't claim dimensions that take gigabytes to decode. Processing holds
+// up to 8 bytes a pixel, the decoded image and its RGBA copy, so this keeps an
+// upload under about 100MB: still more than a 12-megapixel photo needs.
+const MaxPixels = 12_000_000
+
+// Size is a rendition of a cover, fitted within Width by twice Width.
+type Size struct {
+	Name  string
+	Width int
+}
+
+// Sizes lists the renditions made of every cover, the full-size one first.
+var Sizes = []Size{
+	{Name: "original", Width: 1600},
+	{Name: "large", Width: 600},
+	{Name: "medium", Width: 300},
+	{Name: "small", Width: 150},
+}
+
+// Rendition is an encoded cover in one of Sizes.
+type Rendition struct {
+	Name   string
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// ContentType is the media type of every rendition.
+const ContentType = "image/jpeg"
+
+// Sniff returns the content type of an upload as told by its first bytes.
+func Sniff(data []byte) string {
+	return http.DetectContentType(data)
+}
+
+// Process decodes an uploaded image and returns it in every one of Sizes.
+// Images are never enlarged, and transparency is flattened onto white.
+func Process(data []byte) ([]Rendition, error) {
+	contentType := Sniff(data)
+	supported := false
+	for _, t := range MediaTypes {
+		supported = supported || t == contentType
+	}
+	if !supported {
+		return nil, ErrUnsupported
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	if config.Width < 1 || config.Height < 1 {
+		return nil, ErrInvalid
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Over)
+
+	renditions := make([]Rendition, 0, len(Sizes))
+	for _, size := range Sizes {
+		width, height := fit(src.Bounds().Dx(), src.Bounds().Dy(), size.Width, 2*size.Width)
+		scaled := src
+		if width != src.Bounds().Dx() || height != src.Bounds().Dy() {
+			scaled = resize(src, width, height)
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, err
+		}
+		renditions = append(renditions, Rendition{Name: size.Name, Width: width, Height: height, Data: buf.Bytes()})
+	}
+	return renditions, nil
+}
+
+// fit scales width and height down, keeping their ratio, until they are
+// within maxWidth and maxHeight.
+func fit(width, height, maxWidth, maxHeight int) (int, int) {
+	if width <= maxWidth && height <= maxHeight {
+		return width, height
+	}
+	if width*maxHeight > height*maxWidth {
+		height = height * maxWidth / width
+		width = maxWidth
+	} else {
+		width = width * maxHeight / height
+		height = maxHeight
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	return width, height
+}
+
+// resize shrinks src to width by height, averaging the source pixels that
+// each destination pixel covers.
+func resize(src *image.RGBA, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := (y + 1) * srcHeight / height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := (x + 1) * srcWidth / width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0] = uint8(r / n)
+			d[1] = uint8(g / n)
+			d[2] = uint8(b / n)
+			d[3] = uint8(a / n)
+		}
+	}
+	return dst
+}
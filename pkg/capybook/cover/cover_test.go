@@ -0,0 +1,110 @@
+package cover
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		width, height, maxWidth, maxHeight int
+		wantWidth, wantHeight              int
+	}{
+		{100, 200, 150, 300, 100, 200},    // already within
+		{1200, 1800, 600, 1200, 600, 900}, // width bound
+		{600, 3000, 600, 1200, 240, 1200}, // height bound
+		{3000, 1, 150, 300, 150, 1},       // never below a pixel
+	}
+	for _, tt := range tests {
+		width, height := fit(tt.width, tt.height, tt.maxWidth, tt.maxHeight)
+		if width != tt.wantWidth || height != tt.wantHeight {
+			t.Errorf("fit(%d, %d, %d, %d) = %d, %d; want %d, %d",
+				tt.width, tt.height, tt.maxWidth, tt.maxHeight, width, height, tt.wantWidth, tt.wantHeight)
+		}
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	src.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	src.Set(1, 0, color.RGBA{255, 0, 0, 255})
+	src.Set(0, 1, color.RGBA{0, 0, 255, 255})
+	src.Set(1, 1, color.RGBA{0, 0, 255, 255})
+	dst := resize(src, 1, 1)
+	if got, want := dst.RGBAAt(0, 0), (color.RGBA{127, 0, 127, 255}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestProcessMakesEverySize(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 800, 1200))
+	for i := range img.Pix {
+		img.Pix[i] = 0 // transparent black, flattened onto white
+	}
+	renditions, err := Process(encodePNG(t, img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][2]int{
+		"original": {800, 1200}, // never enlarged
+		"large":    {600, 900},
+		"medium":   {300, 450},
+		"small":    {150, 225},
+	}
+	if len(renditions) != len(Sizes) {
+		t.Fatalf("got %d renditions, want %d", len(renditions), len(Sizes))
+	}
+	for _, r := range renditions {
+		if size := want[r.Name]; r.Width != size[0] || r.Height != size[1] {
+			t.Errorf("%s is %dx%d, want %dx%d", r.Name, r.Width, r.Height, size[0], size[1])
+		}
+		decoded, err := jpeg.Decode(bytes.NewReader(r.Data))
+		if err != nil {
+			t.Fatalf("%s is not a JPEG: %v", r.Name, err)
+		}
+		if b := decoded.Bounds(); b.Dx() != r.Width || b.Dy() != r.Height {
+			t.Errorf("%s decodes as %dx%d, want %dx%d", r.Name, b.Dx(), b.Dy(), r.Width, r.Height)
+		}
+		if r, g, b, _ := decoded.At(0, 0).RGBA(); r>>8 < 250 || g>>8 < 250 || b>>8 < 250 {
+			t.Errorf("transparency was not flattened onto white: %d, %d, %d", r>>8, g>>8, b>>8)
+		}
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	// A PNG header claiming 16 megapixels, past MaxPixels, with no pixel data.
+	huge := encodePNG(t, image.NewGray(image.Rect(0, 0, 1, 1)))
+	huge[16], huge[17], huge[18], huge[19] = 0, 0, 0x0f, 0xa0 // width 4000
+	huge[20], huge[21], huge[22], huge[23] = 0, 0, 0x0f, 0xa0 // height 4000
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"text", []byte("not an image at all"), ErrUnsupported},
+		{"truncated", encodePNG(t, image.NewGray(image.Rect(0, 0, 10, 10)))[:40], ErrInvalid},
+		{"too large", huge, ErrTooLarge},
+	}
+	for _, tt := range tests {
+		if _, err := Process(tt.data); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
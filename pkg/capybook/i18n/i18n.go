@@ -0,0 +1,102 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed "locales"
+var localeFS embed.FS
+
+// Default is the locale used when nothing better can be negotiated, and the
+// fallback for messages missing from other catalogs.
+const Default = "en"
+
+// Supported lists the locales that have a message catalog.
+var Supported = []string{"en", "ru", "kk"}
+
+// catalogs maps a locale to its messages, keyed by stable message codes.
+var catalogs = make(map[string]map[string]string)
+
+func init() {
+	for _, locale := range Supported {
+		js, err := localeFS.ReadFile("locales/" + locale + ".json")
+		if err != nil {
+			panic(err)
+		}
+		catalog := make(map[string]string)
+		if err := json.Unmarshal(js, &catalog); err != nil {
+			panic("i18n: " + locale + ": " + err.Error())
+		}
+		catalogs[locale] = catalog
+	}
+}
+
+// Negotiate picks the supported locale best matching an Accept-Language header
+// value, e.g. "ru-RU,ru;q=0.9,en;q=0.8".
+func Negotiate(header string) string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	for _, t := range tags {
+		if t.tag == "*" {
+			return Default
+		}
+		base := strings.Split(t.tag, "-")[0]
+		for _, locale := range Supported {
+			if base == locale {
+				return locale
+			}
+		}
+	}
+	return Default
+}
+
+// Lookup returns the message stored under key for locale, falling back to the
+// default locale. params are name/value pairs filling in {name} placeholders.
+func Lookup(locale, key string, params ...string) (string, bool) {
+	message, ok := catalogs[locale][key]
+	if !ok {
+		message, ok = catalogs[Default][key]
+	}
+	if !ok {
+		return "", false
+	}
+	for i := 0; i+1 < len(params); i += 2 {
+		message = strings.ReplaceAll(message, "{"+params[i]+"}", params[i+1])
+	}
+	return message, true
+}
+
+// T is like Lookup but returns the key itself for unknown messages.
+func T(locale, key string, params ...string) string {
+	if message, ok := Lookup(locale, key, params...); ok {
+		return message
+	}
+	return key
+}
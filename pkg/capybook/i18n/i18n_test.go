@@ -0,0 +1,49 @@
+package i18n
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct{ header, want string }{
+		{"", "en"},
+		{"ru-RU,ru;q=0.9,en;q=0.8", "ru"},
+		{"de-DE, kk;q=0.5, ru;q=0.4", "kk"},
+		{"fr, *;q=0.1", "en"},
+		{"ru;q=0, kk", "kk"},
+		{"KK-kz", "kk"},
+	}
+	for _, tt := range tests {
+		if got := Negotiate(tt.header); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	message, ok := Lookup("ru", "request.unknown_key", "key", `"isbn"`)
+	if !ok || message != `тело запроса содержит неизвестный ключ "isbn"` {
+		t.Errorf("got %q, want the Russian message", message)
+	}
+	if _, ok := Lookup("en", "no.such.key"); ok {
+		t.Error("found a message for an unknown key")
+	}
+	if got := T("ru", "no.such.key"); got != "no.such.key" {
+		t.Errorf("T of an unknown key = %q, want the key", got)
+	}
+}
+
+// Every catalog translates every message, so none of them quietly falls back
+// to English.
+func TestCatalogsHaveTheSameKeys(t *testing.T) {
+	for _, locale := range Supported {
+		for key := range catalogs[Default] {
+			if _, ok := catalogs[locale][key]; !ok {
+				t.Errorf("%s is missing %s", locale, key)
+			}
+		}
+		for key := range catalogs[locale] {
+			if _, ok := catalogs[Default][key]; !ok {
+				t.Errorf("%s has %s, which %s lacks", locale, key, Default)
+			}
+		}
+	}
+}
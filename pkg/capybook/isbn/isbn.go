@@ -0,0 +1,96 @@
+// Package isbn validates International Standard Book Numbers and converts them
+// between their 10 and 13 digit forms.
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalid is returned for numbers that aren't ISBNs or whose check digit
+// doesn't match.
+var ErrInvalid = errors.New("isbn: invalid ISBN")
+
+// Normalize returns the ISBN-13 form of an ISBN-10 or ISBN-13. Hyphens and
+// spaces are ignored, as is an "ISBN" prefix. Thirteen digit numbers must
+// start with 978 or 979, so other EAN-13 barcodes aren't taken for ISBNs.
+func Normalize(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > 4 && strings.EqualFold(s[:4], "isbn") {
+		s = strings.TrimLeft(s[4:], ":- ")
+	}
+	s = strings.NewReplacer("-", "", " ", "").Replace(strings.ToUpper(s))
+	switch len(s) {
+	case 10:
+		if !valid10(s) {
+			return "", ErrInvalid
+		}
+		body := "978" + s[:9]
+		return body + string(checkDigit13(body)), nil
+	case 13:
+		if !digits(s) || (s[:3] != "978" && s[:3] != "979") || checkDigit13(s[:12]) != s[12] {
+			return "", ErrInvalid
+		}
+		return s, nil
+	}
+	return "", ErrInvalid
+}
+
+// Valid reports whether s is an ISBN-10 or ISBN-13.
+func Valid(s string) bool {
+	_, err := Normalize(s)
+	return err == nil
+}
+
+// To10 returns the ISBN-10 form of a normalized ISBN-13, or "" for the 979
+// numbers that have none.
+func To10(isbn13 string) string {
+	if len(isbn13) != 13 || !strings.HasPrefix(isbn13, "978") {
+		return ""
+	}
+	body := isbn13[3:12]
+	return body + string(checkDigit10(body))
+}
+
+func digits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func valid10(s string) bool {
+	if !digits(s[:9]) || (s[9] != 'X' && !digits(s[9:])) {
+		return false
+	}
+	return checkDigit10(s[:9]) == s[9]
+}
+
+// checkDigit10 computes the check digit of the first nine digits of an ISBN-10.
+func checkDigit10(body string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += (10 - i) * int(body[i]-'0')
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+// checkDigit13 computes the check digit of the first twelve digits of an
+// ISBN-13.
+func checkDigit13(body string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(body[i]-'0')
+	}
+	return byte('0' + (10-sum%10)%10)
+}
@@ -0,0 +1,44 @@
+package isbn
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"0-306-40615-2", "9780306406157"},
+		{"ISBN: 978-0-306-40615-7", "9780306406157"},
+		{"isbn 0 8044 2957 X", "9780804429573"},
+		{"979-10-90636-07-1", "9791090636071"},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("Normalize(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestNormalizeRejects(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"0-306-40615-3",     // wrong check digit
+		"978-0-306-40615-8", // wrong check digit
+		"4006381333931",     // an EAN-13 with a valid check digit, but not a book
+		"030640615",
+		"X306406152",
+	} {
+		if got, err := Normalize(in); err != ErrInvalid {
+			t.Errorf("Normalize(%q) = %q, %v; want ErrInvalid", in, got, err)
+		}
+	}
+}
+
+func TestTo10(t *testing.T) {
+	if got := To10("9780306406157"); got != "0306406152" {
+		t.Errorf("To10(9780306406157) = %q, want 0306406152", got)
+	}
+	if got := To10("9791090636071"); got != "" {
+		t.Errorf("To10(9791090636071) = %q, want none", got)
+	}
+}
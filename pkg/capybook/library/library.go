@@ -0,0 +1,197 @@
+// Package library reads the library exports of other reading sites, so that
+// users moving to Capybook can bring their ratings and reviews with them.
+package library
+
+import (
+	"encoding/csv"
+	"errors"
+	"html"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Sites whose exports can be read.
+const (
+	Goodreads  = "goodreads"
+	StoryGraph = "storygraph"
+)
+
+// Sources lists the sites exports can be read from.
+var Sources = []string{Goodreads, StoryGraph}
+
+// ErrUnknownSource is returned when the header of a file doesn't look like any
+// of the supported exports.
+var ErrUnknownSource = errors.New("library: unrecognized export format")
+
+// Entry is a book from someone's library, with their rating (0 when they
+// didn't rate it) and review.
+type Entry struct {
+	Line   int
+	Title  string
+	Author string
+	ISBN   string
+	Year   int32
+	Rating int
+	Review string
+}
+
+// columns names the columns of an export that entries are read from.
+type columns struct {
+	title, author, isbn, isbn13, rating, review string
+	years                                       []string
+}
+
+var sourceColumns = map[string]columns{
+	Goodreads: {
+		title:  "Title",
+		author: "Author",
+		isbn:   "ISBN",
+		isbn13: "ISBN13",
+		rating: "My Rating",
+		review: "My Review",
+		years:  []string{"Original Publication Year", "Year Published"},
+	},
+	StoryGraph: {
+		title:  "Title",
+		author: "Authors",
+		isbn:   "ISBN/UID",
+		rating: "Star Rating",
+		review: "Review",
+	},
+}
+
+// Detect tells which site an export with the given header comes from.
+func Detect(header []string) (string, error) {
+	names := make(map[string]bool)
+	for _, name := range header {
+		names[cleanHeader(name)] = true
+	}
+	switch {
+	case names["Book Id"] && names["My Rating"]:
+		return Goodreads, nil
+	case names["Star Rating"] && names["ISBN/UID"]:
+		return StoryGraph, nil
+	}
+	return "", ErrUnknownSource
+}
+
+// Read reads every entry of an export. source may be empty to detect it from
+// the header; the source used is returned along with the entries. A row that
+// can't be parsed as CSV ends the read with a *csv.ParseError.
+func Read(r io.Reader, source string) (string, []Entry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return "", nil, ErrUnknownSource
+		}
+		return "", nil, err
+	}
+	if source == "" {
+		if source, err = Detect(header); err != nil {
+			return "", nil, err
+		}
+	}
+	cols, ok := sourceColumns[source]
+	if !ok {
+		return "", nil, ErrUnknownSource
+	}
+	index := make(map[string]int)
+	for i, name := range header {
+		index[cleanHeader(name)] = i
+	}
+
+	var entries []Entry
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		get := func(column string) string {
+			i, ok := index[column]
+			if column == "" || !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		line, _ := cr.FieldPos(0)
+		entry := Entry{
+			Line:   line,
+			Title:  get(cols.title),
+			Author: firstAuthor(get(cols.author)),
+			ISBN:   cleanISBN(get(cols.isbn13)),
+			Rating: parseRating(get(cols.rating)),
+			Review: cleanReview(get(cols.review)),
+		}
+		if entry.ISBN == "" {
+			entry.ISBN = cleanISBN(get(cols.isbn))
+		}
+		for _, column := range cols.years {
+			if year, err := strconv.ParseInt(get(column), 10, 32); err == nil && year > 0 {
+				entry.Year = int32(year)
+				break
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return source, entries, nil
+}
+
+func cleanHeader(name string) string {
+	return strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+}
+
+// firstAuthor keeps the first of a comma-separated list of authors.
+func firstAuthor(authors string) string {
+	author, _, _ := strings.Cut(authors, ",")
+	return strings.TrimSpace(author)
+}
+
+// cleanISBN undoes the spreadsheet quoting Goodreads puts around ISBNs, as in
+// ="0441013597". Identifiers that aren't ISBNs are dropped.
+func cleanISBN(s string) string {
+	s = strings.Trim(strings.TrimPrefix(s, "="), `"`)
+	for _, r := range s {
+		if (r < '0' || r > '9') && r != 'X' && r != 'x' {
+			return ""
+		}
+	}
+	return s
+}
+
+// parseRating turns a star rating into a whole number of stars. StoryGraph
+// allows quarter stars; anything rated rounds to at least one star.
+func parseRating(s string) int {
+	rating, err := strconv.ParseFloat(s, 64)
+	if err != nil || rating <= 0 {
+		return 0
+	}
+	return int(math.Max(1, math.Min(5, math.Round(rating))))
+}
+
+var (
+	lineBreakRE = regexp.MustCompile(`(?i)<br\s*/?>`)
+	tagRE       = regexp.MustCompile(`<[^>]*>`)
+)
+
+// cleanReview turns the HTML Goodreads exports reviews as into plain text.
+func cleanReview(s string) string {
+	s = lineBreakRE.ReplaceAllString(s, "\n")
+	s = tagRE.ReplaceAllString(s, "")
+	return strings.TrimSpace(html.UnescapeString(s))
+}
+
+var seriesRE = regexp.MustCompile(`\s*\([^()]*#\s*[\d.]+\)$`)
+
+// BaseTitle strips the series Goodreads appends to titles, as in
+// "Dune Messiah (Dune Chronicles, #2)".
+func BaseTitle(title string) string {
+	return strings.TrimSpace(seriesRE.ReplaceAllString(title, ""))
+}
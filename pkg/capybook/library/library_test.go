@@ -0,0 +1,83 @@
+package library
+
+import (
+	"encoding/csv"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadGoodreads(t *testing.T) {
+	export := "\ufeffBook Id,Title,Author,ISBN,ISBN13,My Rating,Year Published,Original Publication Year,My Review\n" +
+		`1,Dune (Dune Chronicles #1),Frank Herbert,"=""0441013597""","=""9780441013593""",5,2005,1965,"Still <i>great</i>.<br/>Spice &amp; all"` + "\n" +
+		`2,The Hobbit,J.R.R. Tolkien,"=""""","=""""",0,1999,,` + "\n"
+	source, entries, err := Read(strings.NewReader(export), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != Goodreads {
+		t.Errorf("got source %q, want goodreads", source)
+	}
+	want := []Entry{
+		{Line: 2, Title: "Dune (Dune Chronicles #1)", Author: "Frank Herbert", ISBN: "9780441013593", Year: 1965, Rating: 5, Review: "Still great.\nSpice & all"},
+		{Line: 3, Title: "The Hobbit", Author: "J.R.R. Tolkien", Year: 1999},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got entries\n%+v\nwant\n%+v", entries, want)
+	}
+}
+
+func TestReadStoryGraph(t *testing.T) {
+	export := "Title,Authors,ISBN/UID,Format,Read Status,Star Rating,Review\n" +
+		"Good Omens,\"Terry Pratchett, Neil Gaiman\",9780060853983,paperback,read,4.25,Funny\n" +
+		"Piranesi,Susanna Clarke,not-an-isbn,ebook,read,0.25,\n" +
+		"Circe,Madeline Miller,,audio,to-read,,\n"
+	source, entries, err := Read(strings.NewReader(export), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != StoryGraph {
+		t.Errorf("got source %q, want storygraph", source)
+	}
+	want := []Entry{
+		{Line: 2, Title: "Good Omens", Author: "Terry Pratchett", ISBN: "9780060853983", Rating: 4, Review: "Funny"},
+		{Line: 3, Title: "Piranesi", Author: "Susanna Clarke", Rating: 1},
+		{Line: 4, Title: "Circe", Author: "Madeline Miller"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("got entries\n%+v\nwant\n%+v", entries, want)
+	}
+}
+
+func TestReadRejects(t *testing.T) {
+	if _, _, err := Read(strings.NewReader(""), ""); !errors.Is(err, ErrUnknownSource) {
+		t.Errorf("empty file: got %v, want ErrUnknownSource", err)
+	}
+	if _, _, err := Read(strings.NewReader("Name,Stars\nDune,5\n"), ""); !errors.Is(err, ErrUnknownSource) {
+		t.Errorf("unknown header: got %v, want ErrUnknownSource", err)
+	}
+	if _, _, err := Read(strings.NewReader("Title\nDune\n"), "librarything"); !errors.Is(err, ErrUnknownSource) {
+		t.Errorf("unknown source: got %v, want ErrUnknownSource", err)
+	}
+	_, _, err := Read(strings.NewReader("Book Id,Title,My Rating\n1,\"Dune,5\n"), "")
+	var parseErr *csv.ParseError
+	if !errors.As(err, &parseErr) || parseErr.Line != 2 {
+		t.Errorf("malformed row: got %v, want a parse error on line 2", err)
+	}
+}
+
+func TestBaseTitle(t *testing.T) {
+	tests := map[string]string{
+		"Dune Messiah (Dune Chronicles, #2)":   "Dune Messiah",
+		"Mort (Discworld, #4; Death, #1)":      "Mort",
+		"The Fellowship (Middle-earth #1.5)":   "The Fellowship",
+		"Jonathan Strange & Mr Norrell":        "Jonathan Strange & Mr Norrell",
+		"Slaughterhouse-Five (Modern Library)": "Slaughterhouse-Five (Modern Library)",
+	}
+	for title, want := range tests {
+		if got := BaseTitle(title); got != want {
+			t.Errorf("BaseTitle(%q) = %q, want %q", title, got, want)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/go-mail/mail/v2"
+	"github.com/shyndaliu/capybook/pkg/capybook/i18n"
 )
 
 //go:embed "templates"
@@ -26,9 +27,15 @@ func New(host string, port int, username, password, sender string) Mailer {
 	}
 }
 
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
-
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+// Send renders templateFile in the given locale and mails it to recipient.
+// Templates pull their wording from the i18n catalogs with {{t "key"}}.
+func (m Mailer) Send(recipient, locale, templateFile string, data interface{}) error {
+	funcs := template.FuncMap{
+		"t": func(key string, params ...string) string {
+			return i18n.T(locale, key, params...)
+		},
+	}
+	tmpl, err := template.New("email").Funcs(funcs).ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,23 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS language text NOT NULL DEFAULT 'english';
+ALTER TABLE books ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+-- Titles and authors are indexed both stemmed (in the book's own language) and
+-- verbatim, so that prefix matching works regardless of the search language.
+CREATE OR REPLACE FUNCTION books_search_vector_update() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector(NEW.language::regconfig, coalesce(NEW.title, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(NEW.title, '')), 'A') ||
+        setweight(to_tsvector('simple', coalesce(NEW.author, '')), 'B') ||
+        setweight(to_tsvector(NEW.language::regconfig, coalesce(NEW.description, '')), 'C');
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER books_search_vector_trigger
+BEFORE INSERT OR UPDATE OF title, author, description, language ON books
+FOR EACH ROW EXECUTE FUNCTION books_search_vector_update();
+
+UPDATE books SET title = title;
+
+CREATE INDEX IF NOT EXISTS books_search_vector_idx ON books USING GIN (search_vector);
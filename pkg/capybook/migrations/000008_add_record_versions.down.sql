@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS reviews_book_id_updated_at_idx;
+DROP INDEX IF EXISTS books_updated_at_idx;
+ALTER TABLE reviews DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE reviews DROP COLUMN IF EXISTS version;
+ALTER TABLE books DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE books DROP COLUMN IF EXISTS version;
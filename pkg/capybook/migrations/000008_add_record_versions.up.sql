@@ -0,0 +1,8 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS updated_at timestamp with time zone NOT NULL DEFAULT NOW();
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS updated_at timestamp with time zone NOT NULL DEFAULT NOW();
+
+-- Listings are revalidated against the latest modification of the table.
+CREATE INDEX IF NOT EXISTS books_updated_at_idx ON books (updated_at);
+CREATE INDEX IF NOT EXISTS reviews_book_id_updated_at_idx ON reviews (book_id, updated_at);
@@ -0,0 +1,2 @@
+-- Imports look books up by title and author, ignoring case and surrounding space.
+CREATE INDEX IF NOT EXISTS books_title_author_key_idx ON books (lower(trim(title)), lower(trim(author)));
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS import_jobs;
+DROP INDEX IF EXISTS books_pending_idx;
+ALTER TABLE books DROP COLUMN IF EXISTS pending;
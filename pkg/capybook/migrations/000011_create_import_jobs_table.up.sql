@@ -0,0 +1,22 @@
+-- Books created by library imports only have a title and an author until an
+-- editor fills in the rest, and stay out of the catalog until then.
+ALTER TABLE books ADD COLUMN IF NOT EXISTS pending boolean NOT NULL DEFAULT false;
+CREATE INDEX IF NOT EXISTS books_pending_idx ON books (id) WHERE pending;
+
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    source text NOT NULL,
+    status text NOT NULL DEFAULT 'queued',
+    total integer NOT NULL DEFAULT 0,
+    processed integer NOT NULL DEFAULT 0,
+    matched integer NOT NULL DEFAULT 0,
+    created_books integer NOT NULL DEFAULT 0,
+    reviews integer NOT NULL DEFAULT 0,
+    skipped integer NOT NULL DEFAULT 0,
+    issues jsonb NOT NULL DEFAULT '[]',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    finished_at timestamp(0) with time zone
+);
+CREATE INDEX IF NOT EXISTS import_jobs_user_id_idx ON import_jobs (user_id);
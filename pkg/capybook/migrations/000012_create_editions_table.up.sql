@@ -0,0 +1,16 @@
+-- A book is the work that reviews attach to; editions are its printings and
+-- translations. ISBNs are stored in their ISBN-13 form.
+CREATE TABLE IF NOT EXISTS editions (
+    id bigserial PRIMARY KEY,
+    book_id bigint NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    isbn13 text UNIQUE,
+    title text NOT NULL DEFAULT '',
+    publisher text NOT NULL DEFAULT '',
+    language text NOT NULL DEFAULT '',
+    format text NOT NULL DEFAULT '',
+    pages integer NOT NULL DEFAULT 0,
+    year integer NOT NULL DEFAULT 0,
+    version integer NOT NULL DEFAULT 1,
+    updated_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS editions_book_id_idx ON editions (book_id);
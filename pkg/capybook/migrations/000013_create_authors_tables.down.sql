@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS book_authors;
+DROP TABLE IF EXISTS author_aliases;
+DROP TABLE IF EXISTS authors;
+DROP FUNCTION IF EXISTS author_key(text);
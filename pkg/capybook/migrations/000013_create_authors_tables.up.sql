@@ -0,0 +1,64 @@
+-- author_key normalizes a name for matching: case, punctuation and spacing
+-- are ignored, so "J.R.R. Tolkien" and "J. R. R. Tolkien" are the same key.
+CREATE OR REPLACE FUNCTION author_key(name text) RETURNS text
+    LANGUAGE sql IMMUTABLE STRICT
+    AS $$ SELECT trim(regexp_replace(lower(name), '[^[:alnum:]]+', ' ', 'g')) $$;
+
+CREATE TABLE IF NOT EXISTS authors (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    bio text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    updated_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Every name an author is known by, including their own.
+CREATE TABLE IF NOT EXISTS author_aliases (
+    key text PRIMARY KEY,
+    author_id bigint NOT NULL REFERENCES authors(id) ON DELETE CASCADE,
+    name text NOT NULL
+);
+CREATE INDEX IF NOT EXISTS author_aliases_author_id_idx ON author_aliases (author_id);
+
+-- The people credited on a book. The author at position 0 is the one named by
+-- books.author.
+CREATE TABLE IF NOT EXISTS book_authors (
+    book_id bigint NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    author_id bigint NOT NULL REFERENCES authors(id) ON DELETE CASCADE,
+    role text NOT NULL DEFAULT 'author' CHECK (role IN ('author', 'translator', 'illustrator', 'editor')),
+    position integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (book_id, author_id, role)
+);
+CREATE INDEX IF NOT EXISTS book_authors_author_id_idx ON book_authors (author_id);
+
+-- Backfill: one author per distinct key of books.author, named after its most
+-- common spelling, credited on every book with that key. Bylines are
+-- deliberately not split: "A, B" and "A and B" are as often one name ("Tolkien,
+-- J.R.R.", "Marks and Spencer") as two, so a co-authored byline becomes one
+-- author until an editor credits the people on it with PUT /books/{id}/authors.
+WITH bylines AS (
+    SELECT author AS name, author_key(author) AS key, count(*) AS books
+    FROM books
+    GROUP BY author
+),
+canonical AS (
+    SELECT DISTINCT ON (key) key, name
+    FROM bylines
+    WHERE key <> ''
+    ORDER BY key, books DESC, name
+),
+inserted AS (
+    INSERT INTO authors (name)
+    SELECT name FROM canonical
+    RETURNING id, name
+)
+INSERT INTO author_aliases (key, author_id, name)
+SELECT canonical.key, inserted.id, canonical.name
+FROM canonical
+JOIN inserted ON inserted.name = canonical.name;
+
+INSERT INTO book_authors (book_id, author_id, role, position)
+SELECT books.id, author_aliases.author_id, 'author', 0
+FROM books
+JOIN author_aliases ON author_aliases.key = author_key(books.author)
+ON CONFLICT DO NOTHING;
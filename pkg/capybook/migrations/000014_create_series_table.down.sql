@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS books_series_position_idx;
+ALTER TABLE books DROP COLUMN IF EXISTS series_position;
+ALTER TABLE books DROP COLUMN IF EXISTS series_id;
+DROP TABLE IF EXISTS series;
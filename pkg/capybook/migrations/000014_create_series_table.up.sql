@@ -0,0 +1,13 @@
+-- A book belongs to at most one series, at a position that may be fractional
+-- for novellas set between volumes (2.5).
+CREATE TABLE IF NOT EXISTS series (
+    id bigserial PRIMARY KEY,
+    title text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    updated_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+ALTER TABLE books ADD COLUMN IF NOT EXISTS series_id bigint REFERENCES series(id) ON DELETE SET NULL;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS series_position numeric(6, 2) CHECK (series_position >= 0);
+CREATE UNIQUE INDEX IF NOT EXISTS books_series_position_idx ON books (series_id, series_position)
+    WHERE series_id IS NOT NULL;
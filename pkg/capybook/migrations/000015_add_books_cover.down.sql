@@ -0,0 +1,2 @@
+ALTER TABLE books DROP COLUMN IF EXISTS cover_url;
+ALTER TABLE books DROP COLUMN IF EXISTS cover_key;
@@ -0,0 +1,4 @@
+-- Covers are stored as a set of renditions under cover_key; cover_url is the
+-- address that key was served from when the cover was uploaded.
+ALTER TABLE books ADD COLUMN IF NOT EXISTS cover_key text;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS cover_url text;
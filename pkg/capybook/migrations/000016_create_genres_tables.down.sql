@@ -0,0 +1,5 @@
+DROP FUNCTION IF EXISTS genre_subtree(text);
+DROP FUNCTION IF EXISTS canonical_genres(text[]);
+DROP TABLE IF EXISTS genre_aliases;
+DROP TABLE IF EXISTS genres;
+DROP FUNCTION IF EXISTS genre_key(text);
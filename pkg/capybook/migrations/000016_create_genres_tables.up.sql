@@ -0,0 +1,85 @@
+-- genre_key normalizes a genre for matching: case, punctuation and spacing are
+-- ignored, so "Sci-Fi", "sci fi" and "scifi" are the same key.
+CREATE OR REPLACE FUNCTION genre_key(name text) RETURNS text
+    LANGUAGE sql IMMUTABLE STRICT
+    AS $$ SELECT regexp_replace(lower(name), '[^[:alnum:]]+', '', 'g') $$;
+
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    parent_id bigint REFERENCES genres(id) ON DELETE SET NULL,
+    version integer NOT NULL DEFAULT 1,
+    updated_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS genres_parent_id_idx ON genres (parent_id);
+
+-- Every spelling a genre is known by, including its own name.
+CREATE TABLE IF NOT EXISTS genre_aliases (
+    key text PRIMARY KEY,
+    genre_id bigint NOT NULL REFERENCES genres(id) ON DELETE CASCADE,
+    name text NOT NULL
+);
+CREATE INDEX IF NOT EXISTS genre_aliases_genre_id_idx ON genre_aliases (genre_id);
+
+-- canonical_genres maps every genre to the name of the genre it is an alias
+-- of, keeping genres outside the taxonomy as they are, and drops duplicates
+-- while keeping the order.
+CREATE OR REPLACE FUNCTION canonical_genres(names text[]) RETURNS text[]
+    LANGUAGE sql STABLE
+    AS $$
+    SELECT coalesce(array_agg(name ORDER BY ord), '{}') FROM (
+        SELECT DISTINCT ON (genre_key(name)) name, ord FROM (
+            SELECT coalesce(genres.name, trim(input.name)) AS name, input.ord
+            FROM unnest(names) WITH ORDINALITY AS input(name, ord)
+            LEFT JOIN genre_aliases ON genre_aliases.key = genre_key(input.name)
+            LEFT JOIN genres ON genres.id = genre_aliases.genre_id
+            WHERE genre_key(input.name) <> ''
+        ) AS mapped
+        ORDER BY genre_key(name), ord
+    ) AS deduped
+    $$;
+
+-- genre_subtree returns the canonical name of a genre and of all genres below
+-- it, or just the name itself for genres outside the taxonomy.
+CREATE OR REPLACE FUNCTION genre_subtree(genre text) RETURNS text[]
+    LANGUAGE sql STABLE
+    AS $$
+    WITH RECURSIVE subtree AS (
+        SELECT genres.id, genres.name
+        FROM genre_aliases
+        JOIN genres ON genres.id = genre_aliases.genre_id
+        WHERE genre_aliases.key = genre_key(genre)
+        UNION
+        SELECT genres.id, genres.name
+        FROM genres
+        JOIN subtree ON genres.parent_id = subtree.id
+    )
+    SELECT coalesce(array_agg(name), ARRAY[trim(genre)]) FROM subtree
+    $$;
+
+-- Backfill: one genre per distinct key of books.genres, named after its most
+-- common spelling. Spellings that normalize differently ("sci-fi" and "science
+-- fiction") stay separate until an editor merges them.
+WITH spellings AS (
+    SELECT trim(genre) AS name, genre_key(genre) AS key, count(*) AS books
+    FROM books, unnest(books.genres) AS genre
+    GROUP BY trim(genre), genre_key(genre)
+),
+canonical AS (
+    SELECT DISTINCT ON (key) key, name
+    FROM spellings
+    WHERE key <> ''
+    ORDER BY key, books DESC, name
+),
+inserted AS (
+    INSERT INTO genres (name)
+    SELECT name FROM canonical
+    RETURNING id, name
+)
+INSERT INTO genre_aliases (key, genre_id, name)
+SELECT canonical.key, inserted.id, canonical.name
+FROM canonical
+JOIN inserted ON inserted.name = canonical.name;
+
+UPDATE books SET genres = canonical_genres(genres)
+WHERE genres <> canonical_genres(genres);
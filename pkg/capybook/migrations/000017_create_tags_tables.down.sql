@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS tag_votes;
+DROP TABLE IF EXISTS book_tags;
+DROP TABLE IF EXISTS tags;
+DELETE FROM permissions WHERE code = 'tags:moderate';
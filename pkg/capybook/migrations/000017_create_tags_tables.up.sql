@@ -0,0 +1,36 @@
+INSERT INTO permissions (code)
+VALUES
+('tags:moderate');
+
+-- Free-form tags users attach to books. The key is the name lowercased with
+-- every run of other characters turned into a hyphen ("Found Family" is
+-- found-family); the name is the spelling the tag was first added with.
+CREATE TABLE IF NOT EXISTS tags (
+    id bigserial PRIMARY KEY,
+    key text NOT NULL UNIQUE,
+    name text NOT NULL,
+    banned boolean NOT NULL DEFAULT false,
+    updated_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+-- The tags attached to each book. A tag removed by a moderator keeps its row,
+-- so that it can't be attached to the book again.
+CREATE TABLE IF NOT EXISTS book_tags (
+    book_id bigint NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    tag_id bigint NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    removed_at timestamp with time zone,
+    updated_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (book_id, tag_id)
+);
+CREATE INDEX IF NOT EXISTS book_tags_tag_id_idx ON book_tags (tag_id);
+
+-- One row per user agreeing that a tag fits a book, including the user who
+-- attached it.
+CREATE TABLE IF NOT EXISTS tag_votes (
+    book_id bigint NOT NULL,
+    tag_id bigint NOT NULL,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (book_id, tag_id, user_id),
+    FOREIGN KEY (book_id, tag_id) REFERENCES book_tags (book_id, tag_id) ON DELETE CASCADE
+);
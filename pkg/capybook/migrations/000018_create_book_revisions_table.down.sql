@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS book_revisions_trigger ON books;
+DROP FUNCTION IF EXISTS book_revisions_record();
+DROP FUNCTION IF EXISTS book_revision_fields(books);
+DROP TABLE IF EXISTS book_revisions;
@@ -0,0 +1,76 @@
+-- Every change to a book, with the fields it changed and the state it left the
+-- book in. Revisions outlive the books they belong to, so there is no foreign
+-- key on book_id.
+CREATE TABLE IF NOT EXISTS book_revisions (
+    id bigserial PRIMARY KEY,
+    book_id bigint NOT NULL,
+    version integer NOT NULL,
+    action text NOT NULL CHECK (action IN ('create', 'update', 'delete')),
+    user_id bigint REFERENCES users(id) ON DELETE SET NULL,
+    changes jsonb NOT NULL,
+    snapshot jsonb NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS book_revisions_book_id_idx ON book_revisions (book_id, id);
+
+-- book_revision_fields returns the fields of a book that revisions track.
+CREATE OR REPLACE FUNCTION book_revision_fields(book books) RETURNS jsonb
+    LANGUAGE sql IMMUTABLE
+    AS $$ SELECT jsonb_build_object(
+        'title', book.title,
+        'author', book.author,
+        'year', book.year,
+        'description', book.description,
+        'genres', book.genres,
+        'language', book.language,
+        'pending', book.pending,
+        'series_id', book.series_id,
+        'series_position', book.series_position,
+        'cover_url', book.cover_url) $$;
+
+-- The user a change is made by is set for the transaction making it with
+-- set_config('capybook.user_id', ...); changes made without it are recorded
+-- as nobody's.
+CREATE OR REPLACE FUNCTION book_revisions_record() RETURNS trigger AS $$
+DECLARE
+    old_fields jsonb := '{}';
+    new_fields jsonb := '{}';
+    changed jsonb;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_fields := book_revision_fields(OLD);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_fields := book_revision_fields(NEW);
+    END IF;
+    SELECT coalesce(jsonb_object_agg(key, jsonb_build_object('old', old_fields -> key, 'new', new_fields -> key)), '{}')
+    INTO changed
+    FROM jsonb_object_keys(old_fields || new_fields) AS key
+    WHERE (old_fields -> key) IS DISTINCT FROM (new_fields -> key);
+    IF TG_OP = 'UPDATE' AND changed = '{}' THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO book_revisions (book_id, version, action, user_id, changes, snapshot)
+        VALUES (OLD.id, OLD.version, 'delete', nullif(current_setting('capybook.user_id', true), '')::bigint, changed, old_fields);
+    ELSE
+        INSERT INTO book_revisions (book_id, version, action, user_id, changes, snapshot)
+        VALUES (NEW.id, NEW.version, CASE TG_OP WHEN 'INSERT' THEN 'create' ELSE 'update' END,
+            nullif(current_setting('capybook.user_id', true), '')::bigint, changed, new_fields);
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_revisions_trigger
+AFTER INSERT OR UPDATE OR DELETE ON books
+FOR EACH ROW EXECUTE FUNCTION book_revisions_record();
+
+-- Backfill: existing books start their history with their current state.
+INSERT INTO book_revisions (book_id, version, action, changes, snapshot, created_at)
+SELECT books.id, books.version, 'create',
+    (SELECT jsonb_object_agg(key, jsonb_build_object('old', NULL, 'new', value))
+     FROM jsonb_each(book_revision_fields(books))),
+    book_revision_fields(books), books.updated_at
+FROM books;
@@ -0,0 +1,20 @@
+-- Changes to the catalog proposed by users without the books:write permission,
+-- waiting for a librarian. A suggestion without a book_id proposes a new book
+-- and its changes hold every field of it; otherwise they hold the fields to
+-- change, as a merge patch. The locale is the one the suggestion was made in,
+-- which the submitter is notified in.
+CREATE TABLE IF NOT EXISTS suggestions (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    book_id bigint REFERENCES books(id) ON DELETE CASCADE,
+    changes jsonb NOT NULL,
+    comment text NOT NULL DEFAULT '',
+    locale text NOT NULL,
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    reviewer_id bigint REFERENCES users(id) ON DELETE SET NULL,
+    review_comment text NOT NULL DEFAULT '',
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    reviewed_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS suggestions_status_idx ON suggestions (status, id);
+CREATE INDEX IF NOT EXISTS suggestions_user_id_idx ON suggestions (user_id, id);
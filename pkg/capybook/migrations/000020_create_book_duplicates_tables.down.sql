@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS book_redirects;
+DROP TABLE IF EXISTS book_duplicates;
@@ -0,0 +1,26 @@
+-- Pairs of books that look like the same work, for a librarian to merge or
+-- dismiss. book_id is the older book of the pair. Similarities are those of the
+-- normalized titles and authors, from 0 to 1, and the score is their average;
+-- books found sharing an ISBN score 1 whatever their titles.
+CREATE TABLE IF NOT EXISTS book_duplicates (
+    id bigserial PRIMARY KEY,
+    book_id bigint NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    duplicate_id bigint NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    title_similarity real NOT NULL,
+    author_similarity real NOT NULL,
+    score real NOT NULL,
+    same_isbn boolean NOT NULL DEFAULT false,
+    dismissed boolean NOT NULL DEFAULT false,
+    detected_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (book_id, duplicate_id),
+    CHECK (book_id < duplicate_id)
+);
+CREATE INDEX IF NOT EXISTS book_duplicates_duplicate_id_idx ON book_duplicates (duplicate_id);
+
+-- The ids of books merged into other books, and the book each now leads to.
+CREATE TABLE IF NOT EXISTS book_redirects (
+    id bigint PRIMARY KEY,
+    book_id bigint NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS book_redirects_book_id_idx ON book_redirects (book_id);
@@ -0,0 +1,58 @@
+-- Whatever is still in the trash goes for good.
+DELETE FROM reviews WHERE deleted_at IS NOT NULL;
+DELETE FROM books WHERE deleted_at IS NOT NULL;
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION book_revisions_record() RETURNS trigger AS $$
+DECLARE
+    old_fields jsonb := '{}';
+    new_fields jsonb := '{}';
+    changed jsonb;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_fields := book_revision_fields(OLD);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_fields := book_revision_fields(NEW);
+    END IF;
+    SELECT coalesce(jsonb_object_agg(key, jsonb_build_object('old', old_fields -> key, 'new', new_fields -> key)), '{}')
+    INTO changed
+    FROM jsonb_object_keys(old_fields || new_fields) AS key
+    WHERE (old_fields -> key) IS DISTINCT FROM (new_fields -> key);
+    IF TG_OP = 'UPDATE' AND changed = '{}' THEN
+        RETURN NULL;
+    END IF;
+
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO book_revisions (book_id, version, action, user_id, changes, snapshot)
+        VALUES (OLD.id, OLD.version, 'delete', nullif(current_setting('capybook.user_id', true), '')::bigint, changed, old_fields);
+    ELSE
+        INSERT INTO book_revisions (book_id, version, action, user_id, changes, snapshot)
+        VALUES (NEW.id, NEW.version, CASE TG_OP WHEN 'INSERT' THEN 'create' ELSE 'update' END,
+            nullif(current_setting('capybook.user_id', true), '')::bigint, changed, new_fields);
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+UPDATE book_revisions SET action = 'update' WHERE action = 'restore';
+ALTER TABLE book_revisions
+    DROP CONSTRAINT IF EXISTS book_revisions_action_check,
+    ADD CONSTRAINT book_revisions_action_check CHECK (action IN ('create', 'update', 'delete'));
+
+ALTER TABLE admins
+    DROP CONSTRAINT IF EXISTS admins_user_id_fkey,
+    ADD CONSTRAINT admins_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+ALTER TABLE reviews
+    DROP CONSTRAINT IF EXISTS reviews_user_id_fkey,
+    ADD CONSTRAINT reviews_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id),
+    DROP CONSTRAINT IF EXISTS reviews_book_id_fkey,
+    ADD CONSTRAINT reviews_book_id_fkey FOREIGN KEY (book_id) REFERENCES books(id);
+
+DROP INDEX IF EXISTS books_series_position_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS books_series_position_idx ON books (series_id, series_position)
+    WHERE series_id IS NOT NULL;
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE reviews DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE books DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,75 @@
+-- Deleting a book, a review or a user moves it to the trash, where it stays
+-- out of every listing until it is restored or purged for good. Trashing a
+-- book or a user trashes their reviews along with it, at the same time.
+ALTER TABLE books ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+CREATE INDEX IF NOT EXISTS books_deleted_at_idx ON books (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS reviews_deleted_at_idx ON reviews (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- A book in the trash gives up its place in its series.
+DROP INDEX IF EXISTS books_series_position_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS books_series_position_idx ON books (series_id, series_position)
+    WHERE series_id IS NOT NULL AND deleted_at IS NULL;
+
+-- Purging a book or a user takes everything of theirs with it.
+ALTER TABLE reviews
+    DROP CONSTRAINT IF EXISTS reviews_user_id_fkey,
+    ADD CONSTRAINT reviews_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    DROP CONSTRAINT IF EXISTS reviews_book_id_fkey,
+    ADD CONSTRAINT reviews_book_id_fkey FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE;
+ALTER TABLE admins
+    DROP CONSTRAINT IF EXISTS admins_user_id_fkey,
+    ADD CONSTRAINT admins_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE book_revisions
+    DROP CONSTRAINT IF EXISTS book_revisions_action_check,
+    ADD CONSTRAINT book_revisions_action_check CHECK (action IN ('create', 'update', 'delete', 'restore'));
+
+-- Moving a book to the trash is recorded as its deletion and taking it out as
+-- its restoration. Purging it from the trash records nothing more.
+CREATE OR REPLACE FUNCTION book_revisions_record() RETURNS trigger AS $$
+DECLARE
+    old_fields jsonb := '{}';
+    new_fields jsonb := '{}';
+    kind text;
+    changed jsonb;
+BEGIN
+    IF TG_OP = 'DELETE' AND OLD.deleted_at IS NOT NULL THEN
+        RETURN NULL;
+    END IF;
+    IF TG_OP <> 'INSERT' THEN
+        old_fields := book_revision_fields(OLD);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_fields := book_revision_fields(NEW);
+    END IF;
+    kind := CASE TG_OP WHEN 'INSERT' THEN 'create' WHEN 'DELETE' THEN 'delete' ELSE 'update' END;
+    IF TG_OP = 'UPDATE' AND OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        kind := 'delete';
+        new_fields := '{}';
+    ELSIF TG_OP = 'UPDATE' AND OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        kind := 'restore';
+        old_fields := '{}';
+    END IF;
+    SELECT coalesce(jsonb_object_agg(key, jsonb_build_object('old', old_fields -> key, 'new', new_fields -> key)), '{}')
+    INTO changed
+    FROM jsonb_object_keys(old_fields || new_fields) AS key
+    WHERE (old_fields -> key) IS DISTINCT FROM (new_fields -> key);
+    IF kind = 'update' AND changed = '{}' THEN
+        RETURN NULL;
+    END IF;
+
+    IF kind = 'delete' THEN
+        INSERT INTO book_revisions (book_id, version, action, user_id, changes, snapshot)
+        VALUES (OLD.id, CASE TG_OP WHEN 'DELETE' THEN OLD.version ELSE NEW.version END, kind,
+            nullif(current_setting('capybook.user_id', true), '')::bigint, changed, old_fields);
+    ELSE
+        INSERT INTO book_revisions (book_id, version, action, user_id, changes, snapshot)
+        VALUES (NEW.id, NEW.version, kind,
+            nullif(current_setting('capybook.user_id', true), '')::bigint, changed, new_fields);
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,14 @@
+DO $$
+DECLARE
+    catalog_table text;
+BEGIN
+    FOREACH catalog_table IN ARRAY ARRAY[
+        'books', 'reviews', 'editions', 'authors', 'author_aliases', 'book_authors', 'series',
+        'genres', 'genre_aliases', 'tags', 'book_tags', 'tag_votes'
+    ] LOOP
+        EXECUTE format('DROP TRIGGER IF EXISTS %I_catalog_version ON %I', catalog_table, catalog_table);
+    END LOOP;
+END;
+$$;
+DROP FUNCTION IF EXISTS catalog_version_bump();
+DROP TABLE IF EXISTS catalog_version;
@@ -0,0 +1,34 @@
+-- One row whose version moves on every change to the catalog, so that cached
+-- responses built from any part of it are revalidated with a single lookup
+-- instead of aggregating every table. The bump is part of the writing
+-- transaction, so readers never see a version ahead of the data it stands for.
+CREATE TABLE IF NOT EXISTS catalog_version (
+    id boolean PRIMARY KEY DEFAULT true CHECK (id),
+    version bigint NOT NULL DEFAULT 1,
+    updated_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+INSERT INTO catalog_version DEFAULT VALUES ON CONFLICT DO NOTHING;
+
+CREATE OR REPLACE FUNCTION catalog_version_bump() RETURNS trigger AS $$
+BEGIN
+    UPDATE catalog_version SET version = version + 1, updated_at = NOW();
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DO $$
+DECLARE
+    catalog_table text;
+BEGIN
+    FOREACH catalog_table IN ARRAY ARRAY[
+        'books', 'reviews', 'editions', 'authors', 'author_aliases', 'book_authors', 'series',
+        'genres', 'genre_aliases', 'tags', 'book_tags', 'tag_votes'
+    ] LOOP
+        EXECUTE format('DROP TRIGGER IF EXISTS %I_catalog_version ON %I', catalog_table, catalog_table);
+        EXECUTE format(
+            'CREATE TRIGGER %I_catalog_version AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON %I '
+            'FOR EACH STATEMENT EXECUTE FUNCTION catalog_version_bump()',
+            catalog_table, catalog_table);
+    END LOOP;
+END;
+$$;
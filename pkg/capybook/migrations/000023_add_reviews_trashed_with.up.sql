@@ -0,0 +1,13 @@
+-- Why a review is in the trash: along with its book or its author, or on its
+-- own when trashed_with is null. Restoring the book or the author brings back
+-- the reviews that went with them; a review whose other side is still in the
+-- trash is handed over to it, to come back when that is restored.
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS trashed_with text CHECK (trashed_with IN ('book', 'user'));
+
+UPDATE reviews
+SET trashed_with = CASE
+    WHEN reviews.deleted_at = books.deleted_at THEN 'book'
+    WHEN reviews.deleted_at = users.deleted_at THEN 'user'
+END
+FROM books, users
+WHERE books.id = reviews.book_id AND users.id = reviews.user_id AND reviews.deleted_at IS NOT NULL;
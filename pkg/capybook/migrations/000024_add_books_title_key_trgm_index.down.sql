@@ -0,0 +1 @@
+DROP INDEX IF EXISTS books_title_key_trgm_idx;
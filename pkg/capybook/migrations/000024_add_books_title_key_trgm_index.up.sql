@@ -0,0 +1,4 @@
+-- Duplicate detection pairs up books whose normalized titles are alike, so the
+-- trigram index has to be on the same expression to be used.
+CREATE INDEX IF NOT EXISTS books_title_key_trgm_idx ON books USING GIN (author_key(title) gin_trgm_ops)
+WHERE deleted_at IS NULL;
@@ -0,0 +1,65 @@
+-- The catalog version is kept per table, so that writers to different tables
+-- no longer queue up on a single row. Reviews and tag votes in particular are
+-- written far more often than the rest of the catalog. Readers add the
+-- versions up, which moves whenever any of them does.
+CREATE TABLE IF NOT EXISTS catalog_versions (
+    resource text PRIMARY KEY,
+    version bigint NOT NULL DEFAULT 1,
+    updated_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Statements that change no rows, such as an update whose WHERE matches
+-- nothing, leave the version alone. Transition tables can't be declared on a
+-- trigger for several events, so each event has its own trigger.
+CREATE OR REPLACE FUNCTION catalog_version_bump() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM 1 FROM new_rows LIMIT 1;
+    ELSIF TG_OP = 'DELETE' THEN
+        PERFORM 1 FROM old_rows LIMIT 1;
+    END IF;
+    IF TG_OP = 'TRUNCATE' OR FOUND THEN
+        UPDATE catalog_versions SET version = version + 1, updated_at = NOW() WHERE resource = TG_TABLE_NAME;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DO $$
+DECLARE
+    catalog_table text;
+BEGIN
+    FOREACH catalog_table IN ARRAY ARRAY[
+        'books', 'reviews', 'editions', 'authors', 'author_aliases', 'book_authors', 'series',
+        'genres', 'genre_aliases', 'tags', 'book_tags', 'tag_votes'
+    ] LOOP
+        -- Start past the old single version, so that no tag handed out
+        -- before can come back.
+        INSERT INTO catalog_versions (resource, version)
+        SELECT catalog_table, version + 1 FROM catalog_version
+        ON CONFLICT DO NOTHING;
+        EXECUTE format('DROP TRIGGER IF EXISTS %I_catalog_version ON %I', catalog_table, catalog_table);
+        EXECUTE format(
+            'CREATE TRIGGER %I_catalog_version_insert AFTER INSERT ON %I '
+            'REFERENCING NEW TABLE AS new_rows '
+            'FOR EACH STATEMENT EXECUTE FUNCTION catalog_version_bump()',
+            catalog_table, catalog_table);
+        EXECUTE format(
+            'CREATE TRIGGER %I_catalog_version_update AFTER UPDATE ON %I '
+            'REFERENCING NEW TABLE AS new_rows '
+            'FOR EACH STATEMENT EXECUTE FUNCTION catalog_version_bump()',
+            catalog_table, catalog_table);
+        EXECUTE format(
+            'CREATE TRIGGER %I_catalog_version_delete AFTER DELETE ON %I '
+            'REFERENCING OLD TABLE AS old_rows '
+            'FOR EACH STATEMENT EXECUTE FUNCTION catalog_version_bump()',
+            catalog_table, catalog_table);
+        EXECUTE format(
+            'CREATE TRIGGER %I_catalog_version_truncate AFTER TRUNCATE ON %I '
+            'FOR EACH STATEMENT EXECUTE FUNCTION catalog_version_bump()',
+            catalog_table, catalog_table);
+    END LOOP;
+END;
+$$;
+
+DROP TABLE IF EXISTS catalog_version;
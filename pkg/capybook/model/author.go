@@ -0,0 +1,375 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+var (
+	ErrDuplicateAlias = errors.New("duplicate alias")
+	ErrUnknownAuthor  = errors.New("unknown author")
+	ErrAuthorNotFirst = errors.New("first credit is not an author")
+)
+
+type AuthorModel struct {
+	DB *sql.DB
+}
+
+// Author is a person credited on books. Aliases are the other spellings and
+// names they are known by; names are matched ignoring case, punctuation and
+// spacing.
+type Author struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name" validate:"required,max=200"`
+	Bio       string    `json:"bio,omitempty" validate:"max=2000"`
+	Aliases   []string  `json:"aliases"`
+	Version   int32     `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Credit is a person credited on a book, in a role.
+type Credit struct {
+	AuthorID int64  `json:"author_id" validate:"min=1"`
+	Name     string `json:"name,omitempty"`
+	Role     string `json:"role" validate:"required,oneof=author translator illustrator editor"`
+}
+
+// BibliographyEntry is a book an author is credited on, with their roles.
+type BibliographyEntry struct {
+	BookID int64    `json:"book_id"`
+	Title  string   `json:"title"`
+	Year   int32    `json:"year"`
+	Roles  []string `json:"roles"`
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// linkBylines credits the author named by books.author on each of the given
+// books that has no such credit yet, creating authors for names that match no
+// known alias. The byline is one name: co-authors in it are not split out,
+// since commas and "and" are just as often part of a single name, and editors
+// credit co-authors with SetCredits instead.
+func linkBylines(ctx context.Context, db execer, bookIDs []int64) error {
+	query := `
+	WITH unlinked AS (
+		SELECT books.id, books.author, author_key(books.author) AS key
+		FROM books
+		WHERE books.id = ANY($1) AND author_key(books.author) <> ''
+		AND NOT EXISTS (
+			SELECT 1 FROM book_authors
+			WHERE book_id = books.id AND role = 'author' AND position = 0
+		)
+	),
+	new_authors AS (
+		INSERT INTO authors (name)
+		SELECT DISTINCT ON (key) author FROM unlinked
+		WHERE NOT EXISTS (SELECT 1 FROM author_aliases WHERE author_aliases.key = unlinked.key)
+		ORDER BY key, author
+		RETURNING id, name
+	),
+	new_aliases AS (
+		INSERT INTO author_aliases (key, author_id, name)
+		SELECT author_key(name), id, name FROM new_authors
+		RETURNING key, author_id
+	)
+	INSERT INTO book_authors (book_id, author_id, role, position)
+	SELECT unlinked.id, coalesce(author_aliases.author_id, new_aliases.author_id), 'author', 0
+	FROM unlinked
+	LEFT JOIN author_aliases ON author_aliases.key = unlinked.key
+	LEFT JOIN new_aliases ON new_aliases.key = unlinked.key
+	ON CONFLICT DO NOTHING`
+	_, err := db.ExecContext(ctx, query, pq.Array(bookIDs))
+	return err
+}
+
+// relinkByline replaces the credit of the author named by books.author, after
+// the byline of a book changed.
+func relinkByline(ctx context.Context, db execer, bookID int64) error {
+	query := `
+	DELETE FROM book_authors
+	WHERE book_id = $1 AND role = 'author' AND position = 0`
+	if _, err := db.ExecContext(ctx, query, bookID); err != nil {
+		return err
+	}
+	return linkBylines(ctx, db, []int64{bookID})
+}
+
+// Insert adds an author, known by their own name.
+func (m AuthorModel) Insert(author *Author) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO authors (name, bio)
+	VALUES ($1, $2)
+	RETURNING id, version, updated_at`
+	err = tx.QueryRowContext(ctx, query, author.Name, author.Bio).Scan(&author.ID, &author.Version, &author.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if err = addAlias(ctx, tx, author.ID, author.Name); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	author.Aliases = []string{author.Name}
+	return nil
+}
+
+func (m AuthorModel) Get(id int64) (*Author, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT id, name, bio, version, updated_at,
+		array(SELECT name FROM author_aliases WHERE author_id = authors.id ORDER BY name)
+	FROM authors
+	WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var author Author
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&author.ID,
+		&author.Name,
+		&author.Bio,
+		&author.Version,
+		&author.UpdatedAt,
+		pq.Array(&author.Aliases),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &author, nil
+}
+
+// Update saves author if it is still at the version that was read. A new name
+// becomes one of the author's aliases, so books using it keep matching.
+func (m AuthorModel) Update(author *Author) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE authors
+	SET name = $1, bio = $2, version = version + 1, updated_at = NOW()
+	WHERE id = $3 AND version = $4
+	RETURNING version, updated_at`
+	err = tx.QueryRowContext(ctx, query, author.Name, author.Bio, author.ID, author.Version).
+		Scan(&author.Version, &author.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	if err = addAlias(ctx, tx, author.ID, author.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddAlias makes name one of the names author is known by. Names that already
+// belong to another author are rejected with ErrDuplicateAlias.
+func (m AuthorModel) AddAlias(authorID int64, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return addAlias(ctx, m.DB, authorID, name)
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func addAlias(ctx context.Context, db queryer, authorID int64, name string) error {
+	query := `
+	INSERT INTO author_aliases (key, author_id, name)
+	VALUES (author_key($2), $1, $2)
+	ON CONFLICT (key) DO UPDATE SET key = EXCLUDED.key
+	RETURNING author_id`
+	var owner int64
+	err := db.QueryRowContext(ctx, query, authorID, name).Scan(&owner)
+	if err != nil {
+		if strings.Contains(err.Error(), "author_aliases_author_id_fkey") {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	if owner != authorID {
+		return ErrDuplicateAlias
+	}
+	return nil
+}
+
+// Bibliography lists the books author is credited on, oldest first.
+func (m AuthorModel) Bibliography(authorID int64) ([]*BibliographyEntry, error) {
+	query := `
+	SELECT books.id, books.title, books.year, array_agg(book_authors.role ORDER BY book_authors.role)
+	FROM book_authors
+	JOIN books ON books.id = book_authors.book_id
+	WHERE book_authors.author_id = $1 AND NOT books.pending AND books.deleted_at IS NULL
+	GROUP BY books.id
+	ORDER BY books.year, books.id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, authorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []*BibliographyEntry{}
+	for rows.Next() {
+		var entry BibliographyEntry
+		if err := rows.Scan(&entry.BookID, &entry.Title, &entry.Year, pq.Array(&entry.Roles)); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
+
+// Stats aggregates the books author wrote and the reviews of those books.
+func (m AuthorModel) Stats(authorID int64) (*AuthorStats, error) {
+	query := `
+	SELECT count(DISTINCT books.id), count(reviews.id), coalesce(avg(reviews.rating), 0)
+	FROM book_authors
+	JOIN books ON books.id = book_authors.book_id
+	LEFT JOIN reviews ON reviews.book_id = books.id AND reviews.deleted_at IS NULL
+	WHERE book_authors.author_id = $1 AND book_authors.role = 'author' AND NOT books.pending
+	AND books.deleted_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var stats AuthorStats
+	err := m.DB.QueryRowContext(ctx, query, authorID).Scan(&stats.Books, &stats.Reviews, &stats.AverageRating)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// GetCredits fetches the credits of the given books in a single query, keyed
+// by book id and in credit order.
+func (m AuthorModel) GetCredits(bookIDs []int64) (map[int64][]*Credit, error) {
+	query := `
+	SELECT book_authors.book_id, authors.id, authors.name, book_authors.role
+	FROM book_authors
+	JOIN authors ON authors.id = book_authors.author_id
+	WHERE book_authors.book_id = ANY($1)
+	ORDER BY book_authors.book_id, book_authors.position, authors.name`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(bookIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	credits := make(map[int64][]*Credit)
+	for rows.Next() {
+		var bookID int64
+		var credit Credit
+		if err := rows.Scan(&bookID, &credit.AuthorID, &credit.Name, &credit.Role); err != nil {
+			return nil, err
+		}
+		credits[bookID] = append(credits[bookID], &credit)
+	}
+	return credits, rows.Err()
+}
+
+// SetCredits replaces the credits of a book, in the given order, on behalf of
+// a user. The first author credited becomes the one named by the byline, and
+// the book moves to a new version. That author must come first, at position 0,
+// where linkBylines and relinkByline look for the byline's credit; credits
+// that list anyone else before them are rejected with ErrAuthorNotFirst.
+func (m AuthorModel) SetCredits(bookID int64, credits []*Credit, userID int64) error {
+	for i, credit := range credits {
+		if credit.Role == "author" {
+			if i != 0 {
+				return ErrAuthorNotFirst
+			}
+			break
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = setEditor(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM book_authors WHERE book_id = $1`, bookID); err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO book_authors (book_id, author_id, role, position)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT DO NOTHING`
+	for i, credit := range credits {
+		if _, err = tx.ExecContext(ctx, query, bookID, credit.AuthorID, credit.Role, i); err != nil {
+			switch {
+			case strings.Contains(err.Error(), "book_authors_author_id_fkey"):
+				return ErrUnknownAuthor
+			case strings.Contains(err.Error(), "book_authors_book_id_fkey"):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+	}
+	query = `
+	UPDATE books
+	SET author = coalesce((
+		SELECT authors.name FROM book_authors
+		JOIN authors ON authors.id = book_authors.author_id
+		WHERE book_authors.book_id = books.id AND book_authors.role = 'author'
+		ORDER BY book_authors.position LIMIT 1
+	), author), version = version + 1, updated_at = NOW()
+	WHERE id = $1 AND deleted_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, bookID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return tx.Commit()
+}
+
+func ValidateAuthor(v *validator.Validator, author *Author) {
+	v.Struct(author)
+}
+
+func ValidateCredits(v *validator.Validator, credits []*Credit) {
+	v.Var("authors", credits, "max=20,dive")
+}
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+func TestAddAliasReportsNamesOfOtherAuthors(t *testing.T) {
+	tests := []struct {
+		owner   int64
+		wantErr error
+	}{
+		{3, nil},
+		{4, ErrDuplicateAlias},
+	}
+	for _, tt := range tests {
+		conn, db := newStubDB(t)
+		db.On("INSERT INTO author_aliases", func([]driver.NamedValue) stubResult {
+			return stubResult{Columns: []string{"author_id"}, Rows: [][]driver.Value{{tt.owner}}}
+		})
+		err := (AuthorModel{DB: conn}).AddAlias(3, "Lev Tolstoy")
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("alias owned by %d: got %v, want %v", tt.owner, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSetCreditsKeepsTheirOrder(t *testing.T) {
+	conn, db := newStubDB(t)
+	var positions []interface{}
+	db.On("INSERT INTO book_authors", func(args []driver.NamedValue) stubResult {
+		positions = append(positions, args[1].Value, args[3].Value)
+		return stubResult{}
+	})
+	db.On("UPDATE books", func([]driver.NamedValue) stubResult {
+		return stubResult{Rows: [][]driver.Value{{}}}
+	})
+	credits := []*Credit{{AuthorID: 1, Role: "author"}, {AuthorID: 2, Role: "translator"}}
+	if err := (AuthorModel{DB: conn}).SetCredits(9, credits, 7); err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{int64(1), int64(0), int64(2), int64(1)}
+	if len(positions) != len(want) {
+		t.Fatalf("credited %v, want %v", positions, want)
+	}
+	for i := range want {
+		if positions[i] != want[i] {
+			t.Errorf("credited %v, want %v", positions, want)
+			break
+		}
+	}
+	statements := db.Statements()
+	if statements[len(statements)-1] != "COMMIT" {
+		t.Errorf("the credits weren't committed: %v", statements)
+	}
+}
+
+func TestSetCreditsReportsUnknownAuthors(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("INSERT INTO book_authors", func([]driver.NamedValue) stubResult {
+		return stubResult{Err: errors.New(`pq: insert or update on table "book_authors" violates foreign key constraint "book_authors_author_id_fkey"`)}
+	})
+	err := (AuthorModel{DB: conn}).SetCredits(9, []*Credit{{AuthorID: 404, Role: "author"}}, 7)
+	if !errors.Is(err, ErrUnknownAuthor) {
+		t.Errorf("got %v, want ErrUnknownAuthor", err)
+	}
+}
+
+func TestSetCreditsKeepsTheBylineAuthorFirst(t *testing.T) {
+	tests := []struct {
+		name    string
+		credits []*Credit
+		wantErr error
+	}{
+		{"author first", []*Credit{{AuthorID: 1, Role: "author"}, {AuthorID: 2, Role: "translator"}, {AuthorID: 3, Role: "author"}}, nil},
+		{"no author", []*Credit{{AuthorID: 2, Role: "illustrator"}}, nil},
+		{"nobody", nil, nil},
+		{"translator first", []*Credit{{AuthorID: 2, Role: "translator"}, {AuthorID: 1, Role: "author"}}, ErrAuthorNotFirst},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, db := newStubDB(t)
+			db.On("UPDATE books", func([]driver.NamedValue) stubResult {
+				return stubResult{Rows: [][]driver.Value{{}}}
+			})
+			err := (AuthorModel{DB: conn}).SetCredits(1, tt.credits, 7)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil && len(db.Statements()) != 0 {
+				t.Errorf("rejected credits still reached the database: %v", db.Statements())
+			}
+		})
+	}
+}
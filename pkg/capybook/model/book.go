@@ -5,93 +5,607 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/lib/pq"
 	"github.com/shyndaliu/capybook/pkg/capybook/validator"
 )
 
+// SearchLanguages lists the PostgreSQL text search configurations a book (or a
+// search request) may use for stemming.
+var SearchLanguages = []string{
+	"simple", "english", "russian", "french", "german", "spanish", "italian",
+	"portuguese", "dutch", "turkish", "finnish", "swedish", "norwegian", "danish",
+}
+
 type BookModel struct {
 	DB *sql.DB
 }
 
 type Book struct {
-	ID          int64    `json:"id"`
-	Title       string   `json:"title"`
-	Author      string   `json:"author"`
-	Year        int32    `json:"year"`
-	Description string   `json:"description"`
-	Genres      []string `json:"genres"`
+	ID          int64          `json:"id"`
+	Title       string         `json:"title" validate:"required,max=200"`
+	Author      string         `json:"author" validate:"required,max=200"`
+	Year        int32          `json:"year" validate:"required,notfuture"`
+	Description string         `json:"description" validate:"required,max=500"`
+	Genres      []string       `json:"genres" validate:"required,min=1,max=5,unique,dive,required,max=50"`
+	Language    string         `json:"language" validate:"required,searchlang"`
+	Version     int32          `json:"version"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	Pending     bool           `json:"pending"`
+	Series      *BookSeries    `json:"series"`
+	Cover       *BookCover     `json:"cover"`
+	Highlight   *BookHighlight `json:"highlight,omitempty"`
+	DeletedAt   *time.Time     `json:"deleted_at,omitempty"`
+}
+
+func init() {
+	validator.Register("notfuture", func(value reflect.Value, _ string) *validator.FieldError {
+		if value.Int() <= int64(time.Now().Year()) {
+			return nil
+		}
+		return &validator.FieldError{Code: "in_future", Message: "must not be in the future"}
+	})
+	validator.Register("searchlang", func(value reflect.Value, _ string) *validator.FieldError {
+		if validator.In(value.String(), SearchLanguages...) {
+			return nil
+		}
+		return &validator.FieldError{Code: "unsupported_language", Message: "must be a supported language"}
+	})
+}
+
+// BookHighlight holds fragments of a book matching a full-text search, with the
+// matched terms wrapped in <mark> tags.
+type BookHighlight struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// BookCover holds the URLs of the renditions of a book's cover, from the
+// full-size image down to the smallest thumbnail.
+type BookCover struct {
+	Original string `json:"original"`
+	Large    string `json:"large"`
+	Medium   string `json:"medium"`
+	Small    string `json:"small"`
+}
+
+// coverScanner scans books.cover_url into a book. Renditions are stored next
+// to each other, named after their size.
+type coverScanner struct {
+	cover **BookCover
+}
+
+func (s coverScanner) Scan(src interface{}) error {
+	var url sql.NullString
+	if err := url.Scan(src); err != nil {
+		return err
+	}
+	*s.cover = nil
+	if url.Valid {
+		*s.cover = &BookCover{
+			Original: url.String + "/original.jpg",
+			Large:    url.String + "/large.jpg",
+			Medium:   url.String + "/medium.jpg",
+			Small:    url.String + "/small.jpg",
+		}
+	}
+	return nil
+}
+
+// Suggestion is a single autocomplete entry, either a book title or an author name.
+type Suggestion struct {
+	Value  string  `json:"value"`
+	Kind   string  `json:"kind"`
+	BookID int64   `json:"book_id,omitempty"`
+	Score  float64 `json:"-"`
+}
+
+// AuthorStats aggregates the catalog entries and reviews of a single author.
+type AuthorStats struct {
+	Books         int     `json:"books"`
+	Reviews       int     `json:"reviews"`
+	AverageRating float64 `json:"average_rating"`
+}
+
+// BookQuery holds the search criteria accepted by BookModel.GetAll.
+type BookQuery struct {
+	Search   string
+	Language string
+	Title    string
+	Author   string
+	Genres   []string
+	// Pending selects the books created by imports that are still waiting for
+	// an editor, instead of the catalog.
+	Pending bool
+	// Series, when set, keeps only the books of that series.
+	Series int64
+	// Subgenres makes each of Genres match its subgenres as well.
+	Subgenres bool
+	// Tags keeps only the books carrying every one of these tag keys.
+	Tags []string
 }
 
-func (b BookModel) Insert(book *Book) error {
+const bookColumns = `books.id, books.title, books.author, books.year, books.description, books.genres, books.language, books.version, books.updated_at, books.pending, ` + seriesColumn + `, books.cover_url`
+
+// fields returns the scan destinations matching bookColumns.
+func (book *Book) fields() []interface{} {
+	return []interface{}{
+		&book.ID,
+		&book.Title,
+		&book.Author,
+		&book.Year,
+		&book.Description,
+		pq.Array(&book.Genres),
+		&book.Language,
+		&book.Version,
+		&book.UpdatedAt,
+		&book.Pending,
+		seriesScanner{&book.Series},
+		coverScanner{&book.Cover},
+	}
+}
+
+// tsQuery turns free-form user input into a to_tsquery() expression where every
+// word must match, either fully or as a prefix.
+func tsQuery(search string) string {
+	terms := strings.FieldsFunc(search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i := range terms {
+		terms[i] = terms[i] + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
+
+// ValidateBookQuery checks the search of q, which must leave at least one term
+// to look for once punctuation is stripped; otherwise it would match every book.
+func ValidateBookQuery(v *validator.Validator, q BookQuery) {
+	if q.Search != "" && tsQuery(q.Search) == "" {
+		v.Fail("q", "no_search_terms", "must contain a letter or digit")
+	}
+}
+
+// likePrefix escapes the LIKE wildcards in s and turns it into a prefix pattern.
+func likePrefix(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(s) + "%"
+}
+
+// Insert adds a book on behalf of a user, or of nobody when userID is 0.
+func (b BookModel) Insert(book *Book, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = insertBook(ctx, tx, book, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertBook inserts a book within tx, on behalf of a user, and credits the
+// author named by its byline.
+func insertBook(ctx context.Context, tx *sql.Tx, book *Book, userID int64) error {
+	query := `
+	INSERT INTO books (title, author, year, description, genres, language, pending)
+	VALUES ($1, $2, $3, $4, canonical_genres($5), $6, $7)
+	RETURNING id, version, updated_at, genres`
+	args := []interface{}{book.Title, book.Author, book.Year, book.Description, pq.Array(book.Genres), book.Language, book.Pending}
+
+	if err := setEditor(ctx, tx, userID); err != nil {
+		return err
+	}
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.Version, &book.UpdatedAt, pq.Array(&book.Genres))
+	if err != nil {
+		return err
+	}
+	return linkBylines(ctx, tx, []int64{book.ID})
+}
+
+// How many rows each COPY statement of InsertMany sends.
+const copyBatchSize = 1000
+
+// InsertMany inserts books with COPY, in batches, all in one transaction, on
+// behalf of a user. The ids of the new books are not reported back.
+func (b BookModel) InsertMany(books []*Book, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = setEditor(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	// The books are copied into a staging table first, and moved from there
+	// with INSERT ... RETURNING, which reports exactly the ids they were given
+	// for crediting their authors.
 	query := `
-	INSERT INTO books (title, author, year, description, genres)
-	VALUES ($1, $2, $3, $4, $5)
-	RETURNING id`
-	args := []interface{}{book.Title, book.Author, book.Year, book.Description, pq.Array(book.Genres)}
-	return b.DB.QueryRow(query, args...).Scan(&book.ID)
+	CREATE TEMPORARY TABLE staged_books (
+		title text, author text, year integer, description text, genres text[], language text
+	) ON COMMIT DROP`
+	if _, err = tx.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	for start := 0; start < len(books); start += copyBatchSize {
+		end := start + copyBatchSize
+		if end > len(books) {
+			end = len(books)
+		}
+		stmt, err := tx.PrepareContext(ctx, pq.CopyIn("staged_books", "title", "author", "year", "description", "genres", "language"))
+		if err != nil {
+			return err
+		}
+		for _, book := range books[start:end] {
+			_, err = stmt.ExecContext(ctx, book.Title, book.Author, book.Year, book.Description, pq.Array(book.Genres), book.Language)
+			if err != nil {
+				stmt.Close()
+				return err
+			}
+		}
+		// An Exec without arguments flushes the batch.
+		if _, err = stmt.ExecContext(ctx); err != nil {
+			stmt.Close()
+			return err
+		}
+		if err = stmt.Close(); err != nil {
+			return err
+		}
+	}
+	// COPY stores genres as given; they are mapped to the taxonomy on the way
+	// from the staging table into books.
+	var ids []int64
+	query = `
+	WITH inserted AS (
+		INSERT INTO books (title, author, year, description, genres, language)
+		SELECT title, author, year, description, canonical_genres(genres), language FROM staged_books
+		RETURNING id
+	)
+	SELECT array(SELECT id FROM inserted)`
+	if err = tx.QueryRowContext(ctx, query).Scan(pq.Array(&ids)); err != nil {
+		return err
+	}
+	if err = linkBylines(ctx, tx, ids); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// BookKey identifies a book for duplicate detection: its title and author,
+// compared case-insensitively and without surrounding spaces.
+type BookKey struct {
+	Title  string
+	Author string
 }
 
-func (b BookModel) GetAll(title string, author string, genres []string, filters Filters) ([]*Book, error) {
+func (book *Book) Key() BookKey {
+	return BookKey{
+		Title:  strings.ToLower(strings.Trim(book.Title, " ")),
+		Author: strings.ToLower(strings.Trim(book.Author, " ")),
+	}
+}
+
+// FindDuplicates looks up existing books with the given keys, returning the
+// lowest id found for each key that exists.
+func (b BookModel) FindDuplicates(keys []BookKey) (map[BookKey]int64, error) {
+	titles := make([]string, len(keys))
+	authors := make([]string, len(keys))
+	for i, key := range keys {
+		titles[i], authors[i] = key.Title, key.Author
+	}
+	query := `
+	SELECT min(id), lower(trim(title)), lower(trim(author)) FROM books
+	WHERE (lower(trim(title)), lower(trim(author))) IN (SELECT * FROM unnest($1::text[], $2::text[]))
+	AND deleted_at IS NULL
+	GROUP BY 2, 3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := b.DB.QueryContext(ctx, query, pq.Array(titles), pq.Array(authors))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	existing := make(map[BookKey]int64)
+	for rows.Next() {
+		var id int64
+		var key BookKey
+		if err := rows.Scan(&id, &key.Title, &key.Author); err != nil {
+			return nil, err
+		}
+		existing[key] = id
+	}
+	return existing, rows.Err()
+}
+
+// FacetNames lists the aggregates BookModel.Facets can compute.
+var FacetNames = []string{"genres", "decade", "rating"}
+
+// FacetCount is the number of books sharing a facet value.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// BookFacets holds the per-value counts of the requested facets.
+type BookFacets struct {
+	Genres  []FacetCount `json:"genres,omitempty"`
+	Decades []FacetCount `json:"decade,omitempty"`
+	Ratings []FacetCount `json:"rating,omitempty"`
+}
+
+// filter returns the FROM and WHERE clauses selecting the books matching q, and
+// the values of the placeholders they use. The first six are always the same;
+// callers number their own placeholders after the last one. join is spliced in
+// right after books and may pull in related tables.
+func (q BookQuery) filter(join string) (string, []interface{}) {
+	language := q.Language
+	if language == "" {
+		language = "english"
+	}
+	search := tsQuery(q.Search)
+	// The raw input is matched by trigram similarity so that misspelt titles and
+	// authors still find something.
+	fuzzy := ""
+	if search != "" {
+		fuzzy = q.Search
+	}
+	args := []interface{}{search, language, q.Title, q.Author, pq.Array(q.Genres), fuzzy}
+	genres, args := q.genreFilter(args)
+
+	clause := `
+	FROM books` + join + `,
+		(SELECT to_tsquery($2::regconfig, $1) || to_tsquery('simple', $1) AS query) AS search
+	WHERE ($1 = '' OR books.search_vector @@ search.query OR $6 <% books.title OR $6 <% books.author)
+	AND books.deleted_at IS NULL
+	AND (LOWER(books.title) = LOWER($3) OR $3 = '')
+	AND (LOWER(books.author) = LOWER($4) OR $4 = '')
+	AND ` + genres + `
+	AND books.pending = ` + strconv.FormatBool(q.Pending)
+	if q.Series != 0 {
+		args = append(args, q.Series)
+		clause += fmt.Sprintf(`
+	AND books.series_id = $%d`, len(args))
+	}
+	for _, key := range q.Tags {
+		args = append(args, key)
+		clause += fmt.Sprintf(`
+	AND EXISTS (`+taggedWith+`$%d)`, len(args))
+	}
+	return clause, args
+}
+
+// genreFilter returns the condition on $5, the genres of a BookQuery, with args
+// extended by the values of any placeholders it adds. Genres are looked up in
+// the taxonomy, so any alias finds the books of a genre.
+func (q BookQuery) genreFilter(args []interface{}) (string, []interface{}) {
+	if !q.Subgenres {
+		return `(books.genres @> canonical_genres($5) OR $5 = '{}')`, args
+	}
+	// Each subtree is an uncorrelated subquery, which is looked up once for
+	// the whole query rather than once per book.
+	var conditions []string
+	for _, genre := range q.Genres {
+		args = append(args, genre)
+		conditions = append(conditions, fmt.Sprintf(`books.genres && (SELECT genre_subtree($%d))`, len(args)))
+	}
+	if len(conditions) == 0 {
+		return `$5 = '{}'`, args
+	}
+	return `($5 = '{}' OR (` + strings.Join(conditions, " AND ") + `))`, args
+}
+
+// rankExpr scores how well a book matches a search; it relies on the search
+// subquery and placeholders set up by BookQuery.filter.
+const rankExpr = `ts_rank(books.search_vector, search.query)
+			+ 0.1 * greatest(word_similarity($6, books.title), word_similarity($6, books.author))`
+
+func (b BookModel) GetAll(q BookQuery, filters Filters) ([]*Book, Metadata, error) {
+	clause, args := q.filter("")
+	sortExpr := "books." + filters.sortColumn()
+	if filters.sortColumn() == "rank" {
+		sortExpr = rankExpr
+	}
+	// Counting every match would defeat the point of keyset pagination.
+	countExpr := "count(*) OVER()"
+	if filters.Cursor != "" {
+		countExpr = "0"
+	}
+	limit := len(args) + 1
+	keyset, keysetArgs := filters.keyset(sortExpr, "books.id", limit+2)
 	query := fmt.Sprintf(`
-	SELECT id,  title, author,  year, description, genres
-	FROM books
-	WHERE (LOWER(title) = LOWER($1) OR $1 = '')
-	AND (LOWER(author) = LOWER($2) OR $2 = '')
-	AND (genres @> $3 OR $3 = '{}')
-	ORDER BY %s %s, id ASC
-	LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+	SELECT %s,
+		(%s)::text,
+		CASE WHEN $1 = '' THEN '' ELSE ts_headline(books.language::regconfig, books.title, search.query,
+			'StartSel=<mark>, StopSel=</mark>, HighlightAll=true') END,
+		CASE WHEN $1 = '' THEN '' ELSE ts_headline(books.language::regconfig, books.description, search.query,
+			'StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15') END,
+		%s
+	%s
+	AND %s
+	ORDER BY %s %s, books.id ASC
+	LIMIT $%d OFFSET $%d`, bookColumns, sortExpr, countExpr, clause, keyset, sortExpr, filters.sortDirection(), limit, limit+1)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	// Pass the title and genres as the placeholder parameter values.
-	rows, err := b.DB.QueryContext(ctx, query, title, author, pq.Array(genres), filters.Limit, filters.offset())
+	args = append(args, filters.limit(), filters.offset())
+	args = append(args, keysetArgs...)
+	rows, err := b.DB.QueryContext(ctx, query, args...)
 
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
+	totalRecords := 0
 	books := []*Book{}
+	var sortKeys []string
+	var ids []int64
 	for rows.Next() {
 		var book Book
-		err := rows.Scan(
-			&book.ID,
-			&book.Title,
-			&book.Author,
-			&book.Year,
-			&book.Description,
-			pq.Array(&book.Genres),
-		)
+		var sortKey string
+		var highlight BookHighlight
+		err := rows.Scan(append(book.fields(), &sortKey, &highlight.Title, &highlight.Description, &totalRecords)...)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
+		}
+		if args[0] != "" {
+			book.Highlight = &highlight
 		}
 		books = append(books, &book)
+		sortKeys = append(sortKeys, sortKey)
+		ids = append(ids, book.ID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	// Past the last page no row carries the total, so it is counted apart.
+	if len(books) == 0 && filters.offset() > 0 {
+		err = b.DB.QueryRowContext(ctx, "SELECT count(*) "+clause, args[:limit-1]...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
 	}
 
+	metadata := filters.metadata(totalRecords, sortKeys, ids)
+	if len(books) > filters.Limit {
+		books = books[:filters.Limit]
+	}
+	return books, metadata, nil
+}
+
+// Facets counts the books matching q per genre, per publication decade and per
+// average rating bucket. Only the facets named in names are computed, all of them
+// in a single round trip.
+func (b BookModel) Facets(q BookQuery, names []string) (*BookFacets, error) {
+	facetQueries := map[string]struct{ join, query string }{
+		"genres": {
+			join: `
+	CROSS JOIN LATERAL unnest(books.genres) AS genre`,
+			query: `
+	SELECT 'genres', genre, count(*) %s
+	GROUP BY genre`,
+		},
+		"decade": {
+			query: `
+	SELECT 'decade', ((books.year / 10) * 10)::text || 's', count(*) %s
+	GROUP BY books.year / 10`,
+		},
+		"rating": {
+			join: `
+	LEFT JOIN (SELECT book_id, avg(rating) AS rating FROM reviews WHERE deleted_at IS NULL GROUP BY book_id) AS ratings
+	ON ratings.book_id = books.id`,
+			query: `
+	SELECT 'rating', coalesce(floor(ratings.rating)::int::text, 'unrated'), count(*) %s
+	GROUP BY 2`,
+		},
+	}
+	var parts []string
+	var args []interface{}
+	for _, name := range names {
+		facet, ok := facetQueries[name]
+		if !ok {
+			continue
+		}
+		var clause string
+		clause, args = q.filter(facet.join)
+		parts = append(parts, fmt.Sprintf(facet.query, clause))
+	}
+	facets := &BookFacets{}
+	if len(parts) == 0 {
+		return facets, nil
+	}
+	query := strings.Join(parts, "\n\tUNION ALL") + "\n\tORDER BY 1, 3 DESC, 2"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := b.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var count FacetCount
+		err := rows.Scan(&name, &count.Value, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case "genres":
+			facets.Genres = append(facets.Genres, count)
+		case "decade":
+			facets.Decades = append(facets.Decades, count)
+		case "rating":
+			facets.Ratings = append(facets.Ratings, count)
+		}
+	}
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
+	return facets, nil
+}
 
-	return books, nil
+// Autocomplete returns the titles and authors best matching prefix. Exact prefix
+// matches come first, followed by trigram matches that tolerate typos.
+func (b BookModel) Autocomplete(prefix string, limit int) ([]*Suggestion, error) {
+	query := `
+	SELECT value, kind, book_id, score FROM (
+		SELECT title AS value, 'title' AS kind, id AS book_id,
+			word_similarity($1, title) + CASE WHEN title ILIKE $2 THEN 1 ELSE 0 END AS score
+		FROM books
+		WHERE (title ILIKE $2 OR $1 <% title) AND NOT pending AND deleted_at IS NULL
+		UNION ALL
+		SELECT author, 'author', 0,
+			max(word_similarity($1, author)) + CASE WHEN author ILIKE $2 THEN 1 ELSE 0 END
+		FROM books
+		WHERE (author ILIKE $2 OR $1 <% author) AND NOT pending AND deleted_at IS NULL
+		GROUP BY author
+	) AS suggestions
+	ORDER BY score DESC, value ASC
+	LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := b.DB.QueryContext(ctx, query, prefix, likePrefix(prefix), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	suggestions := []*Suggestion{}
+	for rows.Next() {
+		var suggestion Suggestion
+		err := rows.Scan(&suggestion.Value, &suggestion.Kind, &suggestion.BookID, &suggestion.Score)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, &suggestion)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
 }
 
 func (b BookModel) Get(id int64) (*Book, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `
-	SELECT * FROM books
-	WHERE id = $1`
+	query := fmt.Sprintf(`
+	SELECT %s FROM books
+	WHERE id = $1 AND deleted_at IS NULL`, bookColumns)
 	var book Book
-	err := b.DB.QueryRow(query, id).Scan(
-		&book.ID,
-		&book.Title,
-		&book.Author,
-		&book.Year,
-		&book.Description,
-		pq.Array(&book.Genres),
-	)
+	err := b.DB.QueryRow(query, id).Scan(book.fields()...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -103,12 +617,97 @@ func (b BookModel) Get(id int64) (*Book, error) {
 	return &book, nil
 }
 
-func (b BookModel) Update(book *Book) (*Book, error) {
+// GetMany fetches the books with the given ids in a single query, keyed by id.
+// Ids that don't exist are simply missing from the result.
+func (b BookModel) GetMany(ids []int64) (map[int64]*Book, error) {
+	query := fmt.Sprintf(`
+	SELECT %s FROM books
+	WHERE id = ANY($1) AND deleted_at IS NULL`, bookColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := b.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	books := make(map[int64]*Book)
+	for rows.Next() {
+		var book Book
+		if err := rows.Scan(book.fields()...); err != nil {
+			return nil, err
+		}
+		books[book.ID] = &book
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+// AuthorStats computes the number of books, reviews and the average rating of
+// each of the given authors in a single query, keyed by author.
+func (b BookModel) AuthorStats(authors []string) (map[string]*AuthorStats, error) {
 	query := `
+	SELECT books.author, count(DISTINCT books.id), count(reviews.id), coalesce(avg(reviews.rating), 0)
+	FROM books
+	LEFT JOIN reviews ON reviews.book_id = books.id AND reviews.deleted_at IS NULL
+	WHERE books.author = ANY($1) AND books.deleted_at IS NULL
+	GROUP BY books.author`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := b.DB.QueryContext(ctx, query, pq.Array(authors))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := make(map[string]*AuthorStats)
+	for rows.Next() {
+		var author string
+		var s AuthorStats
+		if err := rows.Scan(&author, &s.Books, &s.Reviews, &s.AverageRating); err != nil {
+			return nil, err
+		}
+		stats[author] = &s
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Update saves book on behalf of a user if it is still at the version that was
+// read. Saving a pending book through an editor takes it into the catalog, and
+// changing its byline credits the author it now names.
+func (b BookModel) Update(book *Book, userID int64) (*Book, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	newbook, err := updateBook(ctx, tx, book, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return newbook, nil
+}
+
+// updateBook saves the writable fields of book within tx, on behalf of a user,
+// and returns the book as stored.
+func updateBook(ctx context.Context, tx *sql.Tx, book *Book, userID int64) (*Book, error) {
+	query := fmt.Sprintf(`
+WITH old AS (SELECT author FROM books WHERE id = $7)
 UPDATE books
-SET title = $1, author=$2, year = $3, description = $4, genres = $5
-WHERE id = $6
-RETURNING *`
+SET title = $1, author=$2, year = $3, description = $4, genres = canonical_genres($5), language = $6,
+	pending = false, version = version + 1, updated_at = NOW()
+WHERE id = $7 AND version = $8 AND deleted_at IS NULL
+RETURNING %s, (SELECT author FROM old)`, bookColumns)
 	// Create an args slice containing the values for the placeholder parameters.
 	args := []interface{}{
 		book.Title,
@@ -116,36 +715,146 @@ RETURNING *`
 		book.Year,
 		book.Description,
 		pq.Array(book.Genres),
+		book.Language,
 		book.ID,
+		book.Version,
 	}
+	if err := setEditor(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
 	var newbook Book
-	err := b.DB.QueryRow(query, args...).Scan(
-		&newbook.ID,
-		&newbook.Title,
-		&newbook.Author,
-		&newbook.Year,
-		&newbook.Description,
-		pq.Array(&newbook.Genres),
-	)
+	var oldAuthor string
+	err := tx.QueryRowContext(ctx, query, args...).Scan(append(newbook.fields(), &oldAuthor)...)
 	if err != nil {
 		switch {
+		// The book was changed or deleted since it was read.
 		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrRecordNotFound
+			return nil, ErrEditConflict
 		default:
 			return nil, err
 		}
 	}
+	if newbook.Author != oldAuthor {
+		if err = relinkByline(ctx, tx, newbook.ID); err != nil {
+			return nil, err
+		}
+	}
 	return &newbook, nil
 }
 
-func (b BookModel) Delete(id int64) error {
+// SetSeries places a book in a series at position, or takes it out of its
+// series when seriesID is 0, on behalf of a user. The book moves to a new
+// version.
+func (b BookModel) SetSeries(bookID, seriesID int64, position float64, userID int64) (*Book, error) {
+	query := fmt.Sprintf(`
+	UPDATE books
+	SET series_id = NULLIF($2, 0), series_position = CASE WHEN $2 = 0 THEN NULL ELSE $3::numeric END,
+		version = version + 1, updated_at = NOW()
+	WHERE id = $1 AND deleted_at IS NULL
+	RETURNING %s`, bookColumns)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if err = setEditor(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	var book Book
+	err = tx.QueryRowContext(ctx, query, bookID, seriesID, position).Scan(book.fields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		case strings.Contains(err.Error(), "books_series_id_fkey"):
+			return nil, ErrUnknownSeries
+		case err.Error() == `pq: duplicate key value violates unique constraint "books_series_position_idx"`:
+			return nil, ErrDuplicatePosition
+		default:
+			return nil, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+// SetCover records the renditions stored under key, served from url, as the
+// cover of a book, or removes its cover when key is empty, on behalf of a
+// user. It returns the book and the key of the cover it replaced, if any, so
+// that it can be deleted.
+func (b BookModel) SetCover(bookID int64, key, url string, userID int64) (*Book, string, error) {
+	query := fmt.Sprintf(`
+	WITH old AS (SELECT cover_key FROM books WHERE id = $1)
+	UPDATE books
+	SET cover_key = NULLIF($2, ''), cover_url = NULLIF($3, ''), version = version + 1, updated_at = NOW()
+	WHERE id = $1 AND deleted_at IS NULL
+	RETURNING %s, coalesce((SELECT cover_key FROM old), '')`, bookColumns)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+	if err = setEditor(ctx, tx, userID); err != nil {
+		return nil, "", err
+	}
+	var book Book
+	var oldKey string
+	err = tx.QueryRowContext(ctx, query, bookID, key, url).Scan(append(book.fields(), &oldKey)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, "", ErrRecordNotFound
+		default:
+			return nil, "", err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, "", err
+	}
+	return &book, oldKey, nil
+}
+
+// Export calls fn with every book in id order, streaming them from the
+// database. It stops at the first error fn returns.
+func (b BookModel) Export(ctx context.Context, fn func(*Book) error) error {
+	query := fmt.Sprintf(`SELECT %s FROM books WHERE deleted_at IS NULL ORDER BY id`, bookColumns)
+	return streamCursor(ctx, b.DB, query, func(rows *sql.Rows) error {
+		var book Book
+		if err := rows.Scan(book.fields()...); err != nil {
+			return err
+		}
+		return fn(&book)
+	})
+}
+
+// Delete moves a book to the trash on behalf of a user, along with its
+// reviews. Its revisions are kept.
+func (b BookModel) Delete(id int64, userID int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 	query := `
-		DELETE FROM books
-		WHERE id = $1`
-	result, err := b.DB.Exec(query, id)
+		UPDATE books
+		SET deleted_at = NOW(), version = version + 1, updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = setEditor(ctx, tx, userID); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -156,20 +865,230 @@ func (b BookModel) Delete(id int64) error {
 	if rowsAffected == 0 {
 		return ErrRecordNotFound
 	}
-	return nil
+	// The reviews go to the trash with the book, and come back with it.
+	query = `
+		UPDATE reviews
+		SET deleted_at = NOW(), trashed_with = 'book', version = version + 1, updated_at = NOW()
+		WHERE book_id = $1 AND deleted_at IS NULL`
+	if _, err = tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetTrashed returns a page of the books in the trash.
+func (b BookModel) GetTrashed(filters Filters) ([]*Book, Metadata, error) {
+	sortExpr := "books." + filters.sortColumn()
+	countExpr := "count(*) OVER()"
+	if filters.Cursor != "" {
+		countExpr = "0"
+	}
+	keyset, keysetArgs := filters.keyset(sortExpr, "books.id", 3)
+	query := fmt.Sprintf(`
+	SELECT %s, (%s)::text, %s, books.deleted_at
+	FROM books
+	WHERE books.deleted_at IS NOT NULL
+	AND %s
+	ORDER BY %s %s, books.id ASC
+	LIMIT $1 OFFSET $2`, countExpr, sortExpr, bookColumns, keyset, sortExpr, filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	args := append([]interface{}{filters.limit(), filters.offset()}, keysetArgs...)
+	rows, err := b.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	books := []*Book{}
+	var sortKeys []string
+	var ids []int64
+	for rows.Next() {
+		var book Book
+		var sortKey string
+		dest := append([]interface{}{&totalRecords, &sortKey}, book.fields()...)
+		if err := rows.Scan(append(dest, &book.DeletedAt)...); err != nil {
+			return nil, Metadata{}, err
+		}
+		books = append(books, &book)
+		sortKeys = append(sortKeys, sortKey)
+		ids = append(ids, book.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := filters.metadata(totalRecords, sortKeys, ids)
+	if len(books) > filters.Limit {
+		books = books[:filters.Limit]
+	}
+	return books, metadata, nil
+}
+
+// Restore takes a book out of the trash on behalf of a user, along with the
+// reviews that went to the trash with it, and moves it to a new version. Those
+// whose author is in the trash are left to come back with the author. A
+// book that lost its place in its series to another book in the meantime is
+// reported as ErrDuplicatePosition.
+func (b BookModel) Restore(id int64, userID int64) (*Book, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := fmt.Sprintf(`
+	UPDATE books
+	SET deleted_at = NULL, version = version + 1, updated_at = NOW()
+	WHERE id = $1 AND deleted_at IS NOT NULL
+	RETURNING %s`, bookColumns)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if err = setEditor(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	var book Book
+	err = tx.QueryRowContext(ctx, query, id).Scan(book.fields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "books_series_position_idx"`:
+			return nil, ErrDuplicatePosition
+		default:
+			return nil, err
+		}
+	}
+	// Reviews whose author is still in the trash stay there, and come back
+	// with the author instead.
+	query = `
+	UPDATE reviews
+	SET deleted_at = CASE WHEN users.deleted_at IS NULL THEN NULL ELSE reviews.deleted_at END,
+		trashed_with = CASE WHEN users.deleted_at IS NULL THEN NULL ELSE 'user' END,
+		version = reviews.version + 1, updated_at = NOW()
+	FROM users
+	WHERE users.id = reviews.user_id AND reviews.book_id = $1 AND reviews.trashed_with = 'book'`
+	if _, err = tx.ExecContext(ctx, query, id); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+// Purge permanently deletes the books that went to the trash before a time,
+// with their reviews, editions and everything else of theirs but their
+// revisions, and returns how many there were and the keys their covers were
+// stored under, for the caller to delete the files.
+func (b BookModel) Purge(before time.Time) (int64, []string, error) {
+	query := `
+	WITH purged AS (DELETE FROM books WHERE deleted_at < $1 RETURNING cover_key)
+	SELECT count(*), coalesce(array_agg(cover_key) FILTER (WHERE cover_key <> ''), '{}')
+	FROM purged`
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var purged int64
+	var coverKeys []string
+	err := b.DB.QueryRowContext(ctx, query, before).Scan(&purged, pq.Array(&coverKeys))
+	if err != nil {
+		return 0, nil, err
+	}
+	return purged, coverKeys, nil
+}
+
+// Merge folds the book from into the book into on behalf of a user, as one
+// transaction. Reviews, editions, credits, tags with their votes and
+// suggestions move to into, which keeps its own fields; where a user reviewed
+// both books, the review of into is kept. from is then deleted, leaving a
+// redirect to into behind, and into moves to a new version.
+func (b BookModel) Merge(into, from int64, userID int64) (*Book, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := b.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if err = setEditor(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM books WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, from).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrUnknownBook
+		default:
+			return nil, err
+		}
+	}
+	queries := []string{
+		`DELETE FROM reviews WHERE book_id = $2 AND user_id IN (SELECT user_id FROM reviews WHERE book_id = $1 AND deleted_at IS NULL)`,
+		`UPDATE reviews SET book_id = $1 WHERE book_id = $2`,
+		`UPDATE editions SET book_id = $1, version = version + 1, updated_at = NOW() WHERE book_id = $2`,
+		// Credits of from come after those of into, so the byline stays.
+		`INSERT INTO book_authors (book_id, author_id, role, position)
+		SELECT $1, author_id, role, position + (SELECT coalesce(max(position), -1) + 1 FROM book_authors WHERE book_id = $1)
+		FROM book_authors WHERE book_id = $2
+		ON CONFLICT DO NOTHING`,
+		// Where both books carry a tag, into's row, removed or not, wins.
+		`INSERT INTO book_tags (book_id, tag_id, removed_at)
+		SELECT $1, tag_id, removed_at FROM book_tags WHERE book_id = $2
+		ON CONFLICT (book_id, tag_id) DO UPDATE SET updated_at = NOW()`,
+		`INSERT INTO tag_votes (book_id, tag_id, user_id, created_at)
+		SELECT $1, tag_id, user_id, created_at FROM tag_votes WHERE book_id = $2
+		ON CONFLICT DO NOTHING`,
+		`UPDATE suggestions SET book_id = $1 WHERE book_id = $2`,
+		`UPDATE book_redirects SET book_id = $1 WHERE book_id = $2`,
+		`INSERT INTO book_redirects (id, book_id) VALUES ($2, $1)`,
+		`DELETE FROM books WHERE id = $2`,
+	}
+	for _, query := range queries {
+		if _, err = tx.ExecContext(ctx, query, into, from); err != nil {
+			return nil, err
+		}
+	}
+	query := fmt.Sprintf(`
+	UPDATE books SET version = version + 1, updated_at = NOW()
+	WHERE id = $1 AND deleted_at IS NULL
+	RETURNING %s`, bookColumns)
+	var book Book
+	err = tx.QueryRowContext(ctx, query, into).Scan(book.fields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &book, nil
+}
+
+// Redirect returns the id of the book a book merged into another now leads to.
+func (b BookModel) Redirect(id int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var bookID int64
+	err := b.DB.QueryRowContext(ctx, `SELECT book_id FROM book_redirects WHERE id = $1`, id).Scan(&bookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+	return bookID, nil
 }
 
 func ValidateBook(v *validator.Validator, book *Book) {
-	v.Check(book.Title != "", "title", "must be provided")
-	v.Check(len(book.Title) <= 200, "title", "must not be more than 200 bytes long")
-	v.Check(book.Author != "", "author", "must be provided")
-	v.Check(len(book.Author) <= 200, "author", "must not be more than 200 bytes long")
-	v.Check(book.Description != "", "description", "must be provided")
-	v.Check(len(book.Description) <= 500, "description", "must not be more than 500 bytes long")
-	v.Check(book.Year != 0, "year", "must be provided")
-	v.Check(book.Year <= int32(time.Now().Year()), "year", "must not be in the future")
-	v.Check(book.Genres != nil, "genres", "must be provided")
-	v.Check(len(book.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(book.Genres) <= 5, "genres", "must not contain more than 5 genres")
-	v.Check(validator.Unique(book.Genres), "genres", "must not contain duplicate values")
+	v.Struct(book)
 }
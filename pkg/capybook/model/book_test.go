@@ -0,0 +1,426 @@
+package model
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTsQuery(t *testing.T) {
+	tests := []struct {
+		search string
+		want   string
+	}{
+		{"", ""},
+		{"war and peace", "war:* & and:* & peace:*"},
+		{"  Tolstoy's war!", "Tolstoy:* & s:* & war:*"},
+		{"Война и мир", "Война:* & и:* & мир:*"},
+		{"?!", ""},
+	}
+	for _, tt := range tests {
+		if got := tsQuery(tt.search); got != tt.want {
+			t.Errorf("tsQuery(%q) = %q, want %q", tt.search, got, tt.want)
+		}
+	}
+}
+
+// bookRowColumns are the columns of a book as the book model reads them.
+var bookRowColumns = []string{"id", "title", "author", "year", "description", "genres", "language", "version", "updated_at", "pending", "series", "cover_url"}
+
+// bookRow is the row of a stubbed book.
+func bookRow(id int64, title, author string) []driver.Value {
+	return []driver.Value{id, title, author, int64(1869), "A novel", "{novel}", "english", int64(1), time.Now(), false, nil, nil}
+}
+
+// searchRow is a book as GetAll reads it: the book's columns, then its sort
+// key, the highlighted title and description and the number of matches.
+func searchRow(title string) []driver.Value {
+	return append(bookRow(1, title, "Leo Tolstoy"), "0.5", "<mark>War</mark> and Peace", "A novel", int64(1))
+}
+
+func TestGetAllHighlightsSearches(t *testing.T) {
+	conn, db := newStubDB(t)
+	var args []driver.NamedValue
+	db.On("FROM books", func(a []driver.NamedValue) stubResult {
+		args = a
+		return stubResult{
+			Columns: append(bookRowColumns, "rank", "title", "description", "count"),
+			Rows:    [][]driver.Value{searchRow("War and Peace")},
+		}
+	})
+	filters := Filters{Page: 1, Limit: 20, Sort: "-rank", SortSafelist: []string{"-rank"}}
+	books, _, err := BookModel{DB: conn}.GetAll(BookQuery{Search: "war", Language: "russian"}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 || books[0].Highlight == nil || books[0].Highlight.Title != "<mark>War</mark> and Peace" {
+		t.Fatalf("got %+v, want one highlighted book", books)
+	}
+	if args[0].Value != "war:*" || args[1].Value != "russian" {
+		t.Errorf("searched for %v in %v, want war:* in russian", args[0].Value, args[1].Value)
+	}
+
+	books, _, err = BookModel{DB: conn}.GetAll(BookQuery{}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if books[0].Highlight != nil {
+		t.Errorf("a listing without a search has highlights: %+v", books[0].Highlight)
+	}
+	if args[1].Value != "english" {
+		t.Errorf("searched in %v by default, want english", args[1].Value)
+	}
+}
+
+func TestGetAllOrdersSearchesByRank(t *testing.T) {
+	conn, db := newStubDB(t)
+	filters := Filters{Page: 1, Limit: 20, Sort: "-rank", SortSafelist: []string{"-rank"}}
+	if _, _, err := (BookModel{DB: conn}).GetAll(BookQuery{Search: "war"}, filters); err != nil {
+		t.Fatal(err)
+	}
+	statements := db.Statements()
+	if len(statements) != 1 || !strings.Contains(statements[0], "ORDER BY ts_rank(") {
+		t.Errorf("got %v, want the books ordered by rank", statements)
+	}
+}
+
+// Books waiting for an editor are listed apart from the catalog.
+func TestGetAllSeparatesPendingBooks(t *testing.T) {
+	for _, pending := range []bool{false, true} {
+		conn, db := newStubDB(t)
+		filters := Filters{Page: 1, Limit: 20, Sort: "id", SortSafelist: []string{"id"}}
+		if _, _, err := (BookModel{DB: conn}).GetAll(BookQuery{Pending: pending}, filters); err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("books.pending = %t", pending)
+		if statements := db.Statements(); len(statements) != 1 || !strings.Contains(statements[0], want) {
+			t.Errorf("pending %t: got %v, want %s", pending, statements, want)
+		}
+	}
+}
+
+// Only the search is typo-tolerant; the title and author filters match exactly.
+func TestGetAllFiltersTitleAndAuthorExactly(t *testing.T) {
+	conn, db := newStubDB(t)
+	filters := Filters{Page: 1, Limit: 20, Sort: "id", SortSafelist: []string{"id"}}
+	if _, _, err := (BookModel{DB: conn}).GetAll(BookQuery{Title: "Dune", Author: "Frank Herbert"}, filters); err != nil {
+		t.Fatal(err)
+	}
+	statements := db.Statements()
+	if len(statements) != 1 {
+		t.Fatalf("got %v, want one query", statements)
+	}
+	if strings.Contains(statements[0], "$3 <%") || strings.Contains(statements[0], "$4 <%") {
+		t.Errorf("the title or author is matched by similarity: %s", statements[0])
+	}
+	for _, want := range []string{"LOWER(books.title) = LOWER($3) OR $3 = ''", "LOWER(books.author) = LOWER($4) OR $4 = ''"} {
+		if !strings.Contains(statements[0], want) {
+			t.Errorf("query lacks %s: %s", want, statements[0])
+		}
+	}
+}
+
+func TestLikePrefix(t *testing.T) {
+	tests := []struct{ prefix, want string }{
+		{"Dune", "Dune%"},
+		{"100%", `100\%%`},
+		{"a_b", `a\_b%`},
+		{`C:\`, `C:\\%`},
+	}
+	for _, tt := range tests {
+		if got := likePrefix(tt.prefix); got != tt.want {
+			t.Errorf("likePrefix(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestAutocomplete(t *testing.T) {
+	conn, db := newStubDB(t)
+	var args []driver.NamedValue
+	db.On("AS suggestions", func(a []driver.NamedValue) stubResult {
+		args = a
+		return stubResult{
+			Columns: []string{"value", "kind", "book_id", "score"},
+			Rows: [][]driver.Value{
+				{"Dune", "title", int64(1), 1.8},
+				{"Daphne du Maurier", "author", int64(0), 0.4},
+			},
+		}
+	})
+	suggestions, err := BookModel{DB: conn}.Autocomplete("du_", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) != 2 || suggestions[0].BookID != 1 || suggestions[1].Kind != "author" {
+		t.Errorf("got %+v, want a title and an author", suggestions)
+	}
+	if args[0].Value != "du_" || args[1].Value != `du\_%` || args[2].Value != int64(5) {
+		t.Errorf("got arguments %v, want the prefix, its LIKE pattern and the limit", args)
+	}
+}
+
+func TestFacets(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("UNION ALL", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"name", "value", "count"},
+			Rows: [][]driver.Value{
+				{"decade", "1960s", int64(2)},
+				{"genres", "novel", int64(3)},
+				{"genres", "poetry", int64(1)},
+			},
+		}
+	})
+	facets, err := BookModel{DB: conn}.Facets(BookQuery{}, []string{"genres", "decade"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(facets.Genres) != 2 || facets.Genres[0] != (FacetCount{"novel", 3}) || len(facets.Decades) != 1 || facets.Ratings != nil {
+		t.Errorf("got %+v, want two genres and a decade", facets)
+	}
+	statements := db.Statements()
+	if len(statements) != 1 || strings.Count(statements[0], "SELECT '") != 2 {
+		t.Errorf("got %v, want both facets counted in one query", statements)
+	}
+}
+
+func TestFacetsWithoutNamesSkipTheQuery(t *testing.T) {
+	conn, db := newStubDB(t)
+	facets, err := BookModel{DB: conn}.Facets(BookQuery{}, []string{"publisher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if facets.Genres != nil || facets.Decades != nil || facets.Ratings != nil || len(db.Statements()) != 0 {
+		t.Errorf("got %+v after %v, want no facets and no query", facets, db.Statements())
+	}
+}
+
+// InsertMany sends one COPY per batch, flushing each with an empty Exec, all
+// in one transaction.
+func TestInsertManyCopiesInBatches(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("INSERT INTO books", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"array"}, Rows: [][]driver.Value{{"{}"}}}
+	})
+	books := make([]*Book, copyBatchSize+1)
+	for i := range books {
+		books[i] = &Book{Title: "War and Peace", Author: "Leo Tolstoy", Year: 1869, Genres: []string{"novel"}, Language: "russian"}
+	}
+	if err := (BookModel{DB: conn}).InsertMany(books, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	var copies, rows, flushes int
+	statements := db.Statements()
+	for _, statement := range statements {
+		switch {
+		case strings.HasPrefix(statement, "COPY"):
+			copies++
+		case statement == "copy []":
+			flushes++
+		case strings.HasPrefix(statement, "copy "):
+			rows++
+		}
+	}
+	if copies != 2 || flushes != 2 || rows != len(books) {
+		t.Errorf("sent %d COPY statements with %d rows and %d flushes", copies, rows, flushes)
+	}
+	if statements[0] != "BEGIN" || statements[len(statements)-1] != "COMMIT" {
+		t.Errorf("the copies ran outside a transaction: %v ... %v", statements[0], statements[len(statements)-1])
+	}
+}
+
+func TestBookKey(t *testing.T) {
+	a := (&Book{Title: " War and Peace ", Author: "LEO TOLSTOY"}).Key()
+	b := (&Book{Title: "war and peace", Author: "Leo Tolstoy"}).Key()
+	if a != b {
+		t.Errorf("%v and %v differ", a, b)
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	conn, db := newStubDB(t)
+	var args []driver.NamedValue
+	db.On("unnest($1::text[], $2::text[])", func(a []driver.NamedValue) stubResult {
+		args = a
+		return stubResult{
+			Columns: []string{"min", "lower", "lower"},
+			Rows:    [][]driver.Value{{int64(3), "war and peace", "leo tolstoy"}},
+		}
+	})
+	keys := []BookKey{{"war and peace", "leo tolstoy"}, {"anna karenina", "leo tolstoy"}}
+	existing, err := BookModel{DB: conn}.FindDuplicates(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(existing) != 1 || existing[keys[0]] != 3 {
+		t.Errorf("got %v", existing)
+	}
+	if len(args) != 2 || args[0].Value != `{"war and peace","anna karenina"}` {
+		t.Errorf("sent %v", args)
+	}
+}
+
+// The authors of imported books are credited by the ids the books were given,
+// which must be exactly those of the books inserted, whatever else is being
+// inserted at the same time.
+func TestInsertManyCreditsTheBooksItInserted(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("INSERT INTO books", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"array"}, Rows: [][]driver.Value{{"{11,12}"}}}
+	})
+	var linked interface{}
+	db.On("WITH unlinked", func(args []driver.NamedValue) stubResult {
+		linked = args[0].Value
+		return stubResult{}
+	})
+	books := []*Book{
+		{Title: "Dune", Author: "Frank Herbert", Year: 1965, Genres: []string{"science fiction"}, Language: "english"},
+		{Title: "Emma", Author: "Jane Austen", Year: 1815, Genres: []string{"romance"}, Language: "english"},
+	}
+	if err := (BookModel{DB: conn}).InsertMany(books, 7); err != nil {
+		t.Fatal(err)
+	}
+	if linked != "{11,12}" {
+		t.Errorf("credited the authors of books %v, want {11,12}", linked)
+	}
+	var copied int
+	for _, statement := range db.Statements() {
+		switch {
+		case strings.Contains(statement, "max(id)"):
+			t.Errorf("InsertMany guesses the new ids: %s", statement)
+		case strings.HasPrefix(statement, "COPY") && !strings.Contains(statement, "staged_books"):
+			t.Errorf("InsertMany copies straight into the catalog: %s", statement)
+		case strings.HasPrefix(statement, "copy [") && !strings.HasSuffix(statement, "[]"):
+			copied++
+		}
+	}
+	if copied != len(books) {
+		t.Errorf("copied %d books, want %d", copied, len(books))
+	}
+}
+
+// Deleting a book only moves it to the trash, with its reviews.
+func TestDeleteBookMovesItToTheTrash(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("UPDATE books", func([]driver.NamedValue) stubResult {
+		return stubResult{Rows: [][]driver.Value{{}}}
+	})
+	if err := (BookModel{DB: conn}).Delete(1, 7); err != nil {
+		t.Fatal(err)
+	}
+	var reviews bool
+	for _, statement := range db.Statements() {
+		if strings.Contains(statement, "DELETE") {
+			t.Errorf("deleting the book removed rows: %q", statement)
+		}
+		reviews = reviews || strings.Contains(statement, "UPDATE reviews")
+	}
+	if !reviews {
+		t.Errorf("statements = %q, want the reviews trashed too", db.Statements())
+	}
+
+	conn, db = newStubDB(t)
+	if err := (BookModel{DB: conn}).Delete(1, 7); err != ErrRecordNotFound {
+		t.Errorf("a book already in the trash: got %v, want ErrRecordNotFound", err)
+	}
+	if got := db.Statements(); got[len(got)-1] != "ROLLBACK" {
+		t.Errorf("statements = %q, want a rollback", got)
+	}
+}
+
+func TestDeleteBookRecordsWhyReviewsWereTrashed(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("UPDATE books", func([]driver.NamedValue) stubResult {
+		return stubResult{Rows: [][]driver.Value{{}}}
+	})
+	if err := (BookModel{DB: conn}).Delete(1, 7); err != nil {
+		t.Fatal(err)
+	}
+	var trashed bool
+	for _, statement := range db.Statements() {
+		if strings.Contains(statement, "UPDATE reviews") && strings.Contains(statement, "trashed_with = 'book'") {
+			trashed = true
+		}
+	}
+	if !trashed {
+		t.Errorf("the reviews of the book were not trashed with it: %v", db.Statements())
+	}
+}
+
+// maxPlaceholder returns the highest $n a query uses.
+func maxPlaceholder(query string) int {
+	max := 0
+	for _, match := range regexp.MustCompile(`\$(\d+)`).FindAllStringSubmatch(query, -1) {
+		if n, _ := strconv.Atoi(match[1]); n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+func TestGetAllPassesFiltersAsArguments(t *testing.T) {
+	conn, db := newStubDB(t)
+	var args []driver.NamedValue
+	db.On("FROM books", func(a []driver.NamedValue) stubResult {
+		args = a
+		return stubResult{}
+	})
+	q := BookQuery{
+		Genres:    []string{"fantasy", "Sci-Fi"},
+		Subgenres: true,
+		Series:    3,
+		Tags:      []string{"found-family", "it's-complicated"},
+	}
+	filters := Filters{Page: 1, Limit: 20, Sort: "id", SortSafelist: []string{"id"},
+		Cursor: encodeCursor(cursor{Sort: "id", Value: "10", ID: 10})}
+	if _, _, err := (BookModel{DB: conn}).GetAll(q, filters); err != nil {
+		t.Fatal(err)
+	}
+	query := db.Statements()[0]
+	for _, literal := range []string{"found-family", "complicated", "series_id = 3", "fantasy", "wanted.genre"} {
+		if strings.Contains(query, literal) {
+			t.Errorf("query contains %q:\n%s", literal, query)
+		}
+	}
+	if got := maxPlaceholder(query); got != len(args) {
+		t.Errorf("query uses placeholders up to $%d but has %d arguments:\n%s", got, len(args), query)
+	}
+	values := map[interface{}]bool{}
+	for _, arg := range args {
+		values[arg.Value] = true
+	}
+	for _, want := range []interface{}{"fantasy", "Sci-Fi", int64(3), "found-family", "it's-complicated"} {
+		if !values[want] {
+			t.Errorf("%v is not passed as an argument", want)
+		}
+	}
+	if strings.Count(query, "(SELECT genre_subtree($") != 2 {
+		t.Errorf("genre subtrees are not looked up once per genre:\n%s", query)
+	}
+}
+
+func TestGetAllCountsPastTheLastPage(t *testing.T) {
+	conn, db := newStubDB(t)
+	var counted []driver.NamedValue
+	db.On("SELECT count(*)", func(args []driver.NamedValue) stubResult {
+		counted = args
+		return stubResult{Columns: []string{"count"}, Rows: [][]driver.Value{{int64(41)}}}
+	})
+	filters := Filters{Page: 5, Limit: 20, Sort: "id", SortSafelist: []string{"id"}}
+	books, metadata, err := BookModel{DB: conn}.GetAll(BookQuery{Search: "war"}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 0 || metadata.TotalRecords != 41 || metadata.LastPage != 3 {
+		t.Errorf("got %d books and %+v, want none of 41 past the last of 3 pages", len(books), metadata)
+	}
+	count := db.Statements()[1]
+	if got := maxPlaceholder(count); len(counted) == 0 || got != len(counted) {
+		t.Errorf("the count uses placeholders up to $%d but has %d arguments:\n%s", got, len(counted), count)
+	}
+}
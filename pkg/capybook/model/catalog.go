@@ -0,0 +1,23 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type CatalogModel struct {
+	DB *sql.DB
+}
+
+// Version returns a number that moves on every change to books, reviews,
+// editions, authors, series, genres or tags, for revalidating cached responses
+// that can be affected by any of them. Each table keeps a version of its own,
+// and only ever moves it forward, so their sum does too.
+func (m CatalogModel) Version() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var version int64
+	err := m.DB.QueryRowContext(ctx, `SELECT coalesce(sum(version), 0) FROM catalog_versions`).Scan(&version)
+	return version, err
+}
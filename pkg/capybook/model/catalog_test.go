@@ -0,0 +1,26 @@
+package model
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+// The catalog version stands for every table, so it is read in one statement
+// across the versions they keep.
+func TestCatalogVersionAddsUpTheTables(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("FROM catalog_versions", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"sum"}, Rows: [][]driver.Value{{int64(42)}}}
+	})
+	version, err := CatalogModel{DB: conn}.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 42 {
+		t.Errorf("version = %d, want 42", version)
+	}
+	if statements := db.Statements(); len(statements) != 1 || !strings.Contains(statements[0], "sum(version)") {
+		t.Errorf("statements = %q, want the sum of the versions", statements)
+	}
+}
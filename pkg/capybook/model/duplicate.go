@@ -0,0 +1,198 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrUnknownBook = errors.New("unknown book")
+)
+
+type DuplicateModel struct {
+	DB *sql.DB
+}
+
+// Duplicate is a pair of books that look like the same work. Book is the older
+// one; the handlers fill in both books from their ids.
+type Duplicate struct {
+	ID               int64     `json:"id"`
+	Book             *Book     `json:"book"`
+	Duplicate        *Book     `json:"duplicate"`
+	Score            float64   `json:"score"`
+	TitleSimilarity  float64   `json:"title_similarity"`
+	AuthorSimilarity float64   `json:"author_similarity"`
+	SameISBN         bool      `json:"same_isbn"`
+	Dismissed        bool      `json:"dismissed"`
+	DetectedAt       time.Time `json:"detected_at"`
+	BookID           int64     `json:"-"`
+	DuplicateID      int64     `json:"-"`
+}
+
+// duplicateSimilarities compares the books a and b by their titles and authors,
+// normalized like author names so that case, punctuation and spacing don't
+// count.
+const duplicateSimilarities = `
+	round(similarity(author_key(a.title), author_key(b.title))::numeric, 2) AS title_similarity,
+	round(similarity(author_key(a.author), author_key(b.author))::numeric, 2) AS author_similarity`
+
+const duplicateColumns = `id, book_id, duplicate_id, score, title_similarity, author_similarity, same_isbn, dismissed, detected_at`
+
+func (d *Duplicate) fields() []interface{} {
+	return []interface{}{
+		&d.ID,
+		&d.BookID,
+		&d.DuplicateID,
+		&d.Score,
+		&d.TitleSimilarity,
+		&d.AuthorSimilarity,
+		&d.SameISBN,
+		&d.Dismissed,
+		&d.DetectedAt,
+	}
+}
+
+// Detect flags every pair of books whose score reaches threshold, refreshing
+// the scores of pairs flagged before and dropping those that no longer reach
+// it. Pairs found sharing an ISBN and dismissed pairs are kept. It returns the
+// number of pairs awaiting review.
+func (m DuplicateModel) Detect(threshold float64) (int, error) {
+	// Comparing every pair of books takes a while on a large catalog; the
+	// trigram index on normalized titles narrows it down to those that are at
+	// all alike, compared the same way as they are scored.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	WITH candidates AS (
+		SELECT a.id AS book_id, b.id AS duplicate_id, ` + duplicateSimilarities + `
+		FROM books AS a
+		JOIN books AS b ON a.id < b.id AND author_key(a.title) % author_key(b.title)
+		WHERE a.deleted_at IS NULL AND b.deleted_at IS NULL
+	),
+	scored AS (
+		SELECT candidates.*, round((title_similarity + author_similarity) / 2, 2) AS score
+		FROM candidates
+	)
+	INSERT INTO book_duplicates (book_id, duplicate_id, title_similarity, author_similarity, score)
+	SELECT book_id, duplicate_id, title_similarity, author_similarity, score
+	FROM scored
+	WHERE score >= $1
+	ON CONFLICT (book_id, duplicate_id) DO UPDATE
+	SET title_similarity = excluded.title_similarity, author_similarity = excluded.author_similarity,
+		score = CASE WHEN book_duplicates.same_isbn THEN 1 ELSE excluded.score END, detected_at = NOW()`
+	if _, err = tx.ExecContext(ctx, query, threshold); err != nil {
+		return 0, err
+	}
+	// NOW() is the start of the transaction, so this leaves the pairs flagged
+	// above alone.
+	query = `DELETE FROM book_duplicates WHERE NOT same_isbn AND NOT dismissed AND detected_at < NOW()`
+	if _, err = tx.ExecContext(ctx, query); err != nil {
+		return 0, err
+	}
+	query = `
+	SELECT count(*) FROM book_duplicates
+	WHERE NOT dismissed
+	AND NOT EXISTS (SELECT 1 FROM books WHERE id IN (book_id, duplicate_id) AND deleted_at IS NOT NULL)`
+	var pending int
+	err = tx.QueryRowContext(ctx, query).Scan(&pending)
+	if err != nil {
+		return 0, err
+	}
+	return pending, tx.Commit()
+}
+
+// FlagISBN flags two books found to have an edition with the same ISBN. A pair
+// dismissed before stays dismissed.
+func (m DuplicateModel) FlagISBN(bookID, otherID int64) error {
+	query := `
+	INSERT INTO book_duplicates (book_id, duplicate_id, title_similarity, author_similarity, score, same_isbn)
+	SELECT a.id, b.id, ` + duplicateSimilarities + `, 1, true
+	FROM books AS a, books AS b
+	WHERE a.id = least($1::bigint, $2::bigint) AND b.id = greatest($1::bigint, $2::bigint)
+	AND a.deleted_at IS NULL AND b.deleted_at IS NULL
+	ON CONFLICT (book_id, duplicate_id) DO UPDATE
+	SET same_isbn = true, score = 1, detected_at = NOW()`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, bookID, otherID)
+	return err
+}
+
+// GetAll returns a page of the flagged pairs, either those awaiting review or
+// the dismissed ones. Pairs with a book in the trash are left out.
+func (m DuplicateModel) GetAll(dismissed bool, filters Filters) ([]*Duplicate, Metadata, error) {
+	sortExpr := filters.sortColumn()
+	countExpr := "count(*) OVER()"
+	if filters.Cursor != "" {
+		countExpr = "0"
+	}
+	keyset, keysetArgs := filters.keyset(sortExpr, "id", 4)
+	query := fmt.Sprintf(`
+	SELECT %s, (%s)::text, %s
+	FROM book_duplicates
+	WHERE dismissed = $1
+	AND NOT EXISTS (SELECT 1 FROM books WHERE id IN (book_id, duplicate_id) AND deleted_at IS NOT NULL)
+	AND %s
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3`, countExpr, sortExpr, duplicateColumns, keyset, sortExpr, filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	args := append([]interface{}{dismissed, filters.limit(), filters.offset()}, keysetArgs...)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	duplicates := []*Duplicate{}
+	var sortKeys []string
+	var ids []int64
+	for rows.Next() {
+		var d Duplicate
+		var sortKey string
+		err := rows.Scan(append([]interface{}{&totalRecords, &sortKey}, d.fields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		duplicates = append(duplicates, &d)
+		sortKeys = append(sortKeys, sortKey)
+		ids = append(ids, d.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := filters.metadata(totalRecords, sortKeys, ids)
+	if len(duplicates) > filters.Limit {
+		duplicates = duplicates[:filters.Limit]
+	}
+	return duplicates, metadata, nil
+}
+
+// SetDismissed dismisses a flagged pair as not being duplicates after all, so
+// that detection leaves it out of the queue, or takes it back into the queue.
+func (m DuplicateModel) SetDismissed(id int64, dismissed bool) (*Duplicate, error) {
+	query := `UPDATE book_duplicates SET dismissed = $2 WHERE id = $1 RETURNING ` + duplicateColumns
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var d Duplicate
+	err := m.DB.QueryRowContext(ctx, query, id, dismissed).Scan(d.fields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &d, nil
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"database/sql/driver"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Merging moves everything the other book has and leaves a redirect behind, or
+// does nothing at all.
+func TestMergeRunsInOneTransaction(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("FOR UPDATE", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"id"}, Rows: [][]driver.Value{{int64(2)}}}
+	})
+	db.On("UPDATE books SET version", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: bookRowColumns, Rows: [][]driver.Value{bookRow(1, "Dune", "Frank Herbert")}}
+	})
+	book, err := BookModel{DB: conn}.Merge(1, 2, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.ID != 1 {
+		t.Errorf("merged into book %d, want 1", book.ID)
+	}
+	statements := db.Statements()
+	if statements[0] != "BEGIN" || statements[len(statements)-1] != "COMMIT" {
+		t.Fatalf("statements = %q, want one transaction", statements)
+	}
+	var redirected, deleted bool
+	for _, statement := range statements {
+		redirected = redirected || strings.Contains(statement, "INSERT INTO book_redirects")
+		deleted = deleted || strings.Contains(statement, "DELETE FROM books")
+	}
+	if !redirected || !deleted {
+		t.Errorf("statements = %q, want the book deleted with a redirect left", statements)
+	}
+}
+
+func TestMergeOfAnUnknownBook(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("FOR UPDATE", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"id"}}
+	})
+	if _, err := (BookModel{DB: conn}).Merge(1, 2, 7); err != ErrUnknownBook {
+		t.Fatalf("got %v, want ErrUnknownBook", err)
+	}
+	statements := db.Statements()
+	if last := statements[len(statements)-1]; last != "ROLLBACK" {
+		t.Errorf("statements = %q, want a rollback", statements)
+	}
+}
+
+// Pairs not flagged again by a detection are dropped, but only after the
+// others have been refreshed.
+func TestDetectRefreshesBeforeDropping(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("SELECT count(*) FROM book_duplicates", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"count"}, Rows: [][]driver.Value{{int64(4)}}}
+	})
+	pending, err := DuplicateModel{DB: conn}.Detect(0.6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 4 {
+		t.Errorf("pending = %d, want 4", pending)
+	}
+	var inserted, deleted int
+	for i, statement := range db.Statements() {
+		switch {
+		case strings.Contains(statement, "INSERT INTO book_duplicates"):
+			inserted = i
+		case strings.Contains(statement, "DELETE FROM book_duplicates"):
+			deleted = i
+		}
+	}
+	if inserted == 0 || deleted < inserted {
+		t.Errorf("statements = %q, want the insert before the delete", db.Statements())
+	}
+}
+
+// Candidates are prefiltered on the same normalized titles they are scored
+// on, which the trigram index of migration 000024 covers.
+func TestDetectPrefiltersOnNormalizedTitles(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("SELECT count(*) FROM book_duplicates", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"count"}, Rows: [][]driver.Value{{int64(3)}}}
+	})
+	pending, err := DuplicateModel{DB: conn}.Detect(0.6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 3 {
+		t.Errorf("got %d pending pairs, want 3", pending)
+	}
+	var detect string
+	for _, statement := range db.Statements() {
+		if strings.Contains(statement, "INSERT INTO book_duplicates") {
+			detect = statement
+		}
+	}
+	if !strings.Contains(detect, "author_key(a.title) % author_key(b.title)") {
+		t.Errorf("candidates aren't prefiltered on normalized titles:\n%s", detect)
+	}
+	migration, err := os.ReadFile("../migrations/000024_add_books_title_key_trgm_index.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(migration), "(author_key(title) gin_trgm_ops)") {
+		t.Error("the trigram index isn't on normalized titles")
+	}
+}
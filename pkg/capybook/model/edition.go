@@ -0,0 +1,238 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyndaliu/capybook/pkg/capybook/isbn"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+var ErrDuplicateISBN = errors.New("duplicate isbn")
+
+type EditionModel struct {
+	DB *sql.DB
+}
+
+// Edition is a published form of a book, such as a paperback printing or a
+// translation. Reviews are about the book, not about one of its editions.
+type Edition struct {
+	ID        int64     `json:"id"`
+	BookID    int64     `json:"book_id"`
+	ISBN13    string    `json:"isbn_13,omitempty"`
+	ISBN10    string    `json:"isbn_10,omitempty"`
+	Title     string    `json:"title,omitempty" validate:"max=200"`
+	Publisher string    `json:"publisher,omitempty" validate:"max=200"`
+	Language  string    `json:"language,omitempty" validate:"max=50"`
+	Format    string    `json:"format,omitempty" validate:"omitempty,oneof=hardcover paperback ebook audiobook other"`
+	Pages     int32     `json:"pages,omitempty" validate:"min=0,max=100000"`
+	Year      int32     `json:"year,omitempty" validate:"notfuture"`
+	Version   int32     `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const editionColumns = `editions.id, editions.book_id, coalesce(editions.isbn13, ''), editions.title, editions.publisher,
+	editions.language, editions.format, editions.pages, editions.year, editions.version, editions.updated_at`
+
+// scan reads a row of editionColumns into edition.
+func (edition *Edition) scan(row interface{ Scan(...interface{}) error }) error {
+	err := row.Scan(
+		&edition.ID,
+		&edition.BookID,
+		&edition.ISBN13,
+		&edition.Title,
+		&edition.Publisher,
+		&edition.Language,
+		&edition.Format,
+		&edition.Pages,
+		&edition.Year,
+		&edition.Version,
+		&edition.UpdatedAt,
+	)
+	edition.ISBN10 = isbn.To10(edition.ISBN13)
+	return err
+}
+
+// duplicateISBN maps a violation of the unique ISBN constraint to
+// ErrDuplicateISBN.
+func duplicateISBN(err error) error {
+	if err != nil && err.Error() == `pq: duplicate key value violates unique constraint "editions_isbn13_key"` {
+		return ErrDuplicateISBN
+	}
+	return err
+}
+
+func (m EditionModel) Insert(edition *Edition) error {
+	query := `
+	INSERT INTO editions (book_id, isbn13, title, publisher, language, format, pages, year)
+	VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8)
+	RETURNING id, version, updated_at`
+	args := []interface{}{edition.BookID, edition.ISBN13, edition.Title, edition.Publisher,
+		edition.Language, edition.Format, edition.Pages, edition.Year}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&edition.ID, &edition.Version, &edition.UpdatedAt)
+	edition.ISBN10 = isbn.To10(edition.ISBN13)
+	return duplicateISBN(err)
+}
+
+func (m EditionModel) Get(id int64) (*Edition, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	return m.getBy(`editions.id = $1`, id)
+}
+
+// GetByISBN finds the edition with a normalized ISBN-13.
+func (m EditionModel) GetByISBN(isbn13 string) (*Edition, error) {
+	return m.getBy(`editions.isbn13 = $1`, isbn13)
+}
+
+func (m EditionModel) getBy(where string, arg interface{}) (*Edition, error) {
+	query := `SELECT ` + editionColumns + ` FROM editions WHERE ` + where + `
+	AND editions.book_id IN (SELECT id FROM books WHERE deleted_at IS NULL)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var edition Edition
+	err := edition.scan(m.DB.QueryRowContext(ctx, query, arg))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &edition, nil
+}
+
+// GetForBooks fetches the editions of the given books in a single query, keyed
+// by book id and ordered by year.
+func (m EditionModel) GetForBooks(bookIDs []int64) (map[int64][]*Edition, error) {
+	query := `
+	SELECT ` + editionColumns + ` FROM editions
+	WHERE book_id = ANY($1)
+	ORDER BY book_id, year, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(bookIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	editions := make(map[int64][]*Edition)
+	for rows.Next() {
+		var edition Edition
+		if err := edition.scan(rows); err != nil {
+			return nil, err
+		}
+		editions[edition.BookID] = append(editions[edition.BookID], &edition)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return editions, nil
+}
+
+// FindBooks maps each of the given ISBN-13s that is known to the book of its
+// edition.
+func (m EditionModel) FindBooks(isbns []string) (map[string]int64, error) {
+	query := `
+	SELECT isbn13, book_id FROM editions
+	WHERE isbn13 = ANY($1) AND book_id IN (SELECT id FROM books WHERE deleted_at IS NULL)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(isbns))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	books := make(map[string]int64)
+	for rows.Next() {
+		var isbn13 string
+		var bookID int64
+		if err := rows.Scan(&isbn13, &bookID); err != nil {
+			return nil, err
+		}
+		books[isbn13] = bookID
+	}
+	return books, rows.Err()
+}
+
+func (m EditionModel) Update(edition *Edition) error {
+	query := `
+	UPDATE editions
+	SET isbn13 = NULLIF($1, ''), title = $2, publisher = $3, language = $4, format = $5, pages = $6, year = $7,
+		version = version + 1, updated_at = NOW()
+	WHERE id = $8 AND version = $9
+	RETURNING version, updated_at`
+	args := []interface{}{edition.ISBN13, edition.Title, edition.Publisher, edition.Language,
+		edition.Format, edition.Pages, edition.Year, edition.ID, edition.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&edition.Version, &edition.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return duplicateISBN(err)
+		}
+	}
+	edition.ISBN10 = isbn.To10(edition.ISBN13)
+	return nil
+}
+
+func (m EditionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM editions WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Freshness summarizes the editions of a book, or of every book when bookID
+// is 0, for revalidating cached responses.
+func (m EditionModel) Freshness(bookID int64) (Freshness, error) {
+	query := `
+	SELECT count(*), coalesce(max(updated_at), 'epoch') FROM editions
+	WHERE ($1::bigint = 0 OR book_id = $1)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var f Freshness
+	err := m.DB.QueryRowContext(ctx, query, bookID).Scan(&f.Count, &f.LastModified)
+	return f, err
+}
+
+// ValidateEdition checks edition, normalizing its ISBN to the ISBN-13 form on
+// the way. The ISBN is reported as "isbn", the member clients write it to.
+func ValidateEdition(v *validator.Validator, edition *Edition) {
+	if edition.ISBN13 != "" {
+		normalized, err := isbn.Normalize(edition.ISBN13)
+		if err != nil {
+			v.Fail("isbn", "invalid_isbn", "must be a valid ISBN-10 or ISBN-13")
+		} else {
+			edition.ISBN13 = normalized
+			edition.ISBN10 = isbn.To10(normalized)
+		}
+	}
+	v.Struct(edition)
+}
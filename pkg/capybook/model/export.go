@@ -0,0 +1,52 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// exportBatchSize is how many rows each FETCH from an export cursor returns.
+const exportBatchSize = 1000
+
+// streamCursor runs query through a server-side cursor in a read-only
+// transaction and hands the rows to scan one at a time, fetching them in
+// batches so that memory use doesn't grow with the size of the table. The whole
+// export sees a single snapshot of the database.
+func streamCursor(ctx context.Context, db *sql.DB, query string, scan func(*sql.Rows) error) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DECLARE export_cursor NO SCROLL CURSOR FOR "+query)
+	if err != nil {
+		return err
+	}
+	fetch := fmt.Sprintf("FETCH %d FROM export_cursor", exportBatchSize)
+	for {
+		rows, err := tx.QueryContext(ctx, fetch)
+		if err != nil {
+			return err
+		}
+		n := 0
+		for rows.Next() {
+			n++
+			if err := scan(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if n < exportBatchSize {
+			break
+		}
+	}
+	return tx.Commit()
+}
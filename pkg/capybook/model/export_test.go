@@ -0,0 +1,71 @@
+package model
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Exports read through a cursor in batches, until a batch comes back short.
+func TestExportFetchesInBatches(t *testing.T) {
+	conn, db := newStubDB(t)
+	fetches := 0
+	db.On("FETCH", func([]driver.NamedValue) stubResult {
+		fetches++
+		res := stubResult{Columns: bookRowColumns}
+		n := exportBatchSize
+		if fetches > 1 {
+			n = 2
+		}
+		for i := 0; i < n; i++ {
+			res.Rows = append(res.Rows, bookRow(int64(len(res.Rows)+1), "War and Peace", "Leo Tolstoy"))
+		}
+		return res
+	})
+
+	exported := 0
+	err := BookModel{DB: conn}.Export(context.Background(), func(*Book) error {
+		exported++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported != exportBatchSize+2 {
+		t.Errorf("exported %d books, want %d", exported, exportBatchSize+2)
+	}
+
+	var got []string
+	for _, statement := range db.Statements() {
+		got = append(got, strings.Fields(statement)[0])
+	}
+	want := []string{"BEGIN", "DECLARE", "FETCH", "FETCH", "COMMIT"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
+
+func TestExportStopsAtTheFirstError(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("FETCH", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: bookRowColumns, Rows: [][]driver.Value{
+			bookRow(1, "War and Peace", "Leo Tolstoy"),
+			bookRow(2, "Anna Karenina", "Leo Tolstoy"),
+		}}
+	})
+	stop := errors.New("stop")
+	calls := 0
+	err := BookModel{DB: conn}.Export(context.Background(), func(*Book) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("got %v after %d calls, want the callback's error after one", err, calls)
+	}
+	if statements := db.Statements(); statements[len(statements)-1] != "ROLLBACK" {
+		t.Errorf("the export transaction wasn't rolled back: %v", statements)
+	}
+}
@@ -1,6 +1,10 @@
 package model
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/shyndaliu/capybook/pkg/capybook/validator"
@@ -11,11 +15,52 @@ type Filters struct {
 	Limit        int
 	Sort         string
 	SortSafelist []string
+	// Cursor switches the listing to keyset pagination: rows are read from just
+	// after the position it encodes and Page is ignored.
+	Cursor string
+}
+
+// cursor is the decoded form of Filters.Cursor: the sort the listing was using,
+// and the sort key and id of the last row that was returned.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"i"`
+}
+
+func encodeCursor(c cursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c cursor
+	if err := json.Unmarshal(js, &c); err != nil || c.ID < 1 {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
 }
 
 func (f Filters) offset() int {
+	if f.Cursor != "" {
+		return 0
+	}
 	return (f.Page - 1) * f.Limit
 }
+
+// limit returns the number of rows to fetch. In cursor mode one extra row is
+// read to find out whether another page follows.
+func (f Filters) limit() int {
+	if f.Cursor != "" {
+		return f.Limit + 1
+	}
+	return f.Limit
+}
+
 func (f Filters) sortColumn() string {
 	for _, safeValue := range f.SortSafelist {
 		if f.Sort == safeValue {
@@ -34,10 +79,81 @@ func (f Filters) sortDirection() string {
 	return "ASC"
 }
 
+// keyset returns a condition restricting a query to the rows that come after the
+// cursor when ordered by column and then idColumn ascending, along with the
+// values of the placeholders $n and $n+1 it uses. Without a cursor the
+// condition is always true.
+func (f Filters) keyset(column, idColumn string, n int) (string, []interface{}) {
+	c, err := decodeCursor(f.Cursor)
+	if f.Cursor == "" || err != nil {
+		return "TRUE", nil
+	}
+	op := ">"
+	if f.sortDirection() == "DESC" {
+		op = "<"
+	}
+	condition := fmt.Sprintf("(%s %s $%d OR (%s = $%d AND %s > $%d))", column, op, n, column, n, idColumn, n+1)
+	return condition, []interface{}{c.Value, c.ID}
+}
+
+// Metadata describes where a page of records sits within the full result set.
+type Metadata struct {
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+}
+
+// calculateMetadata works out the pagination metadata from the total number of
+// records matched by a query. An empty Metadata is returned when nothing matched.
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     (totalRecords + pageSize - 1) / pageSize,
+		TotalRecords: totalRecords,
+	}
+}
+
+// metadata builds the metadata of a page of fetched rows, whose sort keys and
+// ids are given in order. In cursor mode the total is unknown, so only the page
+// size and the cursor of the next page are filled in.
+func (f Filters) metadata(totalRecords int, sortKeys []string, ids []int64) Metadata {
+	var metadata Metadata
+	hasNext := false
+	if f.Cursor != "" {
+		metadata = Metadata{PageSize: f.Limit}
+		hasNext = len(ids) > f.Limit
+	} else {
+		metadata = calculateMetadata(totalRecords, f.Page, f.Limit)
+		hasNext = metadata.CurrentPage < metadata.LastPage
+	}
+	last := len(ids) - 1
+	if last >= f.Limit {
+		last = f.Limit - 1
+	}
+	if hasNext && last >= 0 {
+		metadata.NextCursor = encodeCursor(cursor{Sort: f.Sort, Value: sortKeys[last], ID: ids[last]})
+	}
+	return metadata
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
-	v.Check(f.Limit > 0, "limit", "must be greater than zero")
-	v.Check(f.Limit <= 100, "limit", "must be a maximum of 100")
-	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.Var("page", f.Page, "min=1,max=10000000")
+	v.Var("limit", f.Limit, "min=1,max=100")
+	v.Var("sort", f.Sort, "oneof="+strings.Join(f.SortSafelist, " "))
+	if f.Cursor != "" {
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			v.Fail("cursor", "invalid_cursor", "must be a cursor returned by a previous page")
+		} else if c.Sort != f.Sort {
+			v.Fail("cursor", "cursor_sort_mismatch", "was issued for a different sort order")
+		}
+	}
 }
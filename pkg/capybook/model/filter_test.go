@@ -0,0 +1,86 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+func TestCalculateMetadata(t *testing.T) {
+	tests := []struct {
+		total, page, size int
+		want              Metadata
+	}{
+		{0, 1, 20, Metadata{}},
+		{1, 1, 20, Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 1}},
+		{40, 2, 20, Metadata{CurrentPage: 2, PageSize: 20, FirstPage: 1, LastPage: 2, TotalRecords: 40}},
+		{41, 1, 20, Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 3, TotalRecords: 41}},
+	}
+	for _, tt := range tests {
+		if got := calculateMetadata(tt.total, tt.page, tt.size); got != tt.want {
+			t.Errorf("calculateMetadata(%d, %d, %d) = %+v, want %+v", tt.total, tt.page, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := cursor{Sort: "-year", Value: "1965", ID: 12}
+	got, err := decodeCursor(encodeCursor(c))
+	if err != nil || *got != c {
+		t.Errorf("got %+v, %v, want %+v", got, err, c)
+	}
+	for _, s := range []string{"", "not base64!", encodeCursor(cursor{Sort: "id"})} {
+		if _, err := decodeCursor(s); err == nil {
+			t.Errorf("decodeCursor(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestKeyset(t *testing.T) {
+	f := Filters{Sort: "-year", SortSafelist: []string{"-year"}}
+	if condition, args := f.keyset("books.year", "books.id", 9); condition != "TRUE" || args != nil {
+		t.Errorf("got %q %v without a cursor, want TRUE", condition, args)
+	}
+	f.Cursor = encodeCursor(cursor{Sort: "-year", Value: "1965", ID: 12})
+	condition, args := f.keyset("books.year", "books.id", 9)
+	if want := "(books.year < $9 OR (books.year = $9 AND books.id > $10))"; condition != want {
+		t.Errorf("got %q, want %q", condition, want)
+	}
+	if len(args) != 2 || args[0] != "1965" || args[1] != int64(12) {
+		t.Errorf("got arguments %v, want 1965 and 12", args)
+	}
+}
+
+func TestCursorMetadata(t *testing.T) {
+	f := Filters{Limit: 2, Sort: "id", SortSafelist: []string{"id"}, Cursor: encodeCursor(cursor{Sort: "id", Value: "3", ID: 3})}
+	if f.limit() != 3 || f.offset() != 0 {
+		t.Errorf("got limit %d offset %d, want one extra row and no offset", f.limit(), f.offset())
+	}
+	metadata := f.metadata(0, []string{"4", "5", "6"}, []int64{4, 5, 6})
+	c, err := decodeCursor(metadata.NextCursor)
+	if err != nil || c.ID != 5 || metadata.PageSize != 2 || metadata.TotalRecords != 0 {
+		t.Errorf("got %+v, want a cursor after the second row", metadata)
+	}
+	if metadata := f.metadata(0, []string{"4", "5"}, []int64{4, 5}); metadata.NextCursor != "" {
+		t.Errorf("got a next cursor %q on the last page", metadata.NextCursor)
+	}
+}
+
+func TestValidateFiltersChecksTheCursor(t *testing.T) {
+	tests := []struct {
+		cursor string
+		valid  bool
+	}{
+		{"", true},
+		{encodeCursor(cursor{Sort: "-year", Value: "1965", ID: 12}), true},
+		{encodeCursor(cursor{Sort: "title", Value: "Dune", ID: 12}), false},
+		{"garbage", false},
+	}
+	for _, tt := range tests {
+		v := validator.New()
+		ValidateFilters(v, Filters{Page: 1, Limit: 20, Sort: "-year", SortSafelist: []string{"-year"}, Cursor: tt.cursor})
+		if v.Valid() != tt.valid {
+			t.Errorf("cursor %q: valid = %v, want %v", tt.cursor, v.Valid(), tt.valid)
+		}
+	}
+}
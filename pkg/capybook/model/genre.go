@@ -0,0 +1,345 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+var (
+	ErrUnknownGenre = errors.New("unknown genre")
+	ErrGenreCycle   = errors.New("genre would be its own ancestor")
+)
+
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// Genre is a genre of the taxonomy. Books name their genres by the canonical
+// Name; any of the Aliases, which are matched ignoring case, punctuation and
+// spacing, is turned into it when books are saved.
+type Genre struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name" validate:"required,max=50"`
+	ParentID  *int64    `json:"parent_id"`
+	Aliases   []string  `json:"aliases"`
+	Version   int32     `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// GenreNode is a genre in the tree of the taxonomy, with the number of books
+// filed directly under it.
+type GenreNode struct {
+	*Genre
+	Books    int          `json:"books"`
+	Children []*GenreNode `json:"children"`
+}
+
+const genreColumns = `genres.id, genres.name, genres.parent_id, genres.version, genres.updated_at,
+	array(SELECT name FROM genre_aliases WHERE genre_id = genres.id ORDER BY name)`
+
+func (genre *Genre) scan(row interface{ Scan(...interface{}) error }) error {
+	var parentID sql.NullInt64
+	err := row.Scan(&genre.ID, &genre.Name, &parentID, &genre.Version, &genre.UpdatedAt, pq.Array(&genre.Aliases))
+	genre.ParentID = nil
+	if parentID.Valid {
+		genre.ParentID = &parentID.Int64
+	}
+	return err
+}
+
+// renormalizeBooks rewrites the genres of the books using any alias of genre
+// to canonical names. The books move to a new version.
+func renormalizeBooks(ctx context.Context, db execer, genreID int64) error {
+	query := `
+	UPDATE books
+	SET genres = canonical_genres(genres), version = version + 1, updated_at = NOW()
+	WHERE EXISTS (
+		SELECT 1 FROM unnest(books.genres) AS genre
+		JOIN genre_aliases ON genre_aliases.key = genre_key(genre)
+		WHERE genre_aliases.genre_id = $1
+	)
+	AND genres <> canonical_genres(genres)`
+	_, err := db.ExecContext(ctx, query, genreID)
+	return err
+}
+
+// genreQueryer is what the genre queries need of a database or transaction.
+type genreQueryer interface {
+	execer
+	queryer
+}
+
+func addGenreAlias(ctx context.Context, db genreQueryer, genreID int64, name string) error {
+	query := `
+	INSERT INTO genre_aliases (key, genre_id, name)
+	VALUES (genre_key($2), $1, trim($2))
+	ON CONFLICT (key) DO UPDATE SET key = EXCLUDED.key
+	RETURNING genre_id`
+	var owner int64
+	err := db.QueryRowContext(ctx, query, genreID, name).Scan(&owner)
+	if err != nil {
+		if strings.Contains(err.Error(), "genre_aliases_genre_id_fkey") {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	if owner != genreID {
+		return ErrDuplicateAlias
+	}
+	return renormalizeBooks(ctx, db, genreID)
+}
+
+// Insert adds a genre, known by its own name. Books already filed under a
+// spelling of it are renamed to the genre, on behalf of a user.
+func (m GenreModel) Insert(genre *Genre, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = setEditor(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO genres (name, parent_id)
+	VALUES ($1, $2)
+	RETURNING id, version, updated_at`
+	err = tx.QueryRowContext(ctx, query, genre.Name, genre.ParentID).Scan(&genre.ID, &genre.Version, &genre.UpdatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "genres_parent_id_fkey") {
+			return ErrUnknownGenre
+		}
+		return err
+	}
+	if err = addGenreAlias(ctx, tx, genre.ID, genre.Name); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	genre.Aliases = []string{genre.Name}
+	return nil
+}
+
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + genreColumns + ` FROM genres WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var genre Genre
+	err := genre.scan(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &genre, nil
+}
+
+// Tree returns the taxonomy as a forest of its top-level genres, ordered by
+// name at every level.
+func (m GenreModel) Tree() ([]*GenreNode, error) {
+	query := `
+	SELECT ` + genreColumns + `, coalesce(counts.books, 0)
+	FROM genres
+	LEFT JOIN (
+		SELECT genre, count(*) AS books
+		FROM books, unnest(books.genres) AS genre
+		WHERE NOT books.pending AND books.deleted_at IS NULL
+		GROUP BY genre
+	) AS counts ON counts.genre = genres.name
+	ORDER BY genres.name`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []*GenreNode
+	byID := make(map[int64]*GenreNode)
+	for rows.Next() {
+		node := &GenreNode{Genre: &Genre{}, Children: []*GenreNode{}}
+		var parentID sql.NullInt64
+		err := rows.Scan(&node.ID, &node.Name, &parentID, &node.Version, &node.UpdatedAt,
+			pq.Array(&node.Aliases), &node.Books)
+		if err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			node.ParentID = &parentID.Int64
+		}
+		nodes = append(nodes, node)
+		byID[node.ID] = node
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	roots := []*GenreNode{}
+	for _, node := range nodes {
+		if node.ParentID == nil {
+			roots = append(roots, node)
+			continue
+		}
+		parent := byID[*node.ParentID]
+		parent.Children = append(parent.Children, node)
+	}
+	return roots, nil
+}
+
+// Update saves genre if it is still at the version that was read. A new name
+// becomes one of the genre's aliases and the books filed under the genre are
+// renamed with it, on behalf of a user.
+func (m GenreModel) Update(genre *Genre, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = setEditor(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if genre.ParentID != nil {
+		query := `
+		WITH RECURSIVE subtree AS (
+			SELECT id FROM genres WHERE id = $1
+			UNION
+			SELECT genres.id FROM genres JOIN subtree ON genres.parent_id = subtree.id
+		)
+		SELECT EXISTS (SELECT 1 FROM subtree WHERE id = $2)`
+		var cycle bool
+		if err = tx.QueryRowContext(ctx, query, genre.ID, *genre.ParentID).Scan(&cycle); err != nil {
+			return err
+		}
+		if cycle {
+			return ErrGenreCycle
+		}
+	}
+	query := `
+	UPDATE genres
+	SET name = $1, parent_id = $2, version = version + 1, updated_at = NOW()
+	WHERE id = $3 AND version = $4
+	RETURNING version, updated_at`
+	err = tx.QueryRowContext(ctx, query, genre.Name, genre.ParentID, genre.ID, genre.Version).
+		Scan(&genre.Version, &genre.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case strings.Contains(err.Error(), "genres_parent_id_fkey"):
+			return ErrUnknownGenre
+		default:
+			return err
+		}
+	}
+	if err = addGenreAlias(ctx, tx, genre.ID, genre.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddAlias makes name one of the spellings genre is known by, renaming the
+// books filed under it on behalf of a user. Spellings that already belong to
+// another genre are rejected with ErrDuplicateAlias.
+func (m GenreModel) AddAlias(genreID int64, name string, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = setEditor(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err = addGenreAlias(ctx, tx, genreID, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Merge folds the genre from into the genre into on behalf of a user: its
+// aliases, subgenres and books move over, and from is deleted.
+func (m GenreModel) Merge(into, from int64, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = setEditor(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	var parentID sql.NullInt64
+	query := `SELECT parent_id FROM genres WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, from).Scan(&parentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrUnknownGenre
+		default:
+			return err
+		}
+	}
+	// When into is below from, it takes from's place in the tree, or it would
+	// end up below one of its own former ancestors.
+	query = `
+	WITH RECURSIVE subtree AS (
+		SELECT id FROM genres WHERE id = $2
+		UNION
+		SELECT genres.id FROM genres JOIN subtree ON genres.parent_id = subtree.id
+	)
+	UPDATE genres SET parent_id = $3
+	WHERE id = $1 AND id IN (SELECT id FROM subtree)`
+	if _, err = tx.ExecContext(ctx, query, into, from, parentID); err != nil {
+		return err
+	}
+	query = `
+	UPDATE genres SET parent_id = $1, version = version + 1, updated_at = NOW()
+	WHERE parent_id = $2 AND id <> $1`
+	if _, err = tx.ExecContext(ctx, query, into, from); err != nil {
+		return err
+	}
+	query = `UPDATE genre_aliases SET genre_id = $1 WHERE genre_id = $2`
+	if _, err = tx.ExecContext(ctx, query, into, from); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM genres WHERE id = $1`, from); err != nil {
+		return err
+	}
+	query = `UPDATE genres SET version = version + 1, updated_at = NOW() WHERE id = $1`
+	if _, err = tx.ExecContext(ctx, query, into); err != nil {
+		return err
+	}
+	if err = renormalizeBooks(ctx, tx, into); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Struct(genre)
+	if genre.ParentID != nil && *genre.ParentID == genre.ID {
+		v.Fail("parent_id", "genre_cycle", "must not be the genre itself or one of its subgenres")
+	}
+}
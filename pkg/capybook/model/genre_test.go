@@ -0,0 +1,67 @@
+package model
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateGenreRejectsCycles(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("WITH RECURSIVE subtree", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"exists"}, Rows: [][]driver.Value{{true}}}
+	})
+	parent := int64(5)
+	err := GenreModel{DB: conn}.Update(&Genre{ID: 2, Name: "Fantasy", ParentID: &parent, Version: 1}, 7)
+	if !errors.Is(err, ErrGenreCycle) {
+		t.Fatalf("got %v, want ErrGenreCycle", err)
+	}
+	for _, statement := range db.Statements() {
+		if strings.Contains(statement, "UPDATE genres") || statement == "COMMIT" {
+			t.Errorf("a cycle went on to %q", statement)
+		}
+	}
+}
+
+func TestInsertGenreUnderAnUnknownParent(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("INSERT INTO genres", func([]driver.NamedValue) stubResult {
+		return stubResult{Err: errors.New(`pq: insert or update on table "genres" violates foreign key constraint "genres_parent_id_fkey"`)}
+	})
+	parent := int64(99)
+	err := GenreModel{DB: conn}.Insert(&Genre{Name: "Cozy fantasy", ParentID: &parent}, 7)
+	if !errors.Is(err, ErrUnknownGenre) {
+		t.Errorf("got %v, want ErrUnknownGenre", err)
+	}
+}
+
+// Subgenres hang under their parent whichever comes first by name.
+func TestTreeNestsSubgenres(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("AS counts", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"id", "name", "parent_id", "version", "updated_at", "aliases", "books"},
+			Rows: [][]driver.Value{
+				{int64(2), "Epic fantasy", int64(1), int64(1), time.Now(), "{}", int64(3)},
+				{int64(1), "Fantasy", nil, int64(1), time.Now(), `{"fantasy fiction"}`, int64(5)},
+				{int64(3), "Romance", nil, int64(1), time.Now(), "{}", int64(0)},
+			},
+		}
+	})
+	roots, err := GenreModel{DB: conn}.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 2 || roots[0].Name != "Fantasy" || roots[1].Name != "Romance" {
+		t.Fatalf("got roots %v", roots)
+	}
+	fantasy := roots[0]
+	if len(fantasy.Children) != 1 || fantasy.Children[0].Name != "Epic fantasy" || fantasy.Children[0].Books != 3 {
+		t.Errorf("got children %v of Fantasy", fantasy.Children)
+	}
+	if len(fantasy.Aliases) != 1 || len(roots[1].Children) != 0 {
+		t.Errorf("got %+v and %+v", fantasy.Genre, roots[1])
+	}
+}
@@ -0,0 +1,167 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// States an import job goes through.
+const (
+	ImportQueued    = "queued"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+type ImportJobModel struct {
+	DB *sql.DB
+}
+
+// ImportJob tracks the import of a user's library from another site. The
+// counters are updated as rows are processed, so that clients can show
+// progress while the job runs in the background.
+type ImportJob struct {
+	ID           int64         `json:"id"`
+	UserID       int64         `json:"-"`
+	Source       string        `json:"source"`
+	Status       string        `json:"status"`
+	Total        int           `json:"total"`
+	Processed    int           `json:"processed"`
+	Matched      int           `json:"matched"`
+	CreatedBooks int           `json:"created_books"`
+	Reviews      int           `json:"reviews"`
+	Skipped      int           `json:"skipped"`
+	Issues       []ImportIssue `json:"issues"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+	FinishedAt   *time.Time    `json:"finished_at,omitempty"`
+}
+
+// ImportIssue explains why a row of an import was skipped. Reason is the
+// message for Code in the reader's language and is not stored.
+type ImportIssue struct {
+	Line   int    `json:"line"`
+	Title  string `json:"title,omitempty"`
+	Code   string `json:"code"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (m ImportJobModel) Insert(job *ImportJob) error {
+	query := `
+	INSERT INTO import_jobs (user_id, source, status, total)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, updated_at`
+	if job.Status == "" {
+		job.Status = ImportQueued
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, job.UserID, job.Source, job.Status, job.Total).
+		Scan(&job.ID, &job.CreatedAt, &job.UpdatedAt)
+}
+
+// Get returns the job with the given id if it belongs to userID.
+func (m ImportJobModel) Get(id, userID int64) (*ImportJob, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT id, user_id, source, status, total, processed, matched, created_books, reviews, skipped,
+		issues, created_at, updated_at, finished_at
+	FROM import_jobs
+	WHERE id = $1 AND user_id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var job ImportJob
+	var issues []byte
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&job.ID,
+		&job.UserID,
+		&job.Source,
+		&job.Status,
+		&job.Total,
+		&job.Processed,
+		&job.Matched,
+		&job.CreatedBooks,
+		&job.Reviews,
+		&job.Skipped,
+		&issues,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.FinishedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if err := json.Unmarshal(issues, &job.Issues); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Update saves the progress and status of a job. Jobs that are completed or
+// failed are stamped as finished.
+func (m ImportJobModel) Update(job *ImportJob) error {
+	issues, err := json.Marshal(job.Issues)
+	if err != nil {
+		return err
+	}
+	if job.Issues == nil {
+		issues = []byte("[]")
+	}
+	query := `
+	UPDATE import_jobs
+	SET status = $1, total = $2, processed = $3, matched = $4, created_books = $5, reviews = $6,
+		skipped = $7, issues = $8, updated_at = NOW(),
+		finished_at = CASE WHEN $1 IN ('completed', 'failed') THEN NOW() END
+	WHERE id = $9
+	RETURNING updated_at, finished_at`
+	args := []interface{}{
+		job.Status,
+		job.Total,
+		job.Processed,
+		job.Matched,
+		job.CreatedBooks,
+		job.Reviews,
+		job.Skipped,
+		issues,
+		job.ID,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&job.UpdatedAt, &job.FinishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+	return err
+}
+
+// FailUnfinished marks the jobs that are still queued or running as failed,
+// with an interrupted issue. The rows of a library only live in the memory of
+// the server importing it, so when the server starts, jobs left over by its
+// previous run can't be resumed; their owners have to upload the file again.
+func (m ImportJobModel) FailUnfinished() (int64, error) {
+	query := `
+	UPDATE import_jobs
+	SET status = $1, issues = issues || $2::jsonb, updated_at = NOW(), finished_at = NOW()
+	WHERE status IN ($3, $4)`
+	interrupted, err := json.Marshal([]ImportIssue{{Code: "interrupted"}})
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, ImportFailed, string(interrupted), ImportQueued, ImportRunning)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
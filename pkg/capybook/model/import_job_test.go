@@ -0,0 +1,37 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFailUnfinishedImports(t *testing.T) {
+	conn, db := newStubDB(t)
+	var args []driver.NamedValue
+	db.On("UPDATE import_jobs", func(a []driver.NamedValue) stubResult {
+		args = a
+		// Two jobs were left unfinished.
+		return stubResult{Rows: [][]driver.Value{{}, {}}}
+	})
+	n, err := ImportJobModel{DB: conn}.FailUnfinished()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d jobs failed, want 2", n)
+	}
+	statements := db.Statements()
+	if len(statements) != 1 || !strings.Contains(statements[0], "WHERE status IN ($3, $4)") {
+		t.Fatalf("got statements %q, want one update of unfinished jobs", statements)
+	}
+	var issues []ImportIssue
+	if err := json.Unmarshal([]byte(args[1].Value.(string)), &issues); err != nil {
+		t.Fatal(err)
+	}
+	if args[0].Value != ImportFailed || args[2].Value != ImportQueued || args[3].Value != ImportRunning ||
+		len(issues) != 1 || issues[0].Code != "interrupted" {
+		t.Errorf("got arguments %v, want queued and running jobs failed with an interrupted issue", args)
+	}
+}
@@ -3,19 +3,44 @@ package model
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
 )
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	ErrInTrash        = errors.New("in trash")
 )
 
+// Freshness summarizes a set of rows so that HTTP caches can be revalidated
+// without reading the rows: LastModified moves on every insert and update, and
+// Count catches deletions.
+type Freshness struct {
+	Count        int64
+	LastModified time.Time
+}
+
+func (f Freshness) String() string {
+	return fmt.Sprintf("%d@%d", f.Count, f.LastModified.UnixNano())
+}
+
 type Models struct {
 	Books         BookModel
 	Users         UserModel
 	Verifications VerificationModel
 	Permissions   PermissionModel
 	Reviews       ReviewModel
+	ImportJobs    ImportJobModel
+	Editions      EditionModel
+	Authors       AuthorModel
+	Series        SeriesModel
+	Genres        GenreModel
+	Tags          TagModel
+	Revisions     RevisionModel
+	Suggestions   SuggestionModel
+	Duplicates    DuplicateModel
+	Catalog       CatalogModel
 }
 
 func NewModels(db *sql.DB) Models {
@@ -25,5 +50,15 @@ func NewModels(db *sql.DB) Models {
 		Verifications: VerificationModel{DB: db},
 		Permissions:   PermissionModel{DB: db},
 		Reviews:       ReviewModel{DB: db},
+		ImportJobs:    ImportJobModel{DB: db},
+		Editions:      EditionModel{DB: db},
+		Authors:       AuthorModel{DB: db},
+		Series:        SeriesModel{DB: db},
+		Genres:        GenreModel{DB: db},
+		Tags:          TagModel{DB: db},
+		Revisions:     RevisionModel{DB: db},
+		Suggestions:   SuggestionModel{DB: db},
+		Duplicates:    DuplicateModel{DB: db},
+		Catalog:       CatalogModel{DB: db},
 	}
 }
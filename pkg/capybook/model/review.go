@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/shyndaliu/capybook/pkg/capybook/validator"
 )
 
@@ -14,26 +15,29 @@ type ReviewModel struct {
 	DB *sql.DB
 }
 type Review struct {
-	ID             int64     `json:"id"`
-	CreatedAt      time.Time `json:"posted_at"`
-	AuthorId       int64     `json:"-"`
-	AuthorUsername string    `json:"author"`
-	BookId         int64     `json:"-"`
-	BookTitle      string    `json:"book"`
-	Content        string    `json:"content"`
-	Rating         int       `json:"rating"`
+	ID             int64      `json:"id"`
+	CreatedAt      time.Time  `json:"posted_at"`
+	AuthorId       int64      `json:"-"`
+	AuthorUsername string     `json:"author"`
+	BookId         int64      `json:"-"`
+	BookTitle      string     `json:"book"`
+	Content        string     `json:"content" validate:"min=50,max=1000"`
+	Rating         int        `json:"rating" validate:"min=1,max=5"`
+	Version        int32      `json:"version"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
 }
 
 func (r ReviewModel) Insert(review *Review) error {
 	query := `
 	INSERT INTO reviews (user_id,book_id, content, rating)
 	VALUES ($1, $2, $3, $4)
-	RETURNING id, created_at`
+	RETURNING id, created_at, version, updated_at`
 	args := []interface{}{review.AuthorId, review.BookId, review.Content, review.Rating}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	err := r.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt)
+	err := r.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.Version, &review.UpdatedAt)
 	if err != nil {
 		return err
 	}
@@ -42,10 +46,10 @@ func (r ReviewModel) Insert(review *Review) error {
 }
 func (r ReviewModel) Get(book_id int64, user_id int64) (*Review, error) {
 	query := `
-	select reviews.id, created_at,username, title, content, rating from reviews
+	select reviews.id, created_at,username, title, content, rating, reviews.version, reviews.updated_at from reviews
     join books on book_id=books.id
     join users on user_id=users.id
-	where book_id=$1 and user_id=$2
+	where book_id=$1 and user_id=$2 and reviews.deleted_at is null
 	limit 1;`
 	var review Review
 	err := r.DB.QueryRow(query, book_id, user_id).Scan(
@@ -56,6 +60,8 @@ func (r ReviewModel) Get(book_id int64, user_id int64) (*Review, error) {
 		&review.BookTitle,
 		&review.Content,
 		&review.Rating,
+		&review.Version,
+		&review.UpdatedAt,
 	)
 	if err != nil {
 		switch {
@@ -67,64 +73,212 @@ func (r ReviewModel) Get(book_id int64, user_id int64) (*Review, error) {
 	}
 	return &review, nil
 }
-func (r ReviewModel) GetAll(book_id int64, filters Filters) ([]*Review, error) {
+func (r ReviewModel) GetAll(book_id int64, filters Filters) ([]*Review, Metadata, error) {
+	sortExpr := "reviews." + filters.sortColumn()
+	countExpr := "count(*) over()"
+	if filters.Cursor != "" {
+		countExpr = "0"
+	}
+	keyset, keysetArgs := filters.keyset(sortExpr, "reviews.id", 4)
 	query := fmt.Sprintf(`
-	select reviews.id, created_at,username, title, content, rating from reviews
+	select %s, (%s)::text, reviews.id, created_at,username, title, content, rating, reviews.version, reviews.updated_at from reviews
     join books on book_id=books.id
     join users on user_id=users.id
-	where book_id=$1
+	where book_id=$1 and reviews.deleted_at is null
+	and %s
 	order by %s %s, reviews.id asc 
-	limit $2 offset $3`, filters.sortColumn(), filters.sortDirection())
+	limit $2 offset $3`, countExpr, sortExpr, keyset, sortExpr, filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	// Pass the title and genres as the placeholder parameter values.
-	rows, err := r.DB.QueryContext(ctx, query, book_id, filters.Limit, filters.offset())
+	args := append([]interface{}{book_id, filters.limit(), filters.offset()}, keysetArgs...)
+	rows, err := r.DB.QueryContext(ctx, query, args...)
 
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
+	totalRecords := 0
 	reviews := []*Review{}
+	var sortKeys []string
+	var ids []int64
 	for rows.Next() {
 		var review Review
+		var sortKey string
 		err := rows.Scan(
+			&totalRecords,
+			&sortKey,
 			&review.ID,
 			&review.CreatedAt,
 			&review.AuthorUsername,
 			&review.BookTitle,
 			&review.Content,
 			&review.Rating,
+			&review.Version,
+			&review.UpdatedAt,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
+		review.BookId = book_id
 		reviews = append(reviews, &review)
+		sortKeys = append(sortKeys, sortKey)
+		ids = append(ids, review.ID)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
+	// Past the last page no row carries the total, so it is counted apart.
+	if len(reviews) == 0 && filters.offset() > 0 {
+		query = `
+		select count(*) from reviews
+		join books on book_id=books.id
+		join users on user_id=users.id
+		where book_id=$1 and reviews.deleted_at is null`
+		if err = r.DB.QueryRowContext(ctx, query, book_id).Scan(&totalRecords); err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	metadata := filters.metadata(totalRecords, sortKeys, ids)
+	if len(reviews) > filters.Limit {
+		reviews = reviews[:filters.Limit]
+	}
+	return reviews, metadata, nil
+}
 
+// GetLatestForBooks returns up to limit of the most recent reviews of each of the
+// given books in a single query, keyed by book id.
+func (r ReviewModel) GetLatestForBooks(bookIDs []int64, limit int) (map[int64][]*Review, error) {
+	return r.getLatest("book_id", bookIDs, limit)
+}
+
+// GetLatestForUsers returns up to limit of the most recent reviews written by
+// each of the given users in a single query, keyed by user id.
+func (r ReviewModel) GetLatestForUsers(userIDs []int64, limit int) (map[int64][]*Review, error) {
+	return r.getLatest("user_id", userIDs, limit)
+}
+
+func (r ReviewModel) getLatest(column string, ids []int64, limit int) (map[int64][]*Review, error) {
+	query := fmt.Sprintf(`
+	select id, created_at, user_id, username, book_id, title, content, rating, version, updated_at from (
+		select reviews.id, created_at, user_id, username, book_id, title, content, rating, reviews.version, reviews.updated_at,
+			row_number() over (partition by reviews.%s order by created_at desc, reviews.id desc) as n
+		from reviews
+		join books on book_id=books.id
+		join users on user_id=users.id
+		where reviews.%s = any($1) and reviews.deleted_at is null
+	) as latest
+	where n <= $2
+	order by created_at desc, id desc`, column, column)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := r.DB.QueryContext(ctx, query, pq.Array(ids), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	reviews := make(map[int64][]*Review)
+	for rows.Next() {
+		var review Review
+		err := rows.Scan(
+			&review.ID,
+			&review.CreatedAt,
+			&review.AuthorId,
+			&review.AuthorUsername,
+			&review.BookId,
+			&review.BookTitle,
+			&review.Content,
+			&review.Rating,
+			&review.Version,
+			&review.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		key := review.BookId
+		if column == "user_id" {
+			key = review.AuthorId
+		}
+		reviews[key] = append(reviews[key], &review)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
 	return reviews, nil
 }
 
 func (r ReviewModel) Update(review *Review) error {
 	query := `
 	UPDATE reviews
-	SET content=$1, rating=$2
-	where id=$3
-	returning id`
-	err := r.DB.QueryRow(query, review.Content, review.Rating, review.ID).Scan()
-	if errors.Is(err, sql.ErrNoRows) {
-		return ErrEditConflict
+	SET content=$1, rating=$2, version = version + 1, updated_at = NOW()
+	where id=$3 and version=$4 and deleted_at is null
+	returning version, updated_at`
+	args := []interface{}{review.Content, review.Rating, review.ID, review.Version}
+	err := r.DB.QueryRow(query, args...).Scan(&review.Version, &review.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
 	}
 	return nil
 }
+
+// Export calls fn with every review in id order, streaming them from the
+// database. It stops at the first error fn returns.
+func (r ReviewModel) Export(ctx context.Context, fn func(*Review) error) error {
+	query := `
+	select reviews.id, created_at, user_id, username, book_id, title, content, rating, reviews.version, reviews.updated_at
+	from reviews
+	join books on book_id=books.id
+	join users on user_id=users.id
+	where reviews.deleted_at is null
+	order by reviews.id`
+	return streamCursor(ctx, r.DB, query, func(rows *sql.Rows) error {
+		var review Review
+		err := rows.Scan(
+			&review.ID,
+			&review.CreatedAt,
+			&review.AuthorId,
+			&review.AuthorUsername,
+			&review.BookId,
+			&review.BookTitle,
+			&review.Content,
+			&review.Rating,
+			&review.Version,
+			&review.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		return fn(&review)
+	})
+}
+
+// Freshness summarizes the reviews of a book, or of every book when bookID is
+// 0, for revalidating cached listings.
+func (r ReviewModel) Freshness(bookID int64) (Freshness, error) {
+	query := `
+	select count(*), coalesce(max(updated_at), 'epoch') from reviews
+	where ($1::bigint = 0 or book_id = $1)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var f Freshness
+	err := r.DB.QueryRowContext(ctx, query, bookID).Scan(&f.Count, &f.LastModified)
+	return f, err
+}
+
+// Delete moves the review a user wrote of a book to the trash.
 func (r ReviewModel) Delete(book_id int64, user_id int64) error {
 	query := `
-		DELETE FROM reviews
-		WHERE book_id = $1 and user_id=$2`
+		UPDATE reviews
+		SET deleted_at = NOW(), version = version + 1, updated_at = NOW()
+		WHERE book_id = $1 and user_id=$2 and deleted_at is null`
 	result, err := r.DB.Exec(query, book_id, user_id)
 	if err != nil {
 		return err
@@ -139,18 +293,167 @@ func (r ReviewModel) Delete(book_id int64, user_id int64) error {
 	return nil
 }
 
-func ValidateRating(v *validator.Validator, rating int) {
-	v.Check(rating > 0, "raing", "must be an integer between 1 and 5 ")
-	v.Check(rating <= 5, "raing", "must be an integer between 1 and 5 ")
+// GetTrashed returns a page of the reviews in the trash, including those that
+// went there with their book or their author.
+func (r ReviewModel) GetTrashed(filters Filters) ([]*Review, Metadata, error) {
+	sortExpr := "reviews." + filters.sortColumn()
+	countExpr := "count(*) over()"
+	if filters.Cursor != "" {
+		countExpr = "0"
+	}
+	keyset, keysetArgs := filters.keyset(sortExpr, "reviews.id", 3)
+	query := fmt.Sprintf(`
+	select %s, (%s)::text, reviews.id, created_at, user_id, username, book_id, title, content, rating,
+		reviews.version, reviews.updated_at, reviews.deleted_at
+	from reviews
+	join books on book_id=books.id
+	join users on user_id=users.id
+	where reviews.deleted_at is not null
+	and %s
+	order by %s %s, reviews.id asc
+	limit $1 offset $2`, countExpr, sortExpr, keyset, sortExpr, filters.sortDirection())
 
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	args := append([]interface{}{filters.limit(), filters.offset()}, keysetArgs...)
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	reviews := []*Review{}
+	var sortKeys []string
+	var ids []int64
+	for rows.Next() {
+		var review Review
+		var sortKey string
+		err := rows.Scan(
+			&totalRecords,
+			&sortKey,
+			&review.ID,
+			&review.CreatedAt,
+			&review.AuthorId,
+			&review.AuthorUsername,
+			&review.BookId,
+			&review.BookTitle,
+			&review.Content,
+			&review.Rating,
+			&review.Version,
+			&review.UpdatedAt,
+			&review.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		reviews = append(reviews, &review)
+		sortKeys = append(sortKeys, sortKey)
+		ids = append(ids, review.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := filters.metadata(totalRecords, sortKeys, ids)
+	if len(reviews) > filters.Limit {
+		reviews = reviews[:filters.Limit]
+	}
+	return reviews, metadata, nil
+}
+
+// Restore takes a review out of the trash. A review whose book or author is in
+// the trash is reported as ErrInTrash, and one whose author has reviewed the
+// book again since as ErrEditConflict.
+func (r ReviewModel) Restore(id int64) (*Review, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	select books.deleted_at is not null or users.deleted_at is not null,
+		exists (select 1 from reviews as live
+			where live.book_id = reviews.book_id and live.user_id = reviews.user_id and live.deleted_at is null)
+	from reviews
+	join books on book_id=books.id
+	join users on user_id=users.id
+	where reviews.id = $1 and reviews.deleted_at is not null
+	for update of reviews`
+	var inTrash, reviewedAgain bool
+	err = tx.QueryRowContext(ctx, query, id).Scan(&inTrash, &reviewedAgain)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	switch {
+	case inTrash:
+		return nil, ErrInTrash
+	case reviewedAgain:
+		return nil, ErrEditConflict
+	}
+
+	query = `
+	with restored as (
+		update reviews
+		set deleted_at = null, trashed_with = null, version = version + 1, updated_at = NOW()
+		where id = $1
+		returning *
+	)
+	select restored.id, created_at, user_id, username, book_id, title, content, rating, restored.version, restored.updated_at
+	from restored
+	join books on book_id=books.id
+	join users on user_id=users.id`
+	var review Review
+	err = tx.QueryRowContext(ctx, query, id).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.AuthorId,
+		&review.AuthorUsername,
+		&review.BookId,
+		&review.BookTitle,
+		&review.Content,
+		&review.Rating,
+		&review.Version,
+		&review.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// Purge permanently deletes the reviews that went to the trash before a time
+// and returns how many there were.
+func (r ReviewModel) Purge(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM reviews WHERE deleted_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func ValidateRating(v *validator.Validator, rating int) {
+	v.Var("rating", rating, "min=1,max=5")
 }
 
 func ValidateContent(v *validator.Validator, content string) {
-	v.Check(len(content) >= 50, "content", "must be at least 50 bytes long")
-	v.Check(len(content) <= 1000, "password", "must not be more than 1000 bytes long")
+	v.Var("content", content, "min=50,max=1000")
 }
 
 func ValidateReview(v *validator.Validator, review *Review) {
-	ValidateContent(v, review.Content)
-	ValidateRating(v, review.Rating)
+	v.Struct(review)
 }
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestReviewsGetAllCountsEveryPage(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("from reviews", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"count", "sort_key", "id", "created_at", "username", "title", "content", "rating", "version", "updated_at"},
+			Rows:    [][]driver.Value{{int64(21), "2024-01-01", int64(5), time.Now(), "reader", "Dune", "A classic.", int64(5), int64(1), time.Now()}},
+		}
+	})
+	reviews, metadata, err := ReviewModel{DB: conn}.GetAll(1, Filters{Page: 2, Limit: 20, Sort: "-created_at", SortSafelist: []string{"-created_at"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reviews) != 1 || metadata.TotalRecords != 21 || metadata.LastPage != 2 {
+		t.Errorf("got %d reviews and %+v, want one review of 21 on the last of 2 pages", len(reviews), metadata)
+	}
+}
+
+// A page past the last one has no row to carry the total, which is then
+// counted on its own.
+func TestReviewsGetAllCountsPastTheLastPage(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("select count(*) from reviews", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"count"}, Rows: [][]driver.Value{{int64(21)}}}
+	})
+	reviews, metadata, err := ReviewModel{DB: conn}.GetAll(1, Filters{Page: 3, Limit: 20, Sort: "-created_at", SortSafelist: []string{"-created_at"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reviews) != 0 || metadata.TotalRecords != 21 || metadata.LastPage != 2 {
+		t.Errorf("got %d reviews and %+v, want none of 21 past the last of 2 pages", len(reviews), metadata)
+	}
+
+	// The first page being empty means there is nothing to count.
+	conn, db = newStubDB(t)
+	if _, _, err := (ReviewModel{DB: conn}).GetAll(1, Filters{Page: 1, Limit: 20, Sort: "-created_at", SortSafelist: []string{"-created_at"}}); err != nil {
+		t.Fatal(err)
+	}
+	if statements := db.Statements(); len(statements) != 1 {
+		t.Errorf("statements = %q, want the page only", statements)
+	}
+}
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+type RevisionModel struct {
+	DB *sql.DB
+}
+
+// Revision is a change to a book, recorded by the database whenever a book is
+// created, updated, deleted or restored. User is the username of whoever made it, or nil
+// when nobody was signed in or the user no longer exists.
+type Revision struct {
+	ID        int64                  `json:"id"`
+	BookID    int64                  `json:"book_id"`
+	Version   int32                  `json:"version"`
+	Action    string                 `json:"action"`
+	User      *string                `json:"user"`
+	Changes   map[string]FieldChange `json:"changes"`
+	CreatedAt time.Time              `json:"created_at"`
+	// Snapshot holds the editable fields of the book as the revision left it.
+	Snapshot RevisionSnapshot `json:"-"`
+}
+
+// FieldChange is the value of a field before and after a revision. Either is
+// null when the revision created, deleted or restored the book.
+type FieldChange struct {
+	Old json.RawMessage `json:"old"`
+	New json.RawMessage `json:"new"`
+}
+
+// RevisionSnapshot holds the fields a revert restores.
+type RevisionSnapshot struct {
+	Title       string   `json:"title"`
+	Author      string   `json:"author"`
+	Year        int32    `json:"year"`
+	Description string   `json:"description"`
+	Genres      []string `json:"genres"`
+	Language    string   `json:"language"`
+}
+
+// ApplyTo sets the fields of book to the ones in the snapshot.
+func (s RevisionSnapshot) ApplyTo(book *Book) {
+	book.Title = s.Title
+	book.Author = s.Author
+	book.Year = s.Year
+	book.Description = s.Description
+	book.Genres = s.Genres
+	book.Language = s.Language
+}
+
+// setEditor attributes the revisions recorded by the rest of tx to a user. A
+// userID of 0 leaves them unattributed.
+func setEditor(ctx context.Context, tx execer, userID int64) error {
+	if userID == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `SELECT set_config('capybook.user_id', $1, true)`, strconv.FormatInt(userID, 10))
+	return err
+}
+
+const revisionColumns = `book_revisions.id, book_revisions.book_id, book_revisions.version, book_revisions.action,
+	users.username, book_revisions.changes, book_revisions.snapshot, book_revisions.created_at`
+
+func (revision *Revision) fields(changes, snapshot *[]byte) []interface{} {
+	return []interface{}{
+		&revision.ID,
+		&revision.BookID,
+		&revision.Version,
+		&revision.Action,
+		&revision.User,
+		changes,
+		snapshot,
+		&revision.CreatedAt,
+	}
+}
+
+func (revision *Revision) decode(changes, snapshot []byte) error {
+	if err := json.Unmarshal(changes, &revision.Changes); err != nil {
+		return err
+	}
+	return json.Unmarshal(snapshot, &revision.Snapshot)
+}
+
+// GetAll returns a page of the revisions of a book, including those of a book
+// that has since been deleted.
+func (m RevisionModel) GetAll(bookID int64, filters Filters) ([]*Revision, Metadata, error) {
+	sortExpr := "book_revisions." + filters.sortColumn()
+	countExpr := "count(*) OVER()"
+	if filters.Cursor != "" {
+		countExpr = "0"
+	}
+	keyset, keysetArgs := filters.keyset(sortExpr, "book_revisions.id", 4)
+	query := fmt.Sprintf(`
+	SELECT %s, (%s)::text, %s
+	FROM book_revisions
+	LEFT JOIN users ON users.id = book_revisions.user_id
+	WHERE book_revisions.book_id = $1
+	AND %s
+	ORDER BY %s %s, book_revisions.id ASC
+	LIMIT $2 OFFSET $3`, countExpr, sortExpr, revisionColumns, keyset, sortExpr, filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	args := append([]interface{}{bookID, filters.limit(), filters.offset()}, keysetArgs...)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	revisions := []*Revision{}
+	var sortKeys []string
+	var ids []int64
+	for rows.Next() {
+		var revision Revision
+		var sortKey string
+		var changes, snapshot []byte
+		err := rows.Scan(append([]interface{}{&totalRecords, &sortKey}, revision.fields(&changes, &snapshot)...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if err = revision.decode(changes, snapshot); err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, &revision)
+		sortKeys = append(sortKeys, sortKey)
+		ids = append(ids, revision.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := filters.metadata(totalRecords, sortKeys, ids)
+	if len(revisions) > filters.Limit {
+		revisions = revisions[:filters.Limit]
+	}
+	return revisions, metadata, nil
+}
+
+// Get returns a revision of a book.
+func (m RevisionModel) Get(bookID, id int64) (*Revision, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT ` + revisionColumns + `
+	FROM book_revisions
+	LEFT JOIN users ON users.id = book_revisions.user_id
+	WHERE book_revisions.book_id = $1 AND book_revisions.id = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var revision Revision
+	var changes, snapshot []byte
+	err := m.DB.QueryRowContext(ctx, query, bookID, id).Scan(revision.fields(&changes, &snapshot)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if err = revision.decode(changes, snapshot); err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// Freshness summarizes the revisions of a book for revalidating cached
+// listings. Revisions are never changed, only added.
+func (m RevisionModel) Freshness(bookID int64) (Freshness, error) {
+	query := `SELECT count(*), coalesce(max(created_at), 'epoch') FROM book_revisions WHERE book_id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var f Freshness
+	err := m.DB.QueryRowContext(ctx, query, bookID).Scan(&f.Count, &f.LastModified)
+	return f, err
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetEditorSkipsAnonymousEdits(t *testing.T) {
+	conn, db := newStubDB(t)
+	if err := setEditor(context.Background(), conn, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := db.Statements(); len(got) != 0 {
+		t.Errorf("an anonymous edit ran %q", got)
+	}
+	var user interface{}
+	db.On("set_config", func(args []driver.NamedValue) stubResult {
+		user = args[0].Value
+		return stubResult{}
+	})
+	if err := setEditor(context.Background(), conn, 7); err != nil {
+		t.Fatal(err)
+	}
+	if user != "7" {
+		t.Errorf("set the editor to %v, want 7", user)
+	}
+}
+
+func TestGetRevisionDecodesChangesAndSnapshot(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("FROM book_revisions", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"id", "book_id", "version", "action", "username", "changes", "snapshot", "created_at"},
+			Rows: [][]driver.Value{{
+				int64(3), int64(1), int64(2), "update", "alice",
+				[]byte(`{"year": {"old": 1965, "new": 1966}}`),
+				[]byte(`{"title": "Dune", "author": "Frank Herbert", "year": 1966, "genres": ["novel"], "language": "english"}`),
+				time.Now(),
+			}},
+		}
+	})
+	revision, err := RevisionModel{DB: conn}.Get(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision.User == nil || *revision.User != "alice" {
+		t.Errorf("user = %v, want alice", revision.User)
+	}
+	if change := revision.Changes["year"]; string(change.Old) != "1965" || string(change.New) != "1966" {
+		t.Errorf("year changed from %s to %s, want 1965 to 1966", change.Old, change.New)
+	}
+	if revision.Snapshot.Year != 1966 || !reflect.DeepEqual(revision.Snapshot.Genres, []string{"novel"}) {
+		t.Errorf("snapshot = %+v", revision.Snapshot)
+	}
+	if _, err := (RevisionModel{DB: conn}).Get(1, 0); err != ErrRecordNotFound {
+		t.Errorf("revision 0: got %v, want ErrRecordNotFound", err)
+	}
+	for _, statement := range db.Statements() {
+		if !strings.Contains(statement, "book_revisions.id = $2") {
+			t.Errorf("unexpected statement %q", statement)
+		}
+	}
+}
+
+func TestRevisionSnapshotApplyTo(t *testing.T) {
+	book := &Book{ID: 1, Title: "Dune Messiah", Year: 1969, Version: 4}
+	RevisionSnapshot{Title: "Dune", Author: "Frank Herbert", Year: 1965, Genres: []string{"novel"}, Language: "english"}.ApplyTo(book)
+	if book.Title != "Dune" || book.Year != 1965 || book.Author != "Frank Herbert" || book.Language != "english" {
+		t.Errorf("book = %+v", book)
+	}
+	if book.ID != 1 || book.Version != 4 {
+		t.Errorf("the snapshot replaced the identity of the book: %+v", book)
+	}
+}
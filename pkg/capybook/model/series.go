@@ -0,0 +1,192 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+var (
+	ErrUnknownSeries     = errors.New("unknown series")
+	ErrDuplicatePosition = errors.New("duplicate series position")
+)
+
+type SeriesModel struct {
+	DB *sql.DB
+}
+
+type Series struct {
+	ID          int64     `json:"id"`
+	Title       string    `json:"title" validate:"required,max=200"`
+	Description string    `json:"description,omitempty" validate:"max=2000"`
+	Version     int32     `json:"version"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// BookSeries is the series a book belongs to, as embedded in the book.
+type BookSeries struct {
+	ID       int64   `json:"id"`
+	Title    string  `json:"title"`
+	Position float64 `json:"position"`
+	// Version and UpdatedAt are those of the series, which can be renamed
+	// without the book changing.
+	Version   int32     `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// seriesColumn builds the BookSeries of a book as a JSON object, or NULL for
+// books outside any series.
+const seriesColumn = `(SELECT json_build_object('id', series.id, 'title', series.title, 'position', books.series_position,
+	'version', series.version, 'updated_at', series.updated_at)
+	FROM series WHERE series.id = books.series_id)`
+
+// seriesScanner scans seriesColumn into a book.
+type seriesScanner struct {
+	series **BookSeries
+}
+
+func (s seriesScanner) Scan(src interface{}) error {
+	*s.series = nil
+	var data []byte
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		data = src
+	case string:
+		data = []byte(src)
+	default:
+		return fmt.Errorf("cannot scan %T into a book series", src)
+	}
+	var series struct {
+		BookSeries
+		Version   int32     `json:"version"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+	if err := json.Unmarshal(data, &series); err != nil {
+		return err
+	}
+	series.BookSeries.Version = series.Version
+	series.BookSeries.UpdatedAt = series.UpdatedAt
+	*s.series = &series.BookSeries
+	return nil
+}
+
+// Volume is a book of a series, with how it is rated.
+type Volume struct {
+	BookID        int64   `json:"book_id"`
+	Title         string  `json:"title"`
+	Author        string  `json:"author"`
+	Year          int32   `json:"year"`
+	Position      float64 `json:"position"`
+	Reviews       int     `json:"reviews"`
+	AverageRating float64 `json:"average_rating"`
+}
+
+func (m SeriesModel) Insert(series *Series) error {
+	query := `
+	INSERT INTO series (title, description)
+	VALUES ($1, $2)
+	RETURNING id, version, updated_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, series.Title, series.Description).
+		Scan(&series.ID, &series.Version, &series.UpdatedAt)
+}
+
+func (m SeriesModel) Get(id int64) (*Series, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+	SELECT id, title, description, version, updated_at
+	FROM series
+	WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var series Series
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&series.ID,
+		&series.Title,
+		&series.Description,
+		&series.Version,
+		&series.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &series, nil
+}
+
+func (m SeriesModel) Update(series *Series) error {
+	query := `
+	UPDATE series
+	SET title = $1, description = $2, version = version + 1, updated_at = NOW()
+	WHERE id = $3 AND version = $4
+	RETURNING version, updated_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, series.Title, series.Description, series.ID, series.Version).
+		Scan(&series.Version, &series.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Volumes lists the books of a series in reading order, each with its own
+// review count and average rating.
+func (m SeriesModel) Volumes(seriesID int64) ([]*Volume, error) {
+	query := `
+	SELECT books.id, books.title, books.author, books.year, books.series_position,
+		count(reviews.id), coalesce(avg(reviews.rating), 0)
+	FROM books
+	LEFT JOIN reviews ON reviews.book_id = books.id AND reviews.deleted_at IS NULL
+	WHERE books.series_id = $1 AND NOT books.pending AND books.deleted_at IS NULL
+	GROUP BY books.id
+	ORDER BY books.series_position, books.id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	volumes := []*Volume{}
+	for rows.Next() {
+		var volume Volume
+		err := rows.Scan(
+			&volume.BookID,
+			&volume.Title,
+			&volume.Author,
+			&volume.Year,
+			&volume.Position,
+			&volume.Reviews,
+			&volume.AverageRating,
+		)
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, &volume)
+	}
+	return volumes, rows.Err()
+}
+
+func ValidateSeries(v *validator.Validator, series *Series) {
+	v.Struct(series)
+}
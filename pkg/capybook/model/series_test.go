@@ -0,0 +1,55 @@
+package model
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+func TestSeriesScanner(t *testing.T) {
+	tests := []struct {
+		src  interface{}
+		want *BookSeries
+	}{
+		{nil, nil},
+		{[]byte(`{"id": 2, "title": "Dune", "position": 1.5}`), &BookSeries{ID: 2, Title: "Dune", Position: 1.5}},
+		{`{"id": 3, "title": "Discworld", "position": 41}`, &BookSeries{ID: 3, Title: "Discworld", Position: 41}},
+	}
+	for _, tt := range tests {
+		got := &BookSeries{ID: 99}
+		if err := (seriesScanner{&got}).Scan(tt.src); err != nil {
+			t.Fatal(err)
+		}
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("Scan(%v) = %+v, want %+v", tt.src, got, tt.want)
+		}
+	}
+	var series *BookSeries
+	if err := (seriesScanner{&series}).Scan(int64(1)); err == nil {
+		t.Error("scanned a number into a series")
+	}
+}
+
+func TestSetSeriesReportsUnknownSeriesAndTakenPositions(t *testing.T) {
+	tests := []struct {
+		err  string
+		want error
+	}{
+		{`pq: insert or update on table "books" violates foreign key constraint "books_series_id_fkey"`, ErrUnknownSeries},
+		{`pq: duplicate key value violates unique constraint "books_series_position_idx"`, ErrDuplicatePosition},
+	}
+	for _, tt := range tests {
+		conn, db := newStubDB(t)
+		db.On("UPDATE books", func([]driver.NamedValue) stubResult {
+			return stubResult{Err: errors.New(tt.err)}
+		})
+		if _, err := (BookModel{DB: conn}).SetSeries(1, 2, 1.5, 7); !errors.Is(err, tt.want) {
+			t.Errorf("got %v, want %v", err, tt.want)
+		}
+	}
+
+	conn, _ := newStubDB(t)
+	if _, err := (BookModel{DB: conn}).SetSeries(1, 2, 1.5, 7); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v for a missing book, want ErrRecordNotFound", err)
+	}
+}
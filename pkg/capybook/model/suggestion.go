@@ -0,0 +1,218 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	SuggestionPending  = "pending"
+	SuggestionApproved = "approved"
+	SuggestionRejected = "rejected"
+)
+
+// SuggestionStatuses lists the states a suggestion goes through.
+var SuggestionStatuses = []string{SuggestionPending, SuggestionApproved, SuggestionRejected}
+
+// ErrAlreadyReviewed is returned when deciding a suggestion that is no longer
+// pending.
+var ErrAlreadyReviewed = errors.New("suggestion already reviewed")
+
+type SuggestionModel struct {
+	DB *sql.DB
+}
+
+// BookSuggestion is a change to the catalog proposed by a user, either a new
+// book (when BookID is nil) or an edit of one. Changes holds the fields of the
+// new book, or the fields of the book to change as a merge patch. User and
+// Reviewer are usernames.
+type BookSuggestion struct {
+	ID            int64           `json:"id"`
+	BookID        *int64          `json:"book_id"`
+	Changes       json.RawMessage `json:"changes"`
+	Comment       string          `json:"comment"`
+	Status        string          `json:"status"`
+	User          string          `json:"user"`
+	Reviewer      *string         `json:"reviewer"`
+	ReviewComment string          `json:"review_comment"`
+	CreatedAt     time.Time       `json:"created_at"`
+	ReviewedAt    *time.Time      `json:"reviewed_at"`
+	UserID        int64           `json:"-"`
+	ReviewerID    int64           `json:"-"`
+	// Locale is the one the suggestion was made in, for notifying the user.
+	Locale string `json:"-"`
+	// BookTitle is the title of the book to change as it was when the
+	// suggestion was decided.
+	BookTitle string `json:"-"`
+}
+
+const suggestionColumns = `suggestions.id, suggestions.book_id, suggestions.changes, suggestions.comment,
+	suggestions.status, submitters.username, reviewers.username, suggestions.review_comment,
+	suggestions.created_at, suggestions.reviewed_at, suggestions.user_id, suggestions.locale`
+
+const suggestionTables = `suggestions
+	JOIN users AS submitters ON submitters.id = suggestions.user_id AND submitters.deleted_at IS NULL
+	LEFT JOIN users AS reviewers ON reviewers.id = suggestions.reviewer_id`
+
+func (s *BookSuggestion) fields() []interface{} {
+	return []interface{}{
+		&s.ID,
+		&s.BookID,
+		&s.Changes,
+		&s.Comment,
+		&s.Status,
+		&s.User,
+		&s.Reviewer,
+		&s.ReviewComment,
+		&s.CreatedAt,
+		&s.ReviewedAt,
+		&s.UserID,
+		&s.Locale,
+	}
+}
+
+func (m SuggestionModel) Insert(s *BookSuggestion) error {
+	query := `
+	INSERT INTO suggestions (user_id, book_id, changes, comment, locale)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, status, created_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, s.UserID, s.BookID, []byte(s.Changes), s.Comment, s.Locale).
+		Scan(&s.ID, &s.Status, &s.CreatedAt)
+	if err != nil {
+		if err.Error() == `pq: insert or update on table "suggestions" violates foreign key constraint "suggestions_book_id_fkey"` {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (m SuggestionModel) Get(id int64) (*BookSuggestion, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + suggestionColumns + ` FROM ` + suggestionTables + ` WHERE suggestions.id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var s BookSuggestion
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(s.fields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+// GetAll returns a page of the suggestions made by a user, or by everyone when
+// userID is 0, optionally only those with the given status.
+func (m SuggestionModel) GetAll(userID int64, status string, filters Filters) ([]*BookSuggestion, Metadata, error) {
+	sortExpr := "suggestions." + filters.sortColumn()
+	countExpr := "count(*) OVER()"
+	if filters.Cursor != "" {
+		countExpr = "0"
+	}
+	keyset, keysetArgs := filters.keyset(sortExpr, "suggestions.id", 5)
+	query := fmt.Sprintf(`
+	SELECT %s, (%s)::text, %s
+	FROM %s
+	WHERE ($1 = 0 OR suggestions.user_id = $1)
+	AND ($2 = '' OR suggestions.status = $2)
+	AND %s
+	ORDER BY %s %s, suggestions.id ASC
+	LIMIT $3 OFFSET $4`, countExpr, sortExpr, suggestionColumns, suggestionTables, keyset, sortExpr, filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	args := append([]interface{}{userID, status, filters.limit(), filters.offset()}, keysetArgs...)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	suggestions := []*BookSuggestion{}
+	var sortKeys []string
+	var ids []int64
+	for rows.Next() {
+		var s BookSuggestion
+		var sortKey string
+		err := rows.Scan(append([]interface{}{&totalRecords, &sortKey}, s.fields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		suggestions = append(suggestions, &s)
+		sortKeys = append(sortKeys, sortKey)
+		ids = append(ids, s.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := filters.metadata(totalRecords, sortKeys, ids)
+	if len(suggestions) > filters.Limit {
+		suggestions = suggestions[:filters.Limit]
+	}
+	return suggestions, metadata, nil
+}
+
+// Decide records the Status, ReviewerID and ReviewComment of s, if s is still
+// pending. An approved suggestion is applied in the same transaction, in the
+// name of the user who made it: book is inserted when s suggests a new book,
+// and saved over the current one otherwise. The book as stored is returned.
+// A suggestion someone else reviewed in the meantime is reported as
+// ErrAlreadyReviewed, and a book changed since it was read as ErrEditConflict.
+func (m SuggestionModel) Decide(s *BookSuggestion, book *Book) (*Book, error) {
+	query := `
+	UPDATE suggestions
+	SET status = $2, reviewer_id = $3, review_comment = $4, reviewed_at = NOW()
+	WHERE id = $1 AND status = 'pending'
+	RETURNING reviewed_at, coalesce((SELECT title FROM books WHERE books.id = suggestions.book_id), '')`
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRowContext(ctx, query, s.ID, s.Status, s.ReviewerID, s.ReviewComment).Scan(&s.ReviewedAt, &s.BookTitle)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrAlreadyReviewed
+		default:
+			return nil, err
+		}
+	}
+	if s.Status == SuggestionApproved {
+		if s.BookID == nil {
+			if err = insertBook(ctx, tx, book, s.UserID); err != nil {
+				return nil, err
+			}
+			_, err = tx.ExecContext(ctx, `UPDATE suggestions SET book_id = $2 WHERE id = $1`, s.ID, book.ID)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			book, err = updateBook(ctx, tx, book, s.UserID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	if s.Status == SuggestionApproved && s.BookID == nil {
+		s.BookID = &book.ID
+	}
+	return book, nil
+}
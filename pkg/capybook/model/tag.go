@@ -0,0 +1,340 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+var (
+	ErrBannedTag  = errors.New("tag is banned")
+	ErrRemovedTag = errors.New("tag was removed from the book")
+)
+
+type TagModel struct {
+	DB *sql.DB
+}
+
+// Tag is a free-form label users attach to books. Books counts the books it
+// is attached to.
+type Tag struct {
+	ID     int64  `json:"id"`
+	Key    string `json:"key"`
+	Name   string `json:"name"`
+	Banned bool   `json:"banned"`
+	Books  int    `json:"books"`
+}
+
+// BookTag is a tag as attached to a book. Relevance is the share of the users
+// who voted on any tag of the book that voted for this one, and Voted tells
+// whether the requesting user did.
+type BookTag struct {
+	Key       string  `json:"key"`
+	Name      string  `json:"name"`
+	Votes     int     `json:"votes"`
+	Relevance float64 `json:"relevance"`
+	Voted     bool    `json:"voted"`
+}
+
+// TagKey normalizes a tag name: it is lowercased and every run of characters
+// other than letters and digits becomes a single hyphen, so "Found Family"
+// and "found_family" are both found-family.
+func TagKey(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, "-")
+}
+
+// taggedWith is the condition, completed by a quoted key, that a book carries
+// a tag that is neither banned nor removed from it.
+const taggedWith = `SELECT 1 FROM book_tags JOIN tags ON tags.id = book_tags.tag_id
+		WHERE book_tags.book_id = books.id AND book_tags.removed_at IS NULL AND NOT tags.banned
+		AND tags.key = `
+
+// Add attaches the tag named name to a book on behalf of a user, counting as
+// their vote for it. Adding a tag the book already has is a vote for it.
+func (m TagModel) Add(bookID, userID int64, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO tags (key, name)
+	VALUES ($1, trim($2))
+	ON CONFLICT (key) DO UPDATE SET key = EXCLUDED.key
+	RETURNING id, banned`
+	var tagID int64
+	var banned bool
+	if err = tx.QueryRowContext(ctx, query, TagKey(name), name).Scan(&tagID, &banned); err != nil {
+		return err
+	}
+	if banned {
+		return ErrBannedTag
+	}
+	query = `
+	INSERT INTO book_tags (book_id, tag_id)
+	VALUES ($1, $2)
+	ON CONFLICT (book_id, tag_id) DO UPDATE SET updated_at = NOW()
+	RETURNING removed_at IS NOT NULL`
+	var removed bool
+	err = tx.QueryRowContext(ctx, query, bookID, tagID).Scan(&removed)
+	if err != nil {
+		if strings.Contains(err.Error(), "book_tags_book_id_fkey") {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	if removed {
+		return ErrRemovedTag
+	}
+	query = `
+	INSERT INTO tag_votes (book_id, tag_id, user_id)
+	VALUES ($1, $2, $3)
+	ON CONFLICT DO NOTHING`
+	if _, err = tx.ExecContext(ctx, query, bookID, tagID, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Vote records a user's vote for a tag the book already carries. Voting twice
+// is the same as voting once.
+func (m TagModel) Vote(bookID, userID int64, key string) error {
+	query := `
+	WITH target AS (
+		SELECT book_tags.tag_id FROM book_tags
+		JOIN tags ON tags.id = book_tags.tag_id
+		WHERE book_tags.book_id = $1 AND tags.key = $2 AND book_tags.removed_at IS NULL AND NOT tags.banned
+	), voted AS (
+		INSERT INTO tag_votes (book_id, tag_id, user_id)
+		SELECT $1, tag_id, $3 FROM target
+		ON CONFLICT DO NOTHING
+		RETURNING tag_id
+	), touched AS (
+		UPDATE book_tags SET updated_at = NOW()
+		WHERE book_id = $1 AND tag_id IN (SELECT tag_id FROM voted)
+	)
+	SELECT EXISTS (SELECT 1 FROM target)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var found bool
+	if err := m.DB.QueryRowContext(ctx, query, bookID, key, userID).Scan(&found); err != nil {
+		return err
+	}
+	if !found {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Unvote withdraws a user's vote for a tag of a book. A tag nobody votes for
+// any more is detached from the book.
+func (m TagModel) Unvote(bookID, userID int64, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	DELETE FROM tag_votes
+	USING tags
+	WHERE tags.id = tag_votes.tag_id AND tag_votes.book_id = $1 AND tags.key = $2 AND tag_votes.user_id = $3
+	RETURNING tag_votes.tag_id`
+	var tagID int64
+	err = tx.QueryRowContext(ctx, query, bookID, key, userID).Scan(&tagID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	// Removed tags stay attached, so that they can't be added again.
+	query = `
+	DELETE FROM book_tags
+	WHERE book_id = $1 AND tag_id = $2 AND removed_at IS NULL
+	AND NOT EXISTS (SELECT 1 FROM tag_votes WHERE book_id = $1 AND tag_id = $2)`
+	result, err := tx.ExecContext(ctx, query, bookID, tagID)
+	if err != nil {
+		return err
+	}
+	detached, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if detached == 0 {
+		query = `UPDATE book_tags SET updated_at = NOW() WHERE book_id = $1 AND tag_id = $2`
+		if _, err = tx.ExecContext(ctx, query, bookID, tagID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Remove takes a tag off a book for good: it is hidden, and users can't
+// attach it to the book again.
+func (m TagModel) Remove(bookID int64, key string) error {
+	query := `
+	UPDATE book_tags SET removed_at = NOW(), updated_at = NOW()
+	FROM tags
+	WHERE tags.id = book_tags.tag_id AND book_tags.book_id = $1 AND tags.key = $2
+	AND book_tags.removed_at IS NULL`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query, bookID, key)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetBanned bans a tag from every book, or lifts the ban. Banned tags are
+// hidden and can't be attached to books.
+func (m TagModel) SetBanned(key string, banned bool) (*Tag, error) {
+	query := `
+	UPDATE tags SET banned = $2, updated_at = NOW()
+	WHERE key = $1
+	RETURNING id, key, name, banned,
+		(SELECT count(*) FROM book_tags WHERE tag_id = tags.id AND removed_at IS NULL)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var tag Tag
+	err := m.DB.QueryRowContext(ctx, query, key, banned).Scan(&tag.ID, &tag.Key, &tag.Name, &tag.Banned, &tag.Books)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &tag, nil
+}
+
+// Search returns the tags whose key starts with the key of prefix, most used
+// first. With banned set it returns the banned tags instead.
+func (m TagModel) Search(prefix string, banned bool, limit int) ([]*Tag, error) {
+	query := `
+	SELECT tags.id, tags.key, tags.name, tags.banned, count(book_tags.book_id)
+	FROM tags
+	LEFT JOIN book_tags ON book_tags.tag_id = tags.id AND book_tags.removed_at IS NULL
+		AND book_tags.book_id IN (SELECT id FROM books WHERE deleted_at IS NULL)
+	WHERE tags.key LIKE $1 AND tags.banned = $2
+	GROUP BY tags.id
+	HAVING count(book_tags.book_id) > 0 OR tags.banned
+	ORDER BY 5 DESC, tags.key
+	LIMIT $3`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, likePrefix(TagKey(prefix)), banned, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []*Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Key, &tag.Name, &tag.Banned, &tag.Books); err != nil {
+			return nil, err
+		}
+		tags = append(tags, &tag)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// GetForBooks returns the visible tags of each of the given books, most voted
+// first, keyed by book id. A limit of 0 returns every tag. Voted is reported
+// for userID, which may be 0 for nobody.
+func (m TagModel) GetForBooks(ids []int64, userID int64, limit int) (map[int64][]*BookTag, error) {
+	query := `
+	WITH votes AS (
+		SELECT book_tags.book_id, tags.key, tags.name, tag_votes.user_id
+		FROM book_tags
+		JOIN tags ON tags.id = book_tags.tag_id
+		JOIN tag_votes ON tag_votes.book_id = book_tags.book_id AND tag_votes.tag_id = book_tags.tag_id
+		WHERE book_tags.book_id = ANY($1) AND book_tags.removed_at IS NULL AND NOT tags.banned
+	), voters AS (
+		SELECT book_id, count(DISTINCT user_id) AS users FROM votes GROUP BY book_id
+	), ranked AS (
+		SELECT votes.book_id, votes.key, votes.name, count(*) AS votes,
+			round(count(*)::numeric / voters.users, 2)::float8 AS relevance,
+			bool_or(votes.user_id = $2) AS voted,
+			row_number() OVER (PARTITION BY votes.book_id ORDER BY count(*) DESC, votes.key) AS rank
+		FROM votes
+		JOIN voters ON voters.book_id = votes.book_id
+		GROUP BY votes.book_id, votes.key, votes.name, voters.users
+	)
+	SELECT book_id, key, name, votes, relevance, voted
+	FROM ranked
+	WHERE $3 = 0 OR rank <= $3
+	ORDER BY book_id, rank`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(ids), userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := make(map[int64][]*BookTag)
+	for rows.Next() {
+		var bookID int64
+		var tag BookTag
+		err := rows.Scan(&bookID, &tag.Key, &tag.Name, &tag.Votes, &tag.Relevance, &tag.Voted)
+		if err != nil {
+			return nil, err
+		}
+		tags[bookID] = append(tags[bookID], &tag)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// Freshness summarizes the tags of a book, or of every book when bookID is 0,
+// for revalidating cached responses.
+func (m TagModel) Freshness(bookID int64) (Freshness, error) {
+	query := `
+	SELECT (SELECT count(*) FROM tag_votes WHERE $1 = 0 OR book_id = $1),
+		greatest(
+			(SELECT coalesce(max(updated_at), 'epoch') FROM book_tags WHERE $1 = 0 OR book_id = $1),
+			(SELECT coalesce(max(updated_at), 'epoch') FROM tags))`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var f Freshness
+	err := m.DB.QueryRowContext(ctx, query, bookID).Scan(&f.Count, &f.LastModified)
+	return f, err
+}
+
+// ValidateTagName checks the name of a tag being attached to a book.
+func ValidateTagName(v *validator.Validator, name string) {
+	v.Var("name", name, "required,max=50")
+	if !v.Has("name") && TagKey(name) == "" {
+		v.Fail("name", "invalid_tag", "must contain a letter or digit")
+	}
+}
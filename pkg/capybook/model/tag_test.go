@@ -0,0 +1,93 @@
+package model
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shyndaliu/capybook/pkg/capybook/validator"
+)
+
+func TestTagKey(t *testing.T) {
+	tests := map[string]string{
+		"Found Family":       "found-family",
+		"found_family":       "found-family",
+		"  Sci-Fi!!  ":       "sci-fi",
+		"19th century":       "19th-century",
+		"Тёмное фэнтези":     "тёмное-фэнтези",
+		"enemies->lovers":    "enemies-lovers",
+		"---":                "",
+		"Agatha  Christie's": "agatha-christie-s",
+	}
+	for name, want := range tests {
+		if got := TagKey(name); got != want {
+			t.Errorf("TagKey(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestValidateTagName(t *testing.T) {
+	tests := map[string]string{
+		"cozy":                  "",
+		"":                      "required",
+		"?!":                    "invalid_tag",
+		strings.Repeat("a", 51): "too_long",
+	}
+	for name, code := range tests {
+		v := validator.New()
+		ValidateTagName(v, name)
+		got := ""
+		if len(v.Errors) > 0 {
+			got = v.Errors[0].Code
+		}
+		if got != code {
+			t.Errorf("ValidateTagName(%q) failed with %q, want %q", name, got, code)
+		}
+	}
+}
+
+func TestAddTagVotesForIt(t *testing.T) {
+	conn, db := newStubDB(t)
+	var key interface{}
+	db.On("INSERT INTO tags", func(args []driver.NamedValue) stubResult {
+		key = args[0].Value
+		return stubResult{Columns: []string{"id", "banned"}, Rows: [][]driver.Value{{int64(4), false}}}
+	})
+	db.On("INSERT INTO book_tags", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"removed"}, Rows: [][]driver.Value{{false}}}
+	})
+	if err := (TagModel{DB: conn}).Add(1, 7, " Found Family "); err != nil {
+		t.Fatal(err)
+	}
+	if key != "found-family" {
+		t.Errorf("got key %v, want found-family", key)
+	}
+	var got []string
+	for _, statement := range db.Statements() {
+		for _, want := range []string{"INSERT INTO tags", "INSERT INTO book_tags", "INSERT INTO tag_votes", "COMMIT"} {
+			if strings.Contains(statement, want) {
+				got = append(got, want)
+			}
+		}
+	}
+	if want := []string{"INSERT INTO tags", "INSERT INTO book_tags", "INSERT INTO tag_votes", "COMMIT"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got statements %q, want %q", got, want)
+	}
+}
+
+func TestAddBannedTag(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("INSERT INTO tags", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"id", "banned"}, Rows: [][]driver.Value{{int64(4), true}}}
+	})
+	if err := (TagModel{DB: conn}).Add(1, 7, "spoilers"); !errors.Is(err, ErrBannedTag) {
+		t.Fatalf("got %v, want ErrBannedTag", err)
+	}
+	for _, statement := range db.Statements() {
+		if strings.Contains(statement, "book_tags") || statement == "COMMIT" {
+			t.Errorf("a banned tag went on to %q", statement)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/shyndaliu/capybook/internal/stubdb"
+)
+
+// stubResult is what a stubbed statement answers with.
+type stubResult = stubdb.Result
+
+// stubDB answers the statements the models under test send. Any statement
+// without a stub answers with no rows.
+type stubDB = stubdb.DB
+
+// newStubDB returns a connection to a new stub database.
+func newStubDB(t *testing.T) (*sql.DB, *stubDB) {
+	t.Helper()
+	return stubdb.Open(t)
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/shyndaliu/capybook/pkg/capybook/validator"
@@ -18,12 +19,13 @@ type UserModel struct {
 var AnonymousUser = &User{}
 
 type User struct {
-	ID        int64    `json:"-"`
-	Username  string   `json:"username"`
-	Email     string   `json:"email"`
-	Password  password `json:"-"`
-	TokenHash string   `json:"-"`
-	Activated bool     `json:"-"`
+	ID        int64      `json:"-"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	Password  password   `json:"-"`
+	TokenHash string     `json:"-"`
+	Activated bool       `json:"-"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 type password struct {
 	plaintext *string
@@ -66,15 +68,17 @@ func (u UserModel) Insert(user *User) error {
 
 func (m UserModel) GetByID(id int64) (*User, error) {
 	query := `
-	SELECT id, username, email, password
+	SELECT id, username, email, password, token_hash, activated
 	FROM users
-	WHERE id = $1`
+	WHERE id = $1 AND deleted_at IS NULL`
 	var user User
 	err := m.DB.QueryRow(query, id).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
 		&user.Password.hash,
+		&user.TokenHash,
+		&user.Activated,
 	)
 	if err != nil {
 		switch {
@@ -89,15 +93,17 @@ func (m UserModel) GetByID(id int64) (*User, error) {
 
 func (m UserModel) GetByUsername(username string) (*User, error) {
 	query := `
-	SELECT id, username, email, password
+	SELECT id, username, email, password, token_hash, activated
 	FROM users
-	WHERE username = lower($1)`
+	WHERE username = lower($1) AND deleted_at IS NULL`
 	var user User
 	err := m.DB.QueryRow(query, username).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
 		&user.Password.hash,
+		&user.TokenHash,
+		&user.Activated,
 	)
 	if err != nil {
 		switch {
@@ -112,15 +118,17 @@ func (m UserModel) GetByUsername(username string) (*User, error) {
 
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-	SELECT id, username, email, password
+	SELECT id, username, email, password, token_hash, activated
 	FROM users
-	WHERE email = $1`
+	WHERE email = $1 AND deleted_at IS NULL`
 	var user User
 	err := m.DB.QueryRow(query, email).Scan(
 		&user.ID,
 		&user.Username,
 		&user.Email,
 		&user.Password.hash,
+		&user.TokenHash,
+		&user.Activated,
 	)
 	if err != nil {
 		switch {
@@ -136,12 +144,13 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 func (u UserModel) GetByVerificationCode(plaintext string) (*User, error) {
 	hash := sha256.Sum256([]byte(plaintext))
 	query := `
-	SELECT users.id, users.username, users.email, users.password, users.activated
+	SELECT users.id, users.username, users.email, users.password, users.token_hash, users.activated
 	FROM users
 	INNER JOIN verifications
 	ON users.id = verifications.user_id
 	WHERE verifications.code = $1
-	AND verifications.expiry > $2`
+	AND verifications.expiry > $2
+	AND users.deleted_at IS NULL`
 	args := []interface{}{hash[:], time.Now()}
 	var user User
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -151,6 +160,7 @@ func (u UserModel) GetByVerificationCode(plaintext string) (*User, error) {
 		&user.Username,
 		&user.Email,
 		&user.Password.hash,
+		&user.TokenHash,
 		&user.Activated,
 	)
 	if err != nil {
@@ -173,7 +183,8 @@ func (u UserModel) GetByAuthToken(plaintext string) (*User, error) {
 	INNER JOIN temporary
 	ON users.id = temporary.user_id
 	WHERE temporary.code = $1
-	AND temporary.expiry > $2`
+	AND temporary.expiry > $2
+	AND users.deleted_at IS NULL`
 	args := []interface{}{hash[:], time.Now()}
 	var user User
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -201,7 +212,7 @@ func (m UserModel) Update(user *User) error {
 	query := `
 	UPDATE users
 	SET username = $1, email = $2, password = $3, activated = $4, token_hash=$5
-	WHERE id = $6 
+	WHERE id = $6 AND deleted_at IS NULL
 	RETURNING id`
 	args := []interface{}{
 		user.Username,
@@ -211,29 +222,160 @@ func (m UserModel) Update(user *User) error {
 		user.TokenHash,
 		user.ID,
 	}
-	err := m.DB.QueryRow(query, args...).Scan()
-	if errors.Is(err, sql.ErrNoRows) {
-		return ErrEditConflict
+	err := m.DB.QueryRow(query, args...).Scan(&user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
 	}
 	return nil
 }
 
+// Delete moves a user to the trash, along with their reviews. Their username
+// and email stay taken until they are purged.
 func (u UserModel) Delete(username string) error {
-	query := `
-		DELETE FROM users
-		WHERE username = $1`
-	result, err := u.DB.Exec(query, username)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := u.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	rowsAffected, err := result.RowsAffected()
+	defer tx.Rollback()
+	query := `
+		UPDATE users
+		SET deleted_at = NOW()
+		WHERE username = $1 AND deleted_at IS NULL
+		RETURNING id`
+	var id int64
+	err = tx.QueryRowContext(ctx, query, username).Scan(&id)
 	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	// The reviews go to the trash with the user, and come back with them.
+	query = `
+		UPDATE reviews
+		SET deleted_at = NOW(), trashed_with = 'user', version = version + 1, updated_at = NOW()
+		WHERE user_id = $1 AND deleted_at IS NULL`
+	if _, err = tx.ExecContext(ctx, query, id); err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	return tx.Commit()
+}
+
+// GetTrashed returns a page of the users in the trash.
+func (u UserModel) GetTrashed(filters Filters) ([]*User, Metadata, error) {
+	sortExpr := filters.sortColumn()
+	countExpr := "count(*) OVER()"
+	if filters.Cursor != "" {
+		countExpr = "0"
 	}
-	return nil
+	keyset, keysetArgs := filters.keyset(sortExpr, "id", 3)
+	query := fmt.Sprintf(`
+	SELECT %s, (%s)::text, id, username, email, deleted_at
+	FROM users
+	WHERE deleted_at IS NOT NULL
+	AND %s
+	ORDER BY %s %s, id ASC
+	LIMIT $1 OFFSET $2`, countExpr, sortExpr, keyset, sortExpr, filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	args := append([]interface{}{filters.limit(), filters.offset()}, keysetArgs...)
+	rows, err := u.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	users := []*User{}
+	var sortKeys []string
+	var ids []int64
+	for rows.Next() {
+		var user User
+		var sortKey string
+		err := rows.Scan(&totalRecords, &sortKey, &user.ID, &user.Username, &user.Email, &user.DeletedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		users = append(users, &user)
+		sortKeys = append(sortKeys, sortKey)
+		ids = append(ids, user.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	metadata := filters.metadata(totalRecords, sortKeys, ids)
+	if len(users) > filters.Limit {
+		users = users[:filters.Limit]
+	}
+	return users, metadata, nil
+}
+
+// Restore takes a user out of the trash, along with the reviews that went to
+// the trash with them, except those of books that are in the trash, which are
+// left to come back with the book.
+func (u UserModel) Restore(username string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	tx, err := u.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	query := `
+	UPDATE users
+	SET deleted_at = NULL
+	WHERE username = $1 AND deleted_at IS NOT NULL
+	RETURNING id, username, email`
+	var user User
+	err = tx.QueryRowContext(ctx, query, username).Scan(&user.ID, &user.Username, &user.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	// Reviews of books still in the trash stay there, and come back with the
+	// book instead.
+	query = `
+	UPDATE reviews
+	SET deleted_at = CASE WHEN books.deleted_at IS NULL THEN NULL ELSE reviews.deleted_at END,
+		trashed_with = CASE WHEN books.deleted_at IS NULL THEN NULL ELSE 'book' END,
+		version = reviews.version + 1, updated_at = NOW()
+	FROM books
+	WHERE books.id = reviews.book_id AND reviews.user_id = $1 AND reviews.trashed_with = 'user'`
+	if _, err = tx.ExecContext(ctx, query, user.ID); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Purge permanently deletes the users that went to the trash before a time,
+// with their reviews and everything else of theirs, and returns how many there
+// were.
+func (u UserModel) Purge(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := u.DB.ExecContext(ctx, `DELETE FROM users WHERE deleted_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 func (p *password) Set(plaintextPassword string) error {
@@ -259,17 +401,14 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	v.Var("email", email, "required,email")
 }
 func ValidateUsername(v *validator.Validator, username string) {
-	v.Check(username != "", "username", "must be provided")
-	v.Check(validator.Matches(username, validator.UsernameRX), "username", "must be a valid username")
+	v.Var("username", username, "required,username")
 }
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	// bcrypt only looks at the first 72 bytes, whatever the number of characters.
+	v.Var("password", password, "required,min=8,maxbytes=72")
 }
 
 func ValidateEmailOrUsername(v *validator.Validator, username string, email string) {
@@ -277,7 +416,9 @@ func ValidateEmailOrUsername(v *validator.Validator, username string, email stri
 	ValidateUsername(v1, username)
 	v2 := validator.New()
 	ValidateEmail(v2, email)
-	v.Check(v1.Valid() || v2.Valid(), "error", "valid username or email must be provided")
+	if !v1.Valid() && !v2.Valid() {
+		v.Fail("error", "identity_required", "valid username or email must be provided")
+	}
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+// Updating a user writes back its token hash and activation, so every lookup
+// that may precede an update has to read them.
+func TestUserLookupsReadTheTokenHashAndActivation(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("FROM users", func([]driver.NamedValue) stubResult {
+		return stubResult{
+			Columns: []string{"id", "username", "email", "password", "token_hash", "activated"},
+			Rows:    [][]driver.Value{{int64(7), "reader", "reader@example.com", []byte("hash"), "token-hash", true}},
+		}
+	})
+	users := UserModel{DB: conn}
+	lookups := map[string]func() (*User, error){
+		"GetByID":       func() (*User, error) { return users.GetByID(7) },
+		"GetByUsername": func() (*User, error) { return users.GetByUsername("reader") },
+		"GetByEmail":    func() (*User, error) { return users.GetByEmail("reader@example.com") },
+	}
+	for name, lookup := range lookups {
+		user, err := lookup()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if user.TokenHash != "token-hash" || !user.Activated {
+			t.Errorf("%s read token hash %q and activated %v", name, user.TokenHash, user.Activated)
+		}
+	}
+}
+
+// A review that went to the trash with a book whose author was trashed since
+// must come back with the author, whenever each of them is restored; matching
+// reviews by the time they were trashed strands it.
+func TestRestoreUserBringsBackTheReviewsTrashedWithThem(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("UPDATE users", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"id", "username", "email"}, Rows: [][]driver.Value{{int64(7), "reader", "reader@example.com"}}}
+	})
+	var args []driver.NamedValue
+	db.On("UPDATE reviews", func(a []driver.NamedValue) stubResult {
+		args = a
+		return stubResult{}
+	})
+	if _, err := (UserModel{DB: conn}).Restore("reader"); err != nil {
+		t.Fatal(err)
+	}
+	var query string
+	for _, statement := range db.Statements() {
+		if strings.Contains(statement, "UPDATE reviews") {
+			query = statement
+		}
+	}
+	if len(args) != 1 || args[0].Value != int64(7) {
+		t.Errorf("restored reviews by %v, want by user 7 alone", args)
+	}
+	for _, want := range []string{"trashed_with = 'user'", "ELSE 'book'"} {
+		if !strings.Contains(query, want) {
+			t.Errorf("restoring reviews does not use %q:\n%s", want, query)
+		}
+	}
+}
+
+func TestDeleteUserRecordsWhyReviewsWereTrashed(t *testing.T) {
+	conn, db := newStubDB(t)
+	db.On("UPDATE users", func([]driver.NamedValue) stubResult {
+		return stubResult{Columns: []string{"id"}, Rows: [][]driver.Value{{int64(7)}}}
+	})
+	if err := (UserModel{DB: conn}).Delete("reader"); err != nil {
+		t.Fatal(err)
+	}
+	var trashed bool
+	for _, statement := range db.Statements() {
+		if strings.Contains(statement, "UPDATE reviews") && strings.Contains(statement, "trashed_with = 'user'") {
+			trashed = true
+		}
+	}
+	if !trashed {
+		t.Errorf("the reviews of the user were not trashed with them: %v", db.Statements())
+	}
+}
@@ -77,6 +77,5 @@ func (v VerificationModel) Delete(userID int64) error {
 }
 
 func ValidateVerificationCode(v *validator.Validator, plainTextCode string) {
-	v.Check(plainTextCode != "", "code", "must be provided")
-	v.Check(len(plainTextCode) == 26, "code", "must be 26 bytes long")
+	v.Var("code", plainTextCode, "required,len=26")
 }
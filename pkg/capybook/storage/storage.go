@@ -0,0 +1,102 @@
+// Package storage keeps uploaded files, such as book covers, on the local
+// filesystem or in an S3-compatible object store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Storage stores objects under slash-separated keys and tells where they are
+// served from.
+type Storage interface {
+	// Put stores data under key, replacing any object already there.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Delete removes the object under key. Missing objects are not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the address the object under key is served from.
+	URL(key string) string
+}
+
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// Local stores objects as files below a directory. It serves them itself, so
+// it can be mounted at the path of its base URL.
+type Local struct {
+	dir     string
+	baseURL string
+}
+
+func NewLocal(dir, baseURL string) *Local {
+	return &Local{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// path returns the file an object is kept in, refusing keys that would lead
+// outside the directory.
+func (l *Local) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if key == "" || clean != "/"+key {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(clean)), nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// Readers never see a partly written file: it is written next to its
+	// final name and then renamed over it.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) URL(key string) string {
+	return l.baseURL + "/" + key
+}
+
+// ServeHTTP serves the stored objects, with the request path as the key.
+// Directories are not listed, and files whose name starts with a dot, such as
+// uploads still being written, are not served.
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/") || strings.HasPrefix(path.Base(r.URL.Path), ".") {
+		http.NotFound(w, r)
+		return
+	}
+	http.FileServer(http.Dir(l.dir)).ServeHTTP(w, r)
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	local := NewLocal(dir, "/files/")
+	ctx := context.Background()
+
+	if err := local.Put(ctx, "covers/1/small.jpg", []byte("first"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Put(ctx, "covers/1/small.jpg", []byte("second"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if got := local.URL("covers/1/small.jpg"); got != "/files/covers/1/small.jpg" {
+		t.Errorf("got URL %s, want /files/covers/1/small.jpg", got)
+	}
+	res := get(t, local, "/covers/1/small.jpg")
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || string(body) != "second" {
+		t.Errorf("got %d %q, want 200 \"second\"", res.StatusCode, body)
+	}
+	// No temporary file is left behind.
+	entries, err := os.ReadDir(filepath.Join(dir, "covers", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files, want only small.jpg", len(entries))
+	}
+
+	if err := local.Delete(ctx, "covers/1/small.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if res := get(t, local, "/covers/1/small.jpg"); res.StatusCode != http.StatusNotFound {
+		t.Errorf("got %d after delete, want 404", res.StatusCode)
+	}
+	if err := local.Delete(ctx, "covers/1/small.jpg"); err != nil {
+		t.Errorf("deleting a missing object: %v", err)
+	}
+}
+
+func TestLocalRejectsKeysOutsideItsDirectory(t *testing.T) {
+	local := NewLocal(t.TempDir(), "/files")
+	for _, key := range []string{"", "../escape", "covers/../../escape", "/absolute", "covers//double"} {
+		if err := local.Put(context.Background(), key, []byte("x"), "text/plain"); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q): got %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func TestLocalHidesUploadsInProgress(t *testing.T) {
+	dir := t.TempDir()
+	local := NewLocal(dir, "/files")
+	if err := os.MkdirAll(filepath.Join(dir, "covers"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "covers", ".upload-123"), []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/covers/.upload-123", "/covers/"} {
+		if res := get(t, local, path); res.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s: got %d, want 404", path, res.StatusCode)
+		}
+	}
+}
+
+func get(t *testing.T, handler http.Handler, path string) *http.Response {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w.Result()
+}
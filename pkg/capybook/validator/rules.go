@@ -0,0 +1,175 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Rule checks a single value. param is whatever followed "=" in the tag, e.g.
+// "200" for max=200. It returns nil when the value passes, otherwise the code
+// and message of the failure; the validator fills in the field name.
+type Rule func(value reflect.Value, param string) *FieldError
+
+var (
+	rulesMu sync.RWMutex
+	rules   = map[string]Rule{
+		"required": required,
+		"min":      minRule,
+		"max":      maxRule,
+		"len":      lenRule,
+		"maxbytes": maxBytes,
+		"oneof":    oneOf,
+		"unique":   unique,
+		"email":    matchRule(EmailRX, "invalid_email", "must be a valid email address"),
+		"username": matchRule(UsernameRX, "invalid_username", "must be a valid username"),
+	}
+)
+
+// Register makes a custom rule available to `validate` tags under name. It
+// panics if the name is already taken, as rules are meant to be registered once
+// from init functions.
+func Register(name string, rule Rule) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	if _, exists := rules[name]; exists {
+		panic("validator: rule " + name + " registered twice")
+	}
+	rules[name] = rule
+}
+
+func lookup(name string) (Rule, bool) {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	rule, ok := rules[name]
+	return rule, ok
+}
+
+// failure is a shorthand for building the result of a failed rule.
+func failure(code, message string) *FieldError {
+	return &FieldError{Code: code, Message: message}
+}
+
+// size returns what min, max and len compare against: the number of runes of a
+// string, the number of elements of a slice or map, or the value of a number.
+func size(value reflect.Value) (float64, string) {
+	value = indirect(value)
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), "string"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(value.Len()), "list"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), "number"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), "number"
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), "number"
+	}
+	panic(fmt.Sprintf("validator: cannot measure a %s", value.Kind()))
+}
+
+func parseParam(rule, param string) float64 {
+	n, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validator: %s needs a numeric parameter, got %q", rule, param))
+	}
+	return n
+}
+
+// required rejects zero values. A slice only has to be present: use min=1 to
+// also reject empty ones.
+func required(value reflect.Value, _ string) *FieldError {
+	empty := isEmpty(value)
+	if value.Kind() == reflect.Slice {
+		empty = value.IsNil()
+	}
+	if empty {
+		return failure("required", "must be provided")
+	}
+	return nil
+}
+
+func minRule(value reflect.Value, param string) *FieldError {
+	n, kind := size(value)
+	if n >= parseParam("min", param) {
+		return nil
+	}
+	switch kind {
+	case "string":
+		return failure("too_short", fmt.Sprintf("must be at least %s characters long", param))
+	case "list":
+		return failure("too_few", fmt.Sprintf("must contain at least %s items", param))
+	}
+	return failure("too_small", fmt.Sprintf("must be at least %s", param))
+}
+
+func maxRule(value reflect.Value, param string) *FieldError {
+	n, kind := size(value)
+	if n <= parseParam("max", param) {
+		return nil
+	}
+	switch kind {
+	case "string":
+		return failure("too_long", fmt.Sprintf("must not be more than %s characters long", param))
+	case "list":
+		return failure("too_many", fmt.Sprintf("must not contain more than %s items", param))
+	}
+	return failure("too_large", fmt.Sprintf("must be at most %s", param))
+}
+
+func lenRule(value reflect.Value, param string) *FieldError {
+	n, kind := size(value)
+	if n == parseParam("len", param) {
+		return nil
+	}
+	if kind == "list" {
+		return &FieldError{Code: "wrong_length", Unit: "items", Message: fmt.Sprintf("must contain exactly %s items", param)}
+	}
+	return failure("wrong_length", fmt.Sprintf("must be exactly %s characters long", param))
+}
+
+// maxBytes limits the encoded size of a string, for values that end up in
+// byte-limited places such as bcrypt.
+func maxBytes(value reflect.Value, param string) *FieldError {
+	if float64(len(indirect(value).String())) <= parseParam("maxbytes", param) {
+		return nil
+	}
+	return &FieldError{Code: "too_long", Unit: "bytes", Message: fmt.Sprintf("must not be more than %s bytes long", param)}
+}
+
+// oneOf accepts any of the space-separated values in param.
+func oneOf(value reflect.Value, param string) *FieldError {
+	allowed := strings.Fields(param)
+	if In(fmt.Sprint(indirect(value).Interface()), allowed...) {
+		return nil
+	}
+	list := strings.Join(allowed, ", ")
+	return &FieldError{Code: "not_allowed", Param: list, Message: "must be one of " + list}
+}
+
+func unique(value reflect.Value, _ string) *FieldError {
+	value = indirect(value)
+	seen := make(map[interface{}]bool)
+	for i := 0; i < value.Len(); i++ {
+		elem := value.Index(i).Interface()
+		if seen[elem] {
+			return failure("duplicate", "must not contain duplicate values")
+		}
+		seen[elem] = true
+	}
+	return nil
+}
+
+func matchRule(rx *regexp.Regexp, code, message string) Rule {
+	return func(value reflect.Value, _ string) *FieldError {
+		if rx.MatchString(indirect(value).String()) {
+			return nil
+		}
+		return failure(code, message)
+	}
+}
@@ -1,11 +1,26 @@
 package validator
 
 import (
+	"fmt"
+	"reflect"
 	"regexp"
+	"strings"
 )
 
+// FieldError describes a single failed check. Code is stable and meant for
+// machines; Message is meant for people.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+	// Unit tells apart the messages of a code that depends on what was
+	// measured, such as "items" or "bytes" for wrong_length and too_long.
+	Unit string `json:"-"`
+}
+
 type Validator struct {
-	Errors map[string]string
+	Errors []FieldError
 }
 
 var (
@@ -14,17 +29,21 @@ var (
 )
 
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{}
 }
 
 func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
+// Fail records a failed check on key with the given code and message.
+func (v *Validator) Fail(key, code, message string) {
+	v.Errors = append(v.Errors, FieldError{Field: key, Code: code, Message: message})
+}
+
+// AddError records a failed check on key under the generic "invalid" code.
 func (v *Validator) AddError(key, message string) {
-	if _, exists := v.Errors[key]; !exists {
-		v.Errors[key] = message
-	}
+	v.Fail(key, "invalid", message)
 }
 
 func (v *Validator) Check(ok bool, key, message string) {
@@ -33,6 +52,142 @@ func (v *Validator) Check(ok bool, key, message string) {
 	}
 }
 
+// Has reports whether any check on key failed.
+func (v *Validator) Has(key string) bool {
+	for _, e := range v.Errors {
+		if e.Field == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Struct validates every field of the struct s points to against the rules in
+// its `validate` tag, e.g. `validate:"required,max=200"`. Fields are reported
+// under their JSON names; nested structs and slice elements get paths such as
+// "author.name" and "genres[2]".
+func (v *Validator) Struct(s interface{}) {
+	v.structValue("", reflect.ValueOf(s))
+}
+
+// Var validates a single value against a comma-separated list of rules,
+// reporting failures under key.
+func (v *Validator) Var(key string, value interface{}, tag string) {
+	v.value(key, reflect.ValueOf(value), tag)
+}
+
+func (v *Validator) structValue(prefix string, value reflect.Value) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validator: %s is not a struct", value.Type()))
+	}
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if tag := field.Tag.Get("validate"); tag != "" && tag != "-" {
+			v.value(name, value.Field(i), tag)
+		}
+		if isStruct(value.Field(i)) {
+			v.structValue(name, value.Field(i))
+		}
+	}
+}
+
+// value applies the rules in tag to value. Rules after "dive" are applied to
+// every element of a slice instead; "omitempty" skips the remaining rules when
+// the value is empty.
+func (v *Validator) value(key string, value reflect.Value, tag string) {
+	names := strings.Split(tag, ",")
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		param := ""
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, param = name[:eq], name[eq+1:]
+		}
+		switch name {
+		case "":
+			continue
+		case "omitempty":
+			if isEmpty(value) {
+				return
+			}
+			continue
+		case "dive":
+			elems := indirect(value)
+			if elems.Kind() != reflect.Slice && elems.Kind() != reflect.Array {
+				panic(fmt.Sprintf("validator: cannot dive into %s", key))
+			}
+			rest := strings.Join(names[i+1:], ",")
+			for j := 0; j < elems.Len(); j++ {
+				elemKey := fmt.Sprintf("%s[%d]", key, j)
+				v.value(elemKey, elems.Index(j), rest)
+				if isStruct(elems.Index(j)) {
+					v.structValue(elemKey, elems.Index(j))
+				}
+			}
+			return
+		}
+		rule, ok := lookup(name)
+		if !ok {
+			panic(fmt.Sprintf("validator: unknown rule %q on %s", name, key))
+		}
+		if failure := rule(value, param); failure != nil {
+			failure.Field = key
+			if failure.Param == "" {
+				failure.Param = param
+			}
+			v.Errors = append(v.Errors, *failure)
+			// Further rules would only pile up noise on a missing value.
+			if name == "required" {
+				return
+			}
+		}
+	}
+}
+
+func indirect(value reflect.Value) reflect.Value {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return value
+		}
+		value = value.Elem()
+	}
+	return value
+}
+
+func isStruct(value reflect.Value) bool {
+	value = indirect(value)
+	return value.Kind() == reflect.Struct && value.Type().PkgPath() != "time"
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.Slice, reflect.Map:
+		return value.IsNil() || value.Len() == 0
+	}
+	return value.IsZero()
+}
+
 func In(value string, list ...string) bool {
 	for i := range list {
 		if value == list[i] {
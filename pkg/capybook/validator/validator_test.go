@@ -0,0 +1,107 @@
+package validator
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// Codes are part of the API, so each rule is pinned to the code it reports.
+func TestRuleCodes(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		tag   string
+		code  string
+		unit  string
+	}{
+		{"", "required", "required", ""},
+		{"ab", "min=3", "too_short", ""},
+		{[]string{}, "min=1", "too_few", ""},
+		{2, "min=3", "too_small", ""},
+		{"abcd", "max=3", "too_long", ""},
+		{[]string{"a", "b"}, "max=1", "too_many", ""},
+		{4, "max=3", "too_large", ""},
+		{"ab", "len=3", "wrong_length", ""},
+		{[]string{"a"}, "len=2", "wrong_length", "items"},
+		{"ёж", "maxbytes=3", "too_long", "bytes"},
+		{"c", "oneof=a b", "not_allowed", ""},
+		{[]string{"a", "a"}, "unique", "duplicate", ""},
+		{"nope", "email", "invalid_email", ""},
+	}
+	for _, tt := range tests {
+		v := New()
+		v.Var("field", tt.value, tt.tag)
+		if len(v.Errors) != 1 {
+			t.Errorf("%v against %q: got errors %v, want one", tt.value, tt.tag, v.Errors)
+			continue
+		}
+		if e := v.Errors[0]; e.Field != "field" || e.Code != tt.code || e.Unit != tt.unit {
+			t.Errorf("%v against %q: got %s %q (unit %q), want %q (unit %q)", tt.value, tt.tag, e.Field, e.Code, e.Unit, tt.code, tt.unit)
+		}
+	}
+}
+
+func TestStructReportsJSONPaths(t *testing.T) {
+	var input struct {
+		Title  string   `json:"title" validate:"required"`
+		Genres []string `json:"genres" validate:"dive,max=3"`
+	}
+	input.Genres = []string{"sf", "fantasy"}
+	v := New()
+	v.Struct(&input)
+	if len(v.Errors) != 2 || v.Errors[0].Field != "title" || v.Errors[1].Field != "genres[1]" {
+		t.Errorf("got errors %v, want title and genres[1]", v.Errors)
+	}
+	if v.Errors[1].Param != "3" {
+		t.Errorf("got param %q, want the rule's parameter", v.Errors[1].Param)
+	}
+}
+
+func TestRequiredStopsTheOtherRules(t *testing.T) {
+	v := New()
+	v.Var("title", "", "required,min=3,max=10")
+	if len(v.Errors) != 1 || v.Errors[0].Code != "required" {
+		t.Errorf("got errors %v, want only required", v.Errors)
+	}
+}
+
+func TestOmitemptySkipsEmptyValues(t *testing.T) {
+	var input struct {
+		Year *int32 `json:"year" validate:"omitempty,min=1000"`
+	}
+	v := New()
+	v.Struct(&input)
+	if !v.Valid() {
+		t.Errorf("got errors %v for an omitted year", v.Errors)
+	}
+	year := int32(12)
+	input.Year = &year
+	v.Struct(&input)
+	if len(v.Errors) != 1 || v.Errors[0].Code != "too_small" {
+		t.Errorf("got errors %v, want too_small", v.Errors)
+	}
+}
+
+var registerEven sync.Once
+
+func TestRegister(t *testing.T) {
+	registerEven.Do(func() {
+		Register("even", func(value reflect.Value, _ string) *FieldError {
+			if value.Int()%2 == 0 {
+				return nil
+			}
+			return &FieldError{Code: "odd", Message: "must be even"}
+		})
+	})
+	v := New()
+	v.Var("count", 3, "even")
+	if len(v.Errors) != 1 || v.Errors[0].Code != "odd" {
+		t.Errorf("got errors %v, want odd", v.Errors)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a rule twice did not panic")
+		}
+	}()
+	Register("required", nil)
+}